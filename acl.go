@@ -0,0 +1,22 @@
+package s3vfs
+
+import "fmt"
+
+// cannedACLs are the canned ACLs that objects can be written with.
+var cannedACLs = map[string]bool{
+	"private":                   true,
+	"public-read":               true,
+	"public-read-write":         true,
+	"authenticated-read":        true,
+	"aws-exec-read":             true,
+	"bucket-owner-read":         true,
+	"bucket-owner-full-control": true,
+}
+
+// checkACL returns an error if acl is neither empty nor a known canned ACL.
+func checkACL(acl string) error {
+	if acl != "" && !cannedACLs[acl] {
+		return fmt.Errorf("unknown canned ACL %q", acl)
+	}
+	return nil
+}
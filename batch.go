@@ -0,0 +1,143 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// defaultBatchPutConcurrency is the number of workers BatchPut uses if
+// concurrency is not positive.
+const defaultBatchPutConcurrency = 8
+
+// PutEntry is an object to upload with BatchPut.
+type PutEntry struct {
+	Path string
+
+	// Body is read for the object's data. If Body is nil, Data is used.
+	Body io.Reader
+	Data []byte
+
+	Options *WriteOptions // object attributes (may be nil)
+}
+
+// BatchPut uploads the objects described by entries with a fixed pool of
+// concurrency workers, which is much cheaper than a goroutine (and write
+// buffer) per object when uploading many small objects. Each worker reuses
+// a single buffer for the bodies it reads, and objects are uploaded with a
+// single PUT unless they are larger than the part size (see
+// Options.PartSize).
+//
+// The returned slice has the error (or nil) of each entry, in the same order
+// as entries. The error result is only non-nil if no uploads could be
+// attempted at all (e.g., if the filesystem is read-only or closed). If ctx
+// is canceled, the entries not yet uploaded fail with the context's error.
+func (fs *S3FS) BatchPut(ctx context.Context, entries []PutEntry, concurrency int) ([]error, error) {
+	if fs.isClosed() {
+		return nil, ErrClosed
+	}
+	if fs.opt.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	errs := make([]error, len(entries))
+	fs.putAll(ctx, entries, concurrency, func(i int, err error) { errs[i] = err })
+	return errs, nil
+}
+
+// WriteFiles uploads the data read from each reader in files to the object
+// at its path, with at most concurrency (or, if it is not positive, a
+// default number of) uploads in progress at a time, as BatchPut does. The
+// first upload that fails cancels the others, and its error is returned.
+func (fs *S3FS) WriteFiles(ctx context.Context, files map[string]io.Reader, concurrency int) error {
+	if fs.isClosed() {
+		return ErrClosed
+	}
+	if fs.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	entries := make([]PutEntry, 0, len(files))
+	for path, r := range files {
+		entries = append(entries, PutEntry{Path: path, Body: r})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	var first error
+	fs.putAll(ctx, entries, concurrency, func(i int, err error) {
+		if err != nil {
+			once.Do(func() {
+				first = err
+				cancel()
+			})
+		}
+	})
+	return first
+}
+
+// putAll uploads entries with a pool of concurrency workers, calling done
+// (concurrently) with the index and error of each entry.
+func (fs *S3FS) putAll(ctx context.Context, entries []PutEntry, concurrency int, done func(i int, err error)) {
+	if concurrency <= 0 {
+		concurrency = defaultBatchPutConcurrency
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(entries); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			for i := range indexes {
+				err := fs.putEntry(ctx, &entries[i], &buf)
+				if _, ok := err.(*os.PathError); err != nil && !ok {
+					err = &os.PathError{Op: "put", Path: fs.url(entries[i].Path), Err: err}
+				}
+				done(i, err)
+			}
+		}()
+	}
+	for i := range entries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// putEntry uploads e, using buf to hold its body if it is read from a
+// reader.
+func (fs *S3FS) putEntry(ctx context.Context, e *PutEntry, buf *bytes.Buffer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.Options.check(); err != nil {
+		return err
+	}
+	w := fs.newWriter(e.Path, e.Options.header())
+	w.ctx = ctx
+	if e.Body == nil {
+		return w.putBody(bytes.NewReader(e.Data), int64(len(e.Data)))
+	}
+
+	// Read up to one byte more than a part, to tell whether the body fits
+	// in a single PUT.
+	buf.Reset()
+	if _, err := io.CopyN(buf, e.Body, fs.partSize()+1); err != nil && err != io.EOF {
+		return err
+	}
+	if int64(buf.Len()) <= fs.partSize() {
+		return w.putBody(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, e.Body); err != nil {
+		w.fail(err)
+		return err
+	}
+	return w.Close()
+}
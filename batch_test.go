@@ -0,0 +1,133 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestBatchPut(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readErr := errors.New("read failed")
+	entries := []PutEntry{
+		{Path: "a", Data: []byte("data"), Options: &WriteOptions{ContentType: "text/plain"}},
+		{Path: "b", Body: strings.NewReader("reader")},
+		{Path: "c", Body: strings.NewReader("larger than one part")},
+		{Path: "d", Body: iotest.ErrReader(readErr)},
+		{Path: "e"},
+	}
+	errs, err := fs.BatchPut(context.Background(), entries, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != len(entries) {
+		t.Fatalf("got %d errors, want %d", len(errs), len(entries))
+	}
+	for i, want := range []string{"data", "reader", "larger than one part", "", ""} {
+		path := entries[i].Path
+		if path == "d" {
+			if !errors.Is(errs[i], readErr) {
+				t.Errorf("%s: got error %v, want %v", path, errs[i], readErr)
+			}
+			if _, ok := f.get(path); ok {
+				t.Errorf("%s: object created despite error", path)
+			}
+			continue
+		}
+		if errs[i] != nil {
+			t.Errorf("%s: %s", path, errs[i])
+			continue
+		}
+		if o, ok := f.get(path); !ok || string(o.data) != want {
+			t.Errorf("%s: got object %v, want data %q", path, o, want)
+		}
+	}
+	if o, _ := f.get("a"); o.header.Get("Content-Type") != "text/plain" {
+		t.Errorf("a: got Content-Type %q", o.header.Get("Content-Type"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs, err = fs.BatchPut(ctx, []PutEntry{{Path: "x", Data: []byte("x")}}, 0)
+	if err != nil || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("canceled: got errors %v, %v, want per-entry context.Canceled", errs, err)
+	}
+}
+
+func TestBatchPut_readOnly(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := fs.BatchPut(context.Background(), []PutEntry{{Path: "a"}}, 1)
+	if err != ErrReadOnly || errs != nil {
+		t.Errorf("got %v, %v, want ErrReadOnly", errs, err)
+	}
+	if _, err := fs.Stat("a"); !os.IsNotExist(err) {
+		t.Errorf("got Stat error %v, want not exist", err)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	f := newFakeS3(t)
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		if inFlight++; inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(time.Millisecond)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	fs, err := New(f.bucketURL(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]io.Reader{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("f%d", i)] = strings.NewReader(fmt.Sprint(i))
+	}
+	if err := fs.WriteFiles(context.Background(), files, 4); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if o, ok := f.get(fmt.Sprintf("f%d", i)); !ok || string(o.data) != fmt.Sprint(i) {
+			t.Errorf("f%d: got object %v", i, o)
+		}
+	}
+	if maxInFlight > 4 {
+		t.Errorf("got %d concurrent requests, want at most 4", maxInFlight)
+	}
+
+	// The first failure is returned.
+	readErr := errors.New("read failed")
+	err = fs.WriteFiles(context.Background(), map[string]io.Reader{
+		"ok":  strings.NewReader("x"),
+		"bad": iotest.ErrReader(readErr),
+	}, 1)
+	if !errors.Is(err, readErr) {
+		t.Errorf("got error %v, want %v", err, readErr)
+	}
+}
@@ -0,0 +1,227 @@
+package s3vfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"strings"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// CachedFileSystem is a filesystem that caches the results of Stat, Lstat,
+// and ReadDir for a fixed time, to avoid repeated requests (e.g., a HEAD
+// request for each Stat) when the same paths are examined again and again,
+// as when walking trees. Only successful results are cached.
+//
+// Writes through the CachedFileSystem (Create, Mkdir, and Remove) invalidate
+// the cached results for the path and its parent directories. Changes made
+// by other clients of the underlying filesystem are not seen until the
+// cached results expire. It is safe for concurrent use.
+type CachedFileSystem struct {
+	rwvfs.FileSystem
+
+	ttl time.Duration
+
+	mu      sync.Mutex
+	stat    map[string]cachedInfo
+	lstat   map[string]cachedInfo
+	readDir map[string]cachedDir
+}
+
+type cachedInfo struct {
+	fi      os.FileInfo
+	expires time.Time
+}
+
+type cachedDir struct {
+	fis     []os.FileInfo
+	expires time.Time
+}
+
+// NewCached returns a filesystem that caches the metadata of fs for ttl.
+func NewCached(fs rwvfs.FileSystem, ttl time.Duration) *CachedFileSystem {
+	return &CachedFileSystem{
+		FileSystem: fs,
+		ttl:        ttl,
+		stat:       map[string]cachedInfo{},
+		lstat:      map[string]cachedInfo{},
+		readDir:    map[string]cachedDir{},
+	}
+}
+
+func (fs *CachedFileSystem) String() string {
+	return fmt.Sprintf("cached(%s)", fs.FileSystem)
+}
+
+// cacheKey returns the key that results for path are cached under, so that
+// equivalent paths (e.g., "a/b" and "/a/b/") share results.
+func cacheKey(path string) string {
+	return pathpkg.Clean("/" + path)
+}
+
+func (fs *CachedFileSystem) Stat(path string) (os.FileInfo, error) {
+	return fs.cachedStat(fs.stat, path, fs.FileSystem.Stat)
+}
+
+func (fs *CachedFileSystem) Lstat(path string) (os.FileInfo, error) {
+	return fs.cachedStat(fs.lstat, path, fs.FileSystem.Lstat)
+}
+
+func (fs *CachedFileSystem) cachedStat(cache map[string]cachedInfo, path string, stat func(string) (os.FileInfo, error)) (os.FileInfo, error) {
+	key := cacheKey(path)
+	fs.mu.Lock()
+	e, ok := cache[key]
+	if ok && time.Now().Before(e.expires) {
+		fs.mu.Unlock()
+		return e.fi, nil
+	}
+	delete(cache, key)
+	fs.mu.Unlock()
+
+	fi, err := stat(path)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	cache[key] = cachedInfo{fi: fi, expires: time.Now().Add(fs.ttl)}
+	fs.mu.Unlock()
+	return fi, nil
+}
+
+// ReadDir returns a copy of the cached listing of path, if any.
+func (fs *CachedFileSystem) ReadDir(path string) ([]os.FileInfo, error) {
+	key := cacheKey(path)
+	fs.mu.Lock()
+	e, ok := fs.readDir[key]
+	if ok && time.Now().Before(e.expires) {
+		fs.mu.Unlock()
+		return append([]os.FileInfo(nil), e.fis...), nil
+	}
+	delete(fs.readDir, key)
+	fs.mu.Unlock()
+
+	fis, err := fs.FileSystem.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.readDir[key] = cachedDir{fis: append([]os.FileInfo(nil), fis...), expires: time.Now().Add(fs.ttl)}
+	fs.mu.Unlock()
+	return fis, nil
+}
+
+// Invalidate discards the cached results for path and its parent
+// directories, whose listings (and, for implicit directories, existence)
+// may depend on it.
+func (fs *CachedFileSystem) Invalidate(path string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for key := cacheKey(path); ; key = pathpkg.Dir(key) {
+		delete(fs.stat, key)
+		delete(fs.lstat, key)
+		delete(fs.readDir, key)
+		if key == "/" {
+			break
+		}
+	}
+}
+
+// Create invalidates the cached results for path both when the file is
+// created and when it is closed, when the object is actually written.
+func (fs *CachedFileSystem) Create(path string) (io.WriteCloser, error) {
+	fs.Invalidate(path)
+	w, err := fs.FileSystem.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{WriteCloser: w, fs: fs, path: path}, nil
+}
+
+func (fs *CachedFileSystem) Mkdir(name string) error {
+	defer fs.Invalidate(name)
+	return fs.FileSystem.Mkdir(name)
+}
+
+func (fs *CachedFileSystem) Remove(name string) error {
+	defer fs.Invalidate(name)
+	return fs.FileSystem.Remove(name)
+}
+
+// Rename renames oldpath to newpath in the underlying filesystem, which
+// must have a Rename(oldpath, newpath string) error method (as *S3FS does),
+// and invalidates the cached results for both paths.
+func (fs *CachedFileSystem) Rename(oldpath, newpath string) error {
+	r, ok := fs.FileSystem.(interface {
+		Rename(oldpath, newpath string) error
+	})
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: fmt.Errorf("%s does not support Rename", fs.FileSystem)}
+	}
+	defer fs.Invalidate(oldpath)
+	defer fs.Invalidate(newpath)
+	return r.Rename(oldpath, newpath)
+}
+
+// RemoveAll removes path and everything under it in the underlying
+// filesystem, which must have a RemoveAll(path string) error method (as
+// *S3FS does), and invalidates the cached results for path, its parent
+// directories, and everything under it.
+func (fs *CachedFileSystem) RemoveAll(path string) error {
+	r, ok := fs.FileSystem.(interface {
+		RemoveAll(path string) error
+	})
+	if !ok {
+		return &os.PathError{Op: "removeall", Path: path, Err: fmt.Errorf("%s does not support RemoveAll", fs.FileSystem)}
+	}
+	defer fs.invalidateTree(path)
+	return r.RemoveAll(path)
+}
+
+// invalidateTree is like Invalidate, but also discards the cached results
+// for everything under path.
+func (fs *CachedFileSystem) invalidateTree(path string) {
+	fs.Invalidate(path)
+	prefix := strings.TrimSuffix(cacheKey(path), "/") + "/"
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for key := range fs.stat {
+		if strings.HasPrefix(key, prefix) {
+			delete(fs.stat, key)
+		}
+	}
+	for key := range fs.lstat {
+		if strings.HasPrefix(key, prefix) {
+			delete(fs.lstat, key)
+		}
+	}
+	for key := range fs.readDir {
+		if strings.HasPrefix(key, prefix) {
+			delete(fs.readDir, key)
+		}
+	}
+}
+
+// invalidatingWriter invalidates the cached results for path when it is
+// closed.
+type invalidatingWriter struct {
+	io.WriteCloser
+	fs   *CachedFileSystem
+	path string
+}
+
+// Abort aborts the underlying writer, if it has an Abort() error method.
+func (w *invalidatingWriter) Abort() error {
+	if a, ok := w.WriteCloser.(interface{ Abort() error }); ok {
+		return a.Abort()
+	}
+	return w.WriteCloser.Close()
+}
+
+func (w *invalidatingWriter) Close() error {
+	defer w.fs.Invalidate(w.path)
+	return w.WriteCloser.Close()
+}
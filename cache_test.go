@@ -0,0 +1,98 @@
+package s3vfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachedFileSystem(t *testing.T) {
+	f := newFakeS3(t)
+	fs := NewCached(f.fs(), time.Hour)
+	f.put("d/f", []byte("x"))
+
+	if _, err := fs.Stat("d/f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadDir("d"); err != nil {
+		t.Fatal(err)
+	}
+	f.reset()
+	if fi, err := fs.Stat("/d/f"); err != nil || fi.Size() != 1 {
+		t.Fatalf("got %v, %v, want cached info of size 1", fi, err)
+	}
+	if fis, err := fs.ReadDir("d/"); err != nil || len(fis) != 1 {
+		t.Fatalf("got %d entries, %v, want 1 cached entry", len(fis), err)
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests for cached results, want 0", n)
+	}
+
+	// A write to the path invalidates its info and its directory's listing.
+	createFile(t, fs, "d/f", []byte("xyz"))
+	createFile(t, fs, "d/g", []byte("y"))
+	if fi, err := fs.Stat("d/f"); err != nil || fi.Size() != 3 {
+		t.Errorf("after write: got %v, %v, want info of size 3", fi, err)
+	}
+	if fis, err := fs.ReadDir("d"); err != nil || len(fis) != 2 {
+		t.Errorf("after write: got %d entries, %v, want 2", len(fis), err)
+	}
+	if err := fs.Remove("d/f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("d/f"); err == nil {
+		t.Error("after Remove: got no error")
+	}
+	if _, err := fs.Stat("d/g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("d/g", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("d/g"); err == nil {
+		t.Error("after Rename: got no error for old path")
+	}
+	if err := fs.Rename("e", "d/g"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Results expire after the TTL.
+	fs = NewCached(f.fs(), time.Millisecond)
+	if _, err := fs.Stat("d/g"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	f.reset()
+	if _, err := fs.Stat("d/g"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.received()); n == 0 {
+		t.Error("got no requests after the TTL")
+	}
+}
+
+func TestCachedFileSystem_RemoveAll(t *testing.T) {
+	f := newFakeS3(t)
+	fs := NewCached(f.fs(), time.Hour)
+	f.put("d/s/f", []byte("x"))
+	f.put("d/g", []byte("y"))
+
+	for _, path := range []string{"d/s/f", "d/g"} {
+		if _, err := fs.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.ReadDir("d/s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("d"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"d/s/f", "d/g"} {
+		if _, err := fs.Stat(path); err == nil {
+			t.Errorf("after RemoveAll: got no error for %s", path)
+		}
+	}
+	if fis, err := fs.ReadDir("d/s"); err == nil && len(fis) != 0 {
+		t.Errorf("after RemoveAll: got %d cached entries", len(fis))
+	}
+}
@@ -0,0 +1,88 @@
+package s3vfs
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+
+	if _, err := fs.CompareAndSwap("c", 1, []byte("x")); err == nil {
+		t.Error("nonexistent object at generation 1: got nil error")
+	}
+	gen, err := fs.CompareAndSwap("c", 0, []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen != 1 {
+		t.Errorf("got generation %d, want 1", gen)
+	}
+	if gen, err = fs.CompareAndSwap("c", 1, []byte("v2")); err != nil || gen != 2 {
+		t.Fatalf("got generation %d, error %v, want 2", gen, err)
+	}
+
+	_, err = fs.CompareAndSwap("c", 1, []byte("stale"))
+	if e, ok := err.(*ConflictError); !ok || e.Expected != 1 || e.Actual != 2 {
+		t.Errorf("stale generation: got error %#v, want ConflictError at generation 2", err)
+	}
+	if o, _ := f.get("c"); string(o.data) != "v2" {
+		t.Errorf("got data %q after conflict, want %q", o.data, "v2")
+	}
+
+	// A concurrent write between the check and the PUT is detected by the
+	// conditional PUT.
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if req.Method == "HEAD" {
+			f.put("c", []byte("concurrent"))
+		}
+		return resp, err
+	})}
+	fs, err = New(f.bucketURL(), config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.CompareAndSwap("c", 2, []byte("v3"))
+	if e, ok := err.(*ConflictError); !ok || e.Actual != -1 {
+		t.Errorf("concurrent write: got error %#v, want ConflictError", err)
+	}
+	if o, _ := f.get("c"); string(o.data) != "concurrent" {
+		t.Errorf("got data %q after concurrent write, want %q", o.data, "concurrent")
+	}
+}
+
+func TestCreateExcl(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"lock", "multipart lock"} {
+		name := data + ".lock"
+		w, err := fs.CreateExcl(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+		if err := w.Close(); err != nil {
+			t.Fatalf("%q: absent object: %s", data, err)
+		}
+
+		w, err = fs.CreateExcl(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("other"))
+		if err := w.Close(); !os.IsExist(err) {
+			t.Errorf("%q: existing object: got error %v, want os.IsExist-satisfying", data, err)
+		}
+		if o, _ := f.get(name); string(o.data) != data {
+			t.Errorf("%q: object was overwritten with %q", data, o.data)
+		}
+	}
+}
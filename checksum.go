@@ -0,0 +1,134 @@
+package s3vfs
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// checksumHeaders maps the supported values of Options.ChecksumAlgorithm to
+// the header (or trailer) that carries the checksum.
+var checksumHeaders = map[string]string{
+	"CRC32C": "X-Amz-Checksum-Crc32c",
+	"SHA256": "X-Amz-Checksum-Sha256",
+}
+
+func newChecksumHash(alg string) hash.Hash {
+	if alg == "SHA256" {
+		return sha256.New()
+	}
+	return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+}
+
+// setContentMD5 sets the Content-MD5 header of req, which sends the size
+// bytes read from body, so that S3 rejects the request if the data it
+// receives is corrupted. The body is read to compute the MD5 and then
+// rewound.
+func setContentMD5(req *http.Request, body io.ReadSeeker, size int64) error {
+	pos, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	if _, err := io.CopyN(h, body, size); err != nil {
+		return err
+	}
+	if _, err := body.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	return nil
+}
+
+// setChecksum makes req, which sends the size bytes read from body as an
+// object or part, carry their checksum if the writer has a checksum
+// algorithm. The returned function returns the base64-encoded checksum once
+// req has been sent; it is nil if there is no checksum.
+//
+// Requests signed with Signature Version 4A send the checksum as a trailer
+// of an aws-chunked body, so body is only read once. Otherwise, the
+// checksum is computed by reading body, which is then rewound.
+func (w *writer) setChecksum(req *http.Request, body io.ReadSeeker, size int64) (func() string, error) {
+	if w.checksumAlgorithm == "" {
+		return nil, nil
+	}
+	name := checksumHeaders[w.checksumAlgorithm]
+	h := newChecksumHash(w.checksumAlgorithm)
+
+	if w.fs.mrapARN == "" {
+		pos, err := body.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(h, body, size); err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		req.Header.Set(name, sum)
+		return func() string { return sum }, nil
+	}
+
+	// The body is sent as a single chunk (if non-empty), followed by the
+	// final empty chunk and the trailer.
+	var head, tail string
+	if size > 0 {
+		head, tail = fmt.Sprintf("%x\r\n", size), "\r\n"
+	}
+	tail += "0\r\n"
+	tb := &trailerBody{
+		r: io.MultiReader(
+			strings.NewReader(head),
+			io.TeeReader(io.LimitReader(body, size), h),
+			strings.NewReader(tail),
+		),
+		h:    h,
+		name: strings.ToLower(name),
+	}
+	trailerLen := len(tb.name) + len(":") + base64.StdEncoding.EncodedLen(h.Size()) + len("\r\n\r\n")
+	req.Body = tb
+	req.GetBody = nil
+	req.ContentLength = int64(len(head)) + size + int64(len(tail)+trailerLen)
+	req.Header.Set("Content-Encoding", "aws-chunked")
+	req.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("X-Amz-Trailer", tb.name)
+	req.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+	return func() string { return tb.sum }, nil
+}
+
+// trailerBody is an aws-chunked request body whose trailer is a checksum of
+// the chunk data, computed as the data is read.
+type trailerBody struct {
+	r    io.Reader // the chunks
+	h    hash.Hash // of the chunk data
+	name string    // of the trailer
+
+	sum     string    // base64-encoded checksum, once r is exhausted
+	trailer io.Reader // once r is exhausted
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	if b.trailer == nil {
+		n, err := b.r.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		b.sum = base64.StdEncoding.EncodeToString(b.h.Sum(nil))
+		b.trailer = strings.NewReader(b.name + ":" + b.sum + "\r\n\r\n")
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return b.trailer.Read(p)
+}
+
+func (b *trailerBody) Close() error { return nil }
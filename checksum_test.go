@@ -0,0 +1,119 @@
+package s3vfs
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/sqs/s3"
+	"github.com/sqs/s3/s3util"
+)
+
+func TestChecksumAlgorithm(t *testing.T) {
+	for _, alg := range []string{"CRC32C", "SHA256"} {
+		for _, mrap := range []bool{false, true} {
+			f := newFakeS3(t)
+			bucket, config := f.bucketURL(), f.config()
+			if mrap {
+				// Send the access point's requests to the fake bucket.
+				bucket, _ = url.Parse("arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap")
+				fakeURL, _ := url.Parse(f.URL)
+				config = &s3util.Config{
+					Service: s3.DefaultService,
+					Keys:    &s3.Keys{AccessKey: "key", SecretKey: "secret"},
+					Client: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+						r = r.Clone(r.Context())
+						r.URL.Scheme, r.URL.Host, r.Host = fakeURL.Scheme, fakeURL.Host, ""
+						r.URL.Path = "/" + fakeBucket + r.URL.Path
+						return http.DefaultTransport.RoundTrip(r)
+					})},
+				}
+			}
+			fs, err := New(bucket, config, &Options{PartSize: 8, ChecksumAlgorithm: alg})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, data := range []string{"", "small", "larger than one part"} {
+				w, err := fs.Create("f")
+				if err != nil {
+					t.Fatal(err)
+				}
+				w.Write([]byte(data))
+				if err := w.Close(); err != nil {
+					t.Fatalf("%s (mrap %v): %q: %s", alg, mrap, data, err)
+				}
+				if o, _ := f.get("f"); string(o.data) != data {
+					t.Errorf("%s (mrap %v): got data %q, want %q", alg, mrap, o.data, data)
+				}
+			}
+
+			var puts, checksums, trailers int
+			for _, req := range f.received() {
+				if req.Method == "PUT" {
+					puts++
+				}
+				if req.Header.Get(checksumHeaders[alg]) != "" {
+					checksums++
+				}
+				if req.Header.Get("X-Amz-Trailer") != "" {
+					trailers++
+				}
+			}
+			if checksums != puts {
+				t.Errorf("%s (mrap %v): got %d checksums for %d PUTs", alg, mrap, checksums, puts)
+			}
+			wantTrailers := 0
+			if mrap {
+				wantTrailers = puts
+			}
+			if trailers != wantTrailers {
+				t.Errorf("%s (mrap %v): got %d trailers, want %d", alg, mrap, trailers, wantTrailers)
+			}
+		}
+	}
+}
+
+func TestChecksumAlgorithm_unsupported(t *testing.T) {
+	f := newFakeS3(t)
+	if _, err := New(f.bucketURL(), f.config(), &Options{ChecksumAlgorithm: "MD5"}); err == nil {
+		t.Error("got nil error for unsupported algorithm")
+	}
+}
+
+func TestContentMD5(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"", "small", "larger than one part"} {
+		createFile(t, fs, "f", []byte(data))
+		if o, _ := f.get("f"); string(o.data) != data {
+			t.Errorf("got data %q, want %q", o.data, data)
+		}
+	}
+	for _, req := range f.received() {
+		if req.Method == "PUT" && req.Header.Get("Content-MD5") == "" {
+			t.Errorf("%s %s: no Content-MD5", req.Method, req.URL)
+		}
+	}
+
+	// A body corrupted in transit is rejected, for single and multipart
+	// uploads.
+	for _, data := range []string{"small", "larger than one part"} {
+		f.corruptWrites = 1
+		w, err := fs.Create("corrupt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+		if err := w.Close(); err == nil {
+			t.Errorf("%q: got no error for corrupted upload", data)
+		}
+		if _, ok := f.get("corrupt"); ok {
+			t.Errorf("%q: corrupted object was stored", data)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errNotModified is the error for a conditional GET of an object that has
+// not changed (HTTP status 304).
+var errNotModified = errors.New("s3vfs: object not modified")
+
+// OpenIfModified opens the object at path for reading only if it has changed
+// since a copy of it was cached: if its ETag differs from etag (sent as
+// If-None-Match) or, when etag is empty, if it was modified after since
+// (sent as If-Modified-Since). If S3 responds that the object is unchanged
+// (304 Not Modified), OpenIfModified returns (nil, false, nil) and no data is
+// transferred. Otherwise, it returns the object's data and true, and the
+// caller must close the returned body. An empty etag and a zero since make
+// the GET unconditional.
+//
+// The etag may be given with or without surrounding quotes (as in the ETag
+// response header or ManifestEntry.ETag, respectively).
+func (fs *S3FS) OpenIfModified(path string, etag string, since time.Time) (io.ReadCloser, bool, error) {
+	h := make(http.Header)
+	if etag != "" {
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, "W/") {
+			etag = `"` + etag + `"`
+		}
+		h.Set("If-None-Match", etag)
+	}
+	if !since.IsZero() {
+		h.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	}
+	resp, err := fs.getContext(context.Background(), path, h)
+	if err != nil {
+		if errors.Is(err, errNotModified) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return resp.Body, true, nil
+}
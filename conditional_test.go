@@ -0,0 +1,56 @@
+package s3vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenIfModified(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("a", []byte("v1"))
+	o, _ := f.get("a")
+	etag := o.etag()
+
+	for name, tc := range map[string]struct {
+		etag  string
+		since time.Time
+	}{
+		"etag":          {etag: etag},
+		"unquoted etag": {etag: strings.Trim(etag, `"`)},
+		"since":         {since: o.modTime.Add(time.Second)},
+	} {
+		body, changed, err := fs.OpenIfModified("a", tc.etag, tc.since)
+		if err != nil || changed || body != nil {
+			t.Errorf("%s: got %v, %v, %v; want nil, false, nil", name, body, changed, err)
+		}
+	}
+
+	f.put("a", []byte("v2"))
+	for name, tc := range map[string]struct {
+		etag  string
+		since time.Time
+	}{
+		"etag":          {etag: etag},
+		"since":         {since: o.modTime.Add(-time.Hour)},
+		"unconditional": {},
+	} {
+		body, changed, err := fs.OpenIfModified("a", tc.etag, tc.since)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if !changed || err != nil || string(data) != "v2" {
+			t.Errorf("%s: got %q, %v, %v; want %q, true", name, data, changed, err, "v2")
+		}
+	}
+
+	if _, _, err := fs.OpenIfModified("missing", etag, time.Time{}); !os.IsNotExist(err) {
+		t.Errorf("missing: got error %v, want not exist", err)
+	}
+}
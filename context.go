@@ -0,0 +1,76 @@
+package s3vfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// WithContext returns a view of fs whose operations (Open, Create, Stat,
+// Lstat, ReadDir, Mkdir, MkdirAll, and Remove) issue their requests with
+// ctx, so they are canceled when ctx is done. It lets code written against
+// rwvfs.FileSystem be bound to a request-scoped context (e.g., in an HTTP
+// handler) without changing its calls. Files opened or created through the
+// view keep using ctx for their later requests.
+func (fs *S3FS) WithContext(ctx context.Context) rwvfs.FileSystem {
+	return &contextFS{fs: fs, ctx: ctx}
+}
+
+// contextFS is the filesystem returned by S3FS.WithContext.
+type contextFS struct {
+	fs  *S3FS
+	ctx context.Context
+}
+
+func (c *contextFS) String() string {
+	return fmt.Sprintf("%s (with context)", c.fs)
+}
+
+func (c *contextFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	return c.fs.OpenContext(c.ctx, name)
+}
+
+func (c *contextFS) Lstat(name string) (os.FileInfo, error) {
+	return c.fs.lstatContext(c.ctx, name)
+}
+
+func (c *contextFS) Stat(name string) (os.FileInfo, error) {
+	return c.fs.StatContext(c.ctx, name)
+}
+
+func (c *contextFS) ReadDir(path string) ([]os.FileInfo, error) {
+	return c.fs.ReadDirContext(c.ctx, path)
+}
+
+func (c *contextFS) Create(path string) (io.WriteCloser, error) {
+	return c.fs.CreateContext(c.ctx, path)
+}
+
+func (c *contextFS) Mkdir(name string) error {
+	return c.fs.MkdirContext(c.ctx, name)
+}
+
+// MkdirAll implements rwvfs.MkdirAllOverrider.
+func (c *contextFS) MkdirAll(name string) error {
+	return c.fs.MkdirContext(c.ctx, name)
+}
+
+func (c *contextFS) Remove(name string) error {
+	return c.fs.RemoveContext(c.ctx, name)
+}
+
+// Rename is passed through to the S3FS; its requests do not use the
+// context.
+func (c *contextFS) Rename(oldpath, newpath string) error {
+	return c.fs.Rename(oldpath, newpath)
+}
+
+// RemoveAll is passed through to the S3FS; its requests do not use the
+// context.
+func (c *contextFS) RemoveAll(path string) error {
+	return c.fs.RemoveAll(path)
+}
@@ -0,0 +1,63 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestWithContext(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{DirMarkers: true, MaxRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Operations with a live context work as usual.
+	cfs := fs.WithContext(context.Background())
+	if err := rwvfs.MkdirAll(cfs, "d"); err != nil {
+		t.Fatal(err)
+	}
+	createFile(t, cfs, "d/a", []byte("x"))
+	if data, err := vfs.ReadFile(cfs, "d/a"); err != nil || string(data) != "x" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if fis, err := cfs.ReadDir("d"); err != nil || len(fis) != 1 {
+		t.Errorf("got %v, %v; want 1 entry", fis, err)
+	}
+	if err := cfs.Remove("d/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Operations with a canceled context fail without completing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfs = fs.WithContext(ctx)
+	ops := map[string]func() error{
+		"Open":    func() error { _, err := cfs.Open("d/a"); return err },
+		"Stat":    func() error { _, err := cfs.Stat("d"); return err },
+		"Lstat":   func() error { _, err := cfs.Lstat("d"); return err },
+		"ReadDir": func() error { _, err := cfs.ReadDir("d"); return err },
+		"Mkdir":   func() error { return cfs.Mkdir("e") },
+		"Remove":  func() error { return cfs.Remove("d/") },
+		"Create": func() error {
+			w, err := cfs.Create("b")
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		},
+	}
+	f.reset()
+	for name, op := range ops {
+		if err := op(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got error %v, want context.Canceled", name, err)
+		}
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests with a canceled context, want 0", n)
+	}
+}
@@ -0,0 +1,404 @@
+package s3vfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// maxCopySize is the size of the largest object that S3 can copy with a
+// single CopyObject request. Larger objects are copied with multipart copy,
+// in parts of copyPartSize bytes.
+var (
+	maxCopySize  int64 = 5 * 1024 * 1024 * 1024
+	copyPartSize int64 = 1024 * 1024 * 1024
+)
+
+// copiedHeaders are the response headers of a HEAD request that describe
+// attributes of an object (other than its user metadata) that are preserved
+// when it is copied.
+var copiedHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"Expires",
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+	"X-Amz-Server-Side-Encryption-Bucket-Key-Enabled",
+	"X-Amz-Storage-Class",
+	"X-Amz-Website-Redirect-Location",
+}
+
+// Transition changes the storage class of the object at path (e.g., to
+// "STANDARD_IA" or "GLACIER") by copying the object onto itself, as an
+// on-demand alternative to a lifecycle rule. The object's data, metadata, and
+// other attributes are preserved; its ACL is reset to the bucket default, as
+// with any copy. Objects larger than 5 GB are copied with multipart copy.
+//
+// If the object does not exist, the error satisfies os.IsNotExist.
+func (fs *S3FS) Transition(path, storageClass string) error {
+	if err := checkStorageClass(storageClass); err != nil {
+		return &os.PathError{Op: "transition", Path: fs.url(path), Err: err}
+	}
+	h := make(http.Header)
+	h.Set("X-Amz-Storage-Class", storageClass)
+	if err := fs.copy(path, path, h); err != nil {
+		return &os.PathError{Op: "transition", Path: fs.url(path), Err: err}
+	}
+	return nil
+}
+
+// Copy copies the object at src to dst with server-side copy, so its data
+// is not transferred through the client. The copy has the same metadata and
+// other attributes (e.g., Content-Type) as src, except its ACL, which is the
+// bucket default. Objects larger than 5 GB are copied with multipart copy.
+//
+// If src does not exist, the error satisfies os.IsNotExist.
+func (fs *S3FS) Copy(src, dst string) error {
+	if err := fs.copy(src, dst, nil); err != nil {
+		return &os.PathError{Op: "copy", Path: fs.url(src), Err: err}
+	}
+	return nil
+}
+
+// Rename moves the object at oldpath to newpath by copying it (as Copy
+// does) and then removing the original. S3 has no rename operation, so this
+// is not atomic: both objects exist until the original is removed. The new
+// object does appear all at once, with its complete data, so files can be
+// written to a temporary path and then renamed into place. Renaming an
+// object to its own path does nothing.
+//
+// If oldpath does not exist, the error satisfies os.IsNotExist.
+func (fs *S3FS) Rename(oldpath, newpath string) error {
+	if fs.url(oldpath) == fs.url(newpath) {
+		// Copying the object onto itself and then removing it would
+		// delete it.
+		resp, err := fs.head(oldpath)
+		if err != nil {
+			return &os.PathError{Op: "rename", Path: fs.url(oldpath), Err: err}
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if err := fs.copy(oldpath, newpath, nil); err != nil {
+		return &os.PathError{Op: "rename", Path: fs.url(oldpath), Err: err}
+	}
+	return fs.Remove(oldpath)
+}
+
+// CopyFrom copies the file or directory tree at path in src (e.g., a local
+// rwvfs.OS filesystem) to the same path in dst. Each file is streamed to its
+// object (with a multipart upload if it is larger than the part size; see
+// Options.PartSize) rather than read into memory, and directories are created
+// with MkdirAll, so they have markers if Options.DirMarkers is set.
+//
+// CopyFrom stops at the first error, which is returned; the files copied
+// before it remain in dst.
+func CopyFrom(dst *S3FS, src rwvfs.FileSystem, path string) error {
+	fi, err := src.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return copyFile(dst, src, path)
+	}
+	if err := rwvfs.MkdirAll(dst, path); err != nil {
+		return err
+	}
+	fis, err := src.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if err := CopyFrom(dst, src, pathpkg.Join(path, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile streams the file at path in src to the object at path in dst.
+func copyFile(dst *S3FS, src rwvfs.FileSystem, path string) error {
+	r, err := src.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if dst.opt.ReadOnly {
+		return &os.PathError{Op: "create", Path: dst.url(path), Err: ErrReadOnly}
+	}
+	w := dst.newWriter(path, make(http.Header))
+	if _, err := io.Copy(w, r); err != nil {
+		return w.fail(err)
+	}
+	return w.Close()
+}
+
+// copy copies the object at src to dst, preserving its metadata and other
+// attributes (except its ACL), with the headers in h overriding them. It
+// uses multipart copy for objects too large to copy in a single request.
+func (fs *S3FS) copy(src, dst string, h http.Header) error {
+	resp, err := fs.head(src)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	attrs := objectAttrs(resp.Header)
+	for k, v := range h {
+		attrs[k] = v
+	}
+
+	if resp.ContentLength <= maxCopySize {
+		// CopyObject copies the metadata, but the storage class and
+		// encryption must be given explicitly.
+		ch := make(http.Header)
+		for k, v := range attrs {
+			if k == "X-Amz-Storage-Class" || strings.HasPrefix(k, "X-Amz-Server-Side-Encryption") {
+				ch[k] = v
+			}
+		}
+		ch.Set("X-Amz-Metadata-Directive", "COPY")
+		return fs.copyObject(src, dst, ch)
+	}
+	return fs.multipartCopy(src, dst, resp.ContentLength, attrs)
+}
+
+// objectAttrs returns the headers that set the attributes of an object
+// (those in copiedHeaders, and its user metadata) described by h, the
+// response headers of a HEAD request.
+func objectAttrs(h http.Header) http.Header {
+	attrs := make(http.Header)
+	for _, k := range copiedHeaders {
+		if v := h.Get(k); v != "" {
+			attrs.Set(k, v)
+		}
+	}
+	for k, v := range h {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), metadataPrefix) {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+// Touch creates an empty object at path if none exists, and otherwise
+// updates the object's modification time by copying it onto itself (with
+// server-side copy, as Copy does), preserving its data, metadata, and other
+// attributes except its ACL. It is useful for sentinel files and directory
+// markers.
+func (fs *S3FS) Touch(path string) error {
+	resp, err := fs.head(path)
+	if os.IsNotExist(err) {
+		return fs.newWriter(path, make(http.Header)).Close()
+	}
+	if err != nil {
+		return &os.PathError{Op: "touch", Path: fs.url(path), Err: err}
+	}
+	resp.Body.Close()
+
+	// S3 refuses to copy an object onto itself unchanged, so its attributes
+	// are replaced with their current values.
+	attrs := objectAttrs(resp.Header)
+	if resp.ContentLength > maxCopySize {
+		err = fs.multipartCopy(path, path, resp.ContentLength, attrs)
+	} else {
+		attrs.Set("X-Amz-Metadata-Directive", "REPLACE")
+		err = fs.copyObject(path, path, attrs)
+	}
+	if err != nil {
+		return &os.PathError{Op: "touch", Path: fs.url(path), Err: err}
+	}
+	return nil
+}
+
+// SetMetadata replaces the user-defined metadata of the object at path (see
+// WriteOptions.Metadata) with md by copying the object onto itself, as
+// Touch does. Its data and other attributes (e.g., Content-Type and storage
+// class) are preserved. If md is empty, the metadata is removed.
+//
+// If the object does not exist, the error satisfies os.IsNotExist.
+func (fs *S3FS) SetMetadata(path string, md map[string]string) error {
+	if err := (&WriteOptions{Metadata: md}).check(); err != nil {
+		return &os.PathError{Op: "setmetadata", Path: fs.url(path), Err: err}
+	}
+	resp, err := fs.head(path)
+	if err != nil {
+		return &os.PathError{Op: "setmetadata", Path: fs.url(path), Err: err}
+	}
+	resp.Body.Close()
+
+	attrs := objectAttrs(resp.Header)
+	for k := range attrs {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), metadataPrefix) {
+			delete(attrs, k)
+		}
+	}
+	for k, v := range md {
+		attrs.Set(metadataPrefix+k, v)
+	}
+	if resp.ContentLength > maxCopySize {
+		err = fs.multipartCopy(path, path, resp.ContentLength, attrs)
+	} else {
+		attrs.Set("X-Amz-Metadata-Directive", "REPLACE")
+		err = fs.copyObject(path, path, attrs)
+	}
+	if err != nil {
+		return &os.PathError{Op: "setmetadata", Path: fs.url(path), Err: err}
+	}
+	return nil
+}
+
+// copyObject copies the object at src to dst with a single CopyObject
+// request, which also sends the headers in h.
+func (fs *S3FS) copyObject(src, dst string, h http.Header) error {
+	req, err := http.NewRequest("PUT", fs.url(dst), nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	fs.setCustomerKeyHeader(req.Header)
+	fs.setCopySourceKeyHeader(req.Header)
+	req.Header.Set("X-Amz-Copy-Source", fs.copySource(src))
+	resp, err := fs.do(req)
+	if err != nil {
+		return err
+	}
+	return fs.checkOKResponse(resp)
+}
+
+// multipartCopy copies the size-byte object at src to dst with a multipart
+// upload whose parts are copied from ranges of src. The headers in h are
+// sent when initiating the upload.
+func (fs *S3FS) multipartCopy(src, dst string, size int64, h http.Header) error {
+	fs.setCustomerKeyHeader(h)
+	w := &writer{fs: fs, path: dst, header: h}
+	if err := w.initiate(); err != nil {
+		return err
+	}
+	for start := int64(0); start < size; start += copyPartSize {
+		end := start + copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		num := len(w.parts) + 1
+		u := fmt.Sprintf("%s?partNumber=%d&uploadId=%s", fs.url(dst), num, url.QueryEscape(w.uploadID))
+		req, err := http.NewRequest("PUT", u, nil)
+		if err != nil {
+			w.abort()
+			return err
+		}
+		fs.setCustomerKeyHeader(req.Header)
+		fs.setCopySourceKeyHeader(req.Header)
+		req.Header.Set("X-Amz-Copy-Source", fs.copySource(src))
+		req.Header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		resp, err := fs.do(req)
+		if err != nil {
+			w.abort()
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			w.abort()
+			return fs.newS3Error(resp)
+		}
+		var result struct{ ETag string }
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			w.abort()
+			return err
+		}
+		w.parts = append(w.parts, completedPart{PartNumber: num, ETag: result.ETag})
+	}
+	if err := w.complete(); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
+
+// checkOKResponse returns an error if resp, the response to a CopyObject or
+// CompleteMultipartUpload request, indicates failure. S3 may report that
+// these failed after it has already sent a 200 status, in which case the
+// body is an Error document.
+func (fs *S3FS) checkOKResponse(resp *http.Response) error {
+	e := fs.newS3Error(resp)
+	if resp.StatusCode != http.StatusOK || bytes.Contains(e.body, []byte("<Error>")) {
+		return e
+	}
+	return nil
+}
+
+// copySource returns the value of the X-Amz-Copy-Source header that refers
+// to the object at path, which is of the form "/bucket/key" (or an object
+// ARN, for Multi-Region Access Points).
+func (fs *S3FS) copySource(path string) string {
+	if fs.mrapARN != "" {
+		// Objects in access points are referred to by ARN.
+		key := strings.TrimPrefix(pathpkg.Join(fs.bucket.Path, fs.keyPrefix, path), "/")
+		return uriEncode(fs.mrapARN+"/object/"+key, false)
+	}
+	p := pathpkg.Join(fs.bucket.Path, fs.keyPrefix, path)
+	if b := virtualHostBucket(fs.bucket.Hostname()); b != "" {
+		p = "/" + b + p
+	}
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// virtualHostBucket returns the bucket name in a virtual-hosted-style AWS S3
+// host (e.g., "mybucket" in "mybucket.s3-us-west-2.amazonaws.com"), or the
+// empty string if host does not name a bucket.
+func virtualHostBucket(host string) string {
+	if !strings.HasSuffix(host, ".amazonaws.com") {
+		return ""
+	}
+	if i := strings.Index(host, ".s3"); i > 0 {
+		return host[:i]
+	}
+	return ""
+}
+
+// PromoteOptions specifies how PromoteObjectWithOptions promotes an object.
+type PromoteOptions struct {
+	// KeepStaged prevents the staged object from being removed after it is
+	// copied to the live path.
+	KeepStaged bool
+}
+
+// PromoteObject replaces the object at livePath with the object at
+// stagedPath (e.g., to deploy a staged configuration), and then removes the
+// staged object.
+//
+// S3 has no atomic rename or swap, so this is copy-then-replace, not an
+// atomic operation: the staged object is copied server-side to livePath,
+// preserving its metadata, which replaces the live object in a single step.
+// Readers see either the old or the new live object, never a partial one,
+// but may briefly see the old live object after PromoteObject returns (and
+// both the staged and live objects exist until the staged one is removed).
+func (fs *S3FS) PromoteObject(stagedPath, livePath string) error {
+	return fs.PromoteObjectWithOptions(stagedPath, livePath, nil)
+}
+
+// PromoteObjectWithOptions is like PromoteObject, but it promotes the object
+// as specified in opt. If opt is nil, it is equivalent to PromoteObject.
+func (fs *S3FS) PromoteObjectWithOptions(stagedPath, livePath string, opt *PromoteOptions) error {
+	if err := fs.copy(stagedPath, livePath, nil); err != nil {
+		return &os.PathError{Op: "promote", Path: fs.url(stagedPath), Err: err}
+	}
+	if opt != nil && opt.KeepStaged {
+		return nil
+	}
+	return fs.Remove(stagedPath)
+}
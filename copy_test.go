@@ -0,0 +1,263 @@
+package s3vfs
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+func TestTransition(t *testing.T) {
+	defer func(max, part int64) { maxCopySize, copyPartSize = max, part }(maxCopySize, copyPartSize)
+	maxCopySize, copyPartSize = 10, 4
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for _, data := range []string{"small", "larger than ten bytes"} {
+		w, err := fs.CreateWithOptions("f", &WriteOptions{ContentType: "text/plain"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		o, _ := f.get("f")
+		o.header.Set("X-Amz-Meta-Owner", "alice")
+
+		if err := fs.Transition("f", "STANDARD_IA"); err != nil {
+			t.Fatalf("%q: %s", data, err)
+		}
+		o, _ = f.get("f")
+		if !bytes.Equal(o.data, []byte(data)) {
+			t.Errorf("%q: got data %q after transition", data, o.data)
+		}
+		want := http.Header{
+			"Content-Type":        {"text/plain"},
+			"X-Amz-Meta-Owner":    {"alice"},
+			"X-Amz-Storage-Class": {"STANDARD_IA"},
+		}
+		for k := range want {
+			if got := o.header.Get(k); got != want.Get(k) {
+				t.Errorf("%q: got %s %q, want %q", data, k, got, want.Get(k))
+			}
+		}
+	}
+
+	var partCopies int
+	for _, req := range f.received() {
+		if req.Header.Get("X-Amz-Copy-Source-Range") != "" {
+			partCopies++
+		}
+	}
+	if partCopies != 6 {
+		t.Errorf("got %d part copies, want 6", partCopies)
+	}
+}
+
+func TestTransition_notExist(t *testing.T) {
+	fs := newFakeS3(t).fs()
+	if err := fs.Transition("missing", "GLACIER"); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
+
+func TestCopyRename(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	w, _ := fs.CreateWithOptions("a", &WriteOptions{ContentType: "text/csv"})
+	w.Write([]byte("x,y"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	o, _ := f.get("a")
+	o.header.Set("X-Amz-Meta-Owner", "alice")
+
+	if err := fs.Copy("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c"} {
+		o, ok := f.get(key)
+		if !ok {
+			t.Errorf("%s: not exist", key)
+			continue
+		}
+		if string(o.data) != "x,y" || o.header.Get("Content-Type") != "text/csv" || o.header.Get("X-Amz-Meta-Owner") != "alice" {
+			t.Errorf("%s: got data %q and header %v", key, o.data, o.header)
+		}
+	}
+	if _, ok := f.get("b"); ok {
+		t.Error("b not removed by Rename")
+	}
+	for _, req := range f.received() {
+		if req.Method == "GET" {
+			t.Errorf("got GET %s, want server-side copy", req.URL.Path)
+		}
+	}
+
+	if err := fs.Rename("missing", "d"); !os.IsNotExist(err) {
+		t.Errorf("Rename missing: got error %v, want not exist", err)
+	}
+
+	// Renaming an object to its own path leaves it in place.
+	if err := fs.Rename("c", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.get("c"); !ok {
+		t.Error("c removed by Rename to itself")
+	}
+	if err := fs.Rename("missing", "missing"); !os.IsNotExist(err) {
+		t.Errorf("Rename missing to itself: got error %v, want not exist", err)
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	tests := map[string]string{
+		"https://s3-us-west-2.amazonaws.com/mybucket":        "/mybucket/a%20b/c",
+		"https://mybucket.s3-us-west-2.amazonaws.com":        "/mybucket/a%20b/c",
+		"https://my.bucket.s3.eu-west-1.amazonaws.com/":      "/my.bucket/a%20b/c",
+		"http://localhost:9000/mybucket/":                    "/mybucket/a%20b/c",
+		"https://mybucket.s3-us-west-2.amazonaws.com/prefix": "/mybucket/prefix/a%20b/c",
+	}
+	for bucketURL, want := range tests {
+		u, _ := url.Parse(bucketURL)
+		fs := S3(u, nil).(*S3FS)
+		if got := fs.copySource("/a b/c"); got != want {
+			t.Errorf("%s: got %q, want %q", bucketURL, got, want)
+		}
+	}
+}
+
+func TestPromoteObject(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("live", []byte("old"))
+	w, _ := fs.CreateWithOptions("staged", &WriteOptions{ContentType: "application/json"})
+	w.Write([]byte("new"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.PromoteObjectWithOptions("staged", "live", &PromoteOptions{KeepStaged: true}); err != nil {
+		t.Fatal(err)
+	}
+	o, _ := f.get("live")
+	if string(o.data) != "new" {
+		t.Errorf("got live data %q, want %q", o.data, "new")
+	}
+	if got, want := o.header.Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("got live Content-Type %q, want %q", got, want)
+	}
+	if _, ok := f.get("staged"); !ok {
+		t.Error("staged object removed despite KeepStaged")
+	}
+
+	if err := fs.PromoteObject("staged", "live"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.get("staged"); ok {
+		t.Error("staged object not removed")
+	}
+
+	if err := fs.PromoteObject("staged", "live"); !os.IsNotExist(err) {
+		t.Errorf("missing staged object: got error %v, want not exist", err)
+	}
+	if o, _ := f.get("live"); string(o.data) != "new" {
+		t.Errorf("failed promotion changed live data to %q", o.data)
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 8, DirMarkers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := rwvfs.Map(map[string]string{
+		"top":             "not copied",
+		"site/index.html": "<html>",
+		"site/css/a.css":  "larger than one part", // multipart
+		"site/css/b.css":  "",
+	})
+	if err := CopyFrom(fs, src, "site"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"site/index.html": "<html>",
+		"site/css/a.css":  "larger than one part",
+		"site/css/b.css":  "",
+		"site/":           "",
+		"site/css/":       "",
+	} {
+		if o, ok := f.get(key); !ok || string(o.data) != want {
+			t.Errorf("%s: got object %v, want data %q", key, o, want)
+		}
+	}
+	if _, ok := f.get("top"); ok {
+		t.Error("top: copied from outside path")
+	}
+
+	// A single file is copied to the same path.
+	if err := CopyFrom(fs, src, "top"); err != nil {
+		t.Fatal(err)
+	}
+	if o, ok := f.get("top"); !ok || string(o.data) != "not copied" {
+		t.Errorf("top: got object %v", o)
+	}
+
+	if err := CopyFrom(fs, src, "missing"); !os.IsNotExist(err) {
+		t.Errorf("missing: got error %v, want not exist", err)
+	}
+	ro, _ := New(f.bucketURL(), f.config(), &Options{ReadOnly: true})
+	if err := CopyFrom(ro, src, "top"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only: got error %v, want ErrReadOnly", err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	if err := fs.Touch("new"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat("new"); err != nil || fi.Size() != 0 || fi.IsDir() {
+		t.Errorf("new: got %v, %v, want zero-byte file", fi, err)
+	}
+
+	createFileWithOptions := func(path string, data []byte, opt *WriteOptions) {
+		w, err := fs.CreateWithOptions(path, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createFileWithOptions("old", []byte("data"), &WriteOptions{ContentType: "text/csv", Metadata: map[string]string{"owner": "alice"}})
+	o, _ := f.get("old")
+	before := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	o.modTime = before
+	if err := fs.Touch("old"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().After(before) {
+		t.Errorf("got ModTime %s, want after %s", fi.ModTime(), before)
+	}
+	o, _ = f.get("old")
+	if string(o.data) != "data" || o.header.Get("Content-Type") != "text/csv" || o.header.Get("X-Amz-Meta-Owner") != "alice" {
+		t.Errorf("got data %q and header %v, want them preserved", o.data, o.header)
+	}
+}
@@ -0,0 +1,355 @@
+package s3vfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoCredentials is the error of a CredentialsProvider whose source of
+// credentials is not configured (e.g., EnvCredentials when the environment
+// variables are not set).
+var ErrNoCredentials = errors.New("s3vfs: no credentials found")
+
+// DefaultCredentials returns a provider of the credentials found the way
+// the AWS SDKs find them, trying in order:
+//
+//   - the environment (EnvCredentials);
+//   - a web identity token, as with IAM roles for service accounts on EKS
+//     (WebIdentityCredentials);
+//   - the shared credentials file (SharedCredentials);
+//   - the ECS container credentials endpoint (ECSCredentials);
+//   - the IAM role of the EC2 instance (EC2RoleCredentials).
+//
+// It is meant for Options.Credentials, which refreshes temporary
+// credentials before they expire.
+func DefaultCredentials() CredentialsProvider {
+	return ChainCredentials{
+		&EnvCredentials{},
+		&WebIdentityCredentials{},
+		&SharedCredentials{},
+		&ECSCredentials{},
+		// Off EC2, the metadata service is unreachable; don't wait long
+		// to find out.
+		&EC2RoleCredentials{Client: &http.Client{Timeout: 5 * time.Second}},
+	}
+}
+
+// ChainCredentials is a CredentialsProvider that returns the credentials of
+// the first of its providers that retrieves them successfully.
+type ChainCredentials []CredentialsProvider
+
+// Retrieve implements CredentialsProvider.
+func (c ChainCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	var errs errorList
+	for _, p := range c {
+		creds, err := p.Retrieve(ctx)
+		if err == nil {
+			return creds, nil
+		}
+		if ctx.Err() != nil {
+			return Credentials{}, ctx.Err()
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return Credentials{}, ErrNoCredentials
+	}
+	return Credentials{}, errs.err()
+}
+
+// EnvCredentials is a CredentialsProvider that reads credentials from the
+// environment variables AWS_ACCESS_KEY_ID (or AWS_ACCESS_KEY),
+// AWS_SECRET_ACCESS_KEY (or AWS_SECRET_KEY), and AWS_SESSION_TOKEN.
+type EnvCredentials struct{}
+
+// Retrieve implements CredentialsProvider.
+func (*EnvCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	creds := Credentials{
+		AccessKey:    firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		SecretKey:    firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return Credentials{}, fmt.Errorf("%w in environment", ErrNoCredentials)
+	}
+	return creds, nil
+}
+
+// firstEnv returns the value of the first of the environment variables that
+// is set, or "" if none is.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// SharedCredentials is a CredentialsProvider that reads credentials from a
+// profile of the shared credentials file that the AWS CLI writes.
+type SharedCredentials struct {
+	// Filename is the path of the credentials file. If empty, the
+	// AWS_SHARED_CREDENTIALS_FILE environment variable is used, or else
+	// ~/.aws/credentials.
+	Filename string
+
+	// Profile is the name of the profile. If empty, the AWS_PROFILE
+	// environment variable is used, or else "default".
+	Profile string
+}
+
+// Retrieve implements CredentialsProvider.
+func (p *SharedCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	filename := p.Filename
+	if filename == "" {
+		filename = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("%w: %s", ErrNoCredentials, err)
+		}
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := p.Profile
+	if profile == "" {
+		profile = firstEnv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return Credentials{}, fmt.Errorf("%w: %s", ErrNoCredentials, err)
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer f.Close()
+
+	var creds Credentials
+	var found bool
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == profile
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch strings.ToLower(key) {
+		case "aws_access_key_id":
+			creds.AccessKey = value
+		case "aws_secret_access_key":
+			creds.SecretKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, err
+	}
+	if !found {
+		return Credentials{}, fmt.Errorf("%w: no profile %q in %s", ErrNoCredentials, profile, filename)
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return Credentials{}, fmt.Errorf("profile %q in %s has no access key", profile, filename)
+	}
+	return creds, nil
+}
+
+// ECSCredentials is a CredentialsProvider that retrieves the temporary
+// credentials of the IAM role of an ECS task (or of another container
+// environment that serves credentials the same way, such as EKS Pod
+// Identity) from the container credentials endpoint.
+type ECSCredentials struct {
+	// Endpoint is the URL of the credentials endpoint. If empty, it is
+	// http://169.254.170.2 followed by the path in the
+	// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI environment variable, or
+	// else the URL in AWS_CONTAINER_CREDENTIALS_FULL_URI.
+	Endpoint string
+
+	// AuthToken is sent as the Authorization header of requests to the
+	// endpoint. If empty, the AWS_CONTAINER_AUTHORIZATION_TOKEN
+	// environment variable is used, or else the contents of the file
+	// named by AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE.
+	AuthToken string
+
+	// Client is the HTTP client used to query the endpoint. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Retrieve implements CredentialsProvider.
+func (p *ECSCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+			endpoint = "http://169.254.170.2" + uri
+		} else {
+			endpoint = os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+		}
+	}
+	if endpoint == "" {
+		return Credentials{}, fmt.Errorf("%w: no container credentials endpoint", ErrNoCredentials)
+	}
+	token := p.AuthToken
+	if token == "" {
+		token = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	}
+	if name := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); token == "" && name != "" {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return Credentials{}, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	h := make(http.Header)
+	if token != "" {
+		h.Set("Authorization", token)
+	}
+	body, err := fetch(ctx, p.Client, "GET", endpoint, h, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var result struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Credentials{}, fmt.Errorf("decoding container credentials: %w", err)
+	}
+	return Credentials{
+		AccessKey:    result.AccessKeyId,
+		SecretKey:    result.SecretAccessKey,
+		SessionToken: result.Token,
+		Expires:      result.Expiration,
+	}, nil
+}
+
+// WebIdentityCredentials is a CredentialsProvider that exchanges a web
+// identity token (e.g., the service account token of an EKS pod with IAM
+// roles for service accounts) for the temporary credentials of an IAM role
+// with STS's AssumeRoleWithWebIdentity operation. The token file is read
+// again for each retrieval, since it is rotated.
+type WebIdentityCredentials struct {
+	// RoleARN is the ARN of the role to assume. If empty, the
+	// AWS_ROLE_ARN environment variable is used.
+	RoleARN string
+
+	// TokenFile is the path of the file that holds the token. If empty,
+	// the AWS_WEB_IDENTITY_TOKEN_FILE environment variable is used.
+	TokenFile string
+
+	// SessionName identifies the role session. If empty, the
+	// AWS_ROLE_SESSION_NAME environment variable is used, or else a name
+	// is generated.
+	SessionName string
+
+	// Endpoint is the URL of STS. If empty, the regional endpoint of the
+	// AWS_REGION environment variable is used, or else
+	// https://sts.amazonaws.com.
+	Endpoint string
+
+	// Client is the HTTP client used to send requests to STS. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Retrieve implements CredentialsProvider.
+func (p *WebIdentityCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	roleARN := p.RoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	tokenFile := p.TokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if roleARN == "" || tokenFile == "" {
+		return Credentials{}, fmt.Errorf("%w: no web identity role and token file", ErrNoCredentials)
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	sessionName := p.SessionName
+	if sessionName == "" {
+		sessionName = os.Getenv("AWS_ROLE_SESSION_NAME")
+	}
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("s3vfs-%d", time.Now().UnixNano())
+	}
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		if region := os.Getenv("AWS_REGION"); region != "" {
+			endpoint = "https://sts." + region + ".amazonaws.com"
+		} else {
+			endpoint = "https://sts.amazonaws.com"
+		}
+	}
+
+	q := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	// The token is sent in the body, not the URL, so that it doesn't appear
+	// in errors.
+	h := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	body, err := fetch(ctx, p.Client, "POST", strings.TrimSuffix(endpoint, "/")+"/", h, strings.NewReader(q.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return Credentials{}, fmt.Errorf("decoding AssumeRoleWithWebIdentity response: %w", err)
+	}
+	c := result.Credentials
+	return Credentials{
+		AccessKey:    c.AccessKeyId,
+		SecretKey:    c.SecretAccessKey,
+		SessionToken: c.SessionToken,
+		Expires:      c.Expiration,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearCredentialsEnv unsets the environment variables that credentials
+// providers read, for the duration of the test.
+func clearCredentialsEnv(t *testing.T) {
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY", "AWS_SESSION_TOKEN",
+		"AWS_SHARED_CREDENTIALS_FILE", "AWS_PROFILE",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+		"AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_SESSION_NAME", "AWS_REGION",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	if _, err := (&EnvCredentials{}).Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unset: got error %v, want ErrNoCredentials", err)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	creds, err := (&EnvCredentials{}).Retrieve(context.Background())
+	if want := (Credentials{AccessKey: "AKID", SecretKey: "secret", SessionToken: "token"}); err != nil || creds != want {
+		t.Errorf("got %+v, %v; want %+v", creds, err, want)
+	}
+}
+
+func TestSharedCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	name := filepath.Join(t.TempDir(), "credentials")
+	err := ioutil.WriteFile(name, []byte(`# comment
+[default]
+aws_access_key_id = AKID1
+aws_secret_access_key = secret1
+
+[work]
+aws_access_key_id=AKID2
+aws_secret_access_key=secret2
+aws_session_token=token2
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := (&SharedCredentials{Filename: name}).Retrieve(context.Background())
+	if err != nil || creds.AccessKey != "AKID1" || creds.SecretKey != "secret1" || creds.SessionToken != "" {
+		t.Errorf("default: got %+v, %v", creds, err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", name)
+	t.Setenv("AWS_PROFILE", "work")
+	creds, err = (&SharedCredentials{}).Retrieve(context.Background())
+	if err != nil || creds.AccessKey != "AKID2" || creds.SecretKey != "secret2" || creds.SessionToken != "token2" {
+		t.Errorf("work: got %+v, %v", creds, err)
+	}
+	if _, err := (&SharedCredentials{Profile: "missing"}).Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("missing profile: got error %v, want ErrNoCredentials", err)
+	}
+	if _, err := (&SharedCredentials{Filename: name + ".missing"}).Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("missing file: got error %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestECSCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/creds" || r.Header.Get("Authorization") != "auth" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"token","Expiration":"2030-01-02T03:04:05Z"}`)
+	}))
+	defer srv.Close()
+
+	if _, err := (&ECSCredentials{}).Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unset: got error %v, want ErrNoCredentials", err)
+	}
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "auth")
+	creds, err := (&ECSCredentials{}).Retrieve(context.Background())
+	if err != nil || creds.AccessKey != "ASIA" || creds.SessionToken != "token" || creds.Expires.Year() != 2030 {
+		t.Errorf("got %+v, %v", creds, err)
+	}
+	if _, err := (&ECSCredentials{AuthToken: "wrong"}).Retrieve(context.Background()); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("wrong token: got error %v", err)
+	}
+}
+
+func TestWebIdentityCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Method != "POST" || r.Form.Get("Action") != "AssumeRoleWithWebIdentity" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/r" || r.Form.Get("WebIdentityToken") != "jwt" ||
+			r.Form.Get("RoleSessionName") == "" || r.URL.RawQuery != "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIA</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2030-01-02T03:04:05Z</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer sts.Close()
+
+	if _, err := (&WebIdentityCredentials{}).Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unset: got error %v, want ErrNoCredentials", err)
+	}
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/r")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	creds, err := (&WebIdentityCredentials{Endpoint: sts.URL}).Retrieve(context.Background())
+	if err != nil || creds.AccessKey != "ASIA" || creds.SecretKey != "secret" || creds.SessionToken != "token" || creds.Expires.Year() != 2030 {
+		t.Errorf("got %+v, %v", creds, err)
+	}
+}
+
+func TestChainCredentials(t *testing.T) {
+	clearCredentialsEnv(t)
+	chain := ChainCredentials{&EnvCredentials{}, &SharedCredentials{Filename: filepath.Join(t.TempDir(), "missing")}}
+	if _, err := chain.Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("none: got error %v, want ErrNoCredentials", err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	if creds, err := chain.Retrieve(context.Background()); err != nil || creds.AccessKey != "AKID" {
+		t.Errorf("env: got %+v, %v", creds, err)
+	}
+
+	// Errors other than ErrNoCredentials are reported if no provider
+	// succeeds.
+	clearCredentialsEnv(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	chain = append(chain, &ECSCredentials{Endpoint: srv.URL})
+	if _, err := chain.Retrieve(context.Background()); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("failing provider: got error %v, want its error", err)
+	}
+
+	// The filesystem signs requests with the chain's credentials.
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{Credentials: DefaultCredentials()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Exists("f")
+	if reqs := f.received(); len(reqs) != 1 || !strings.Contains(reqs[0].Header.Get("Authorization"), "AKID") {
+		t.Errorf("got requests %v, want one signed with AKID", reqs)
+	}
+}
@@ -0,0 +1,171 @@
+package s3vfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sqs/s3"
+)
+
+// Credentials are AWS credentials that requests are signed with.
+type Credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // of temporary credentials; sent as X-Amz-Security-Token
+
+	// Expires is when temporary credentials expire, or the zero time if
+	// the credentials don't expire.
+	Expires time.Time
+}
+
+// A CredentialsProvider supplies the credentials of a filesystem (see
+// Options.Credentials). Retrieve is called for the first request and again
+// whenever the credentials it last returned are about to expire, so it may
+// fetch new credentials each time it is called.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// credentialsExpiryWindow is how long before they expire credentials are
+// refreshed, so that requests are not signed with credentials that expire
+// while they are in flight.
+var credentialsExpiryWindow = 5 * time.Minute
+
+// credentialsCache caches the credentials of a CredentialsProvider until they
+// are about to expire.
+type credentialsCache struct {
+	provider CredentialsProvider
+
+	mu    sync.Mutex
+	creds *Credentials // nil if not yet retrieved
+}
+
+func (c *credentialsCache) get(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds != nil && (c.creds.Expires.IsZero() || time.Now().Add(credentialsExpiryWindow).Before(c.creds.Expires)) {
+		return *c.creds, nil
+	}
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("s3vfs: retrieving credentials: %w", err)
+	}
+	c.creds = &creds
+	return creds, nil
+}
+
+// keys returns the keys to sign requests with: those of Options.Credentials
+// if set, otherwise those of the config.
+func (fs *S3FS) keys(ctx context.Context) (s3.Keys, error) {
+	if fs.creds == nil {
+		return *fs.config.Keys, nil
+	}
+	creds, err := fs.creds.get(ctx)
+	if err != nil {
+		return s3.Keys{}, err
+	}
+	return s3.Keys{AccessKey: creds.AccessKey, SecretKey: creds.SecretKey, SecurityToken: creds.SessionToken}, nil
+}
+
+// EC2RoleCredentials is a CredentialsProvider that retrieves the temporary
+// credentials of the IAM role of the EC2 instance that it runs on from the
+// instance metadata service, using IMDSv2 session tokens.
+type EC2RoleCredentials struct {
+	// Endpoint is the URL of the instance metadata service. If empty,
+	// http://169.254.169.254 is used.
+	Endpoint string
+
+	// Client is the HTTP client used to query the instance metadata
+	// service. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Retrieve implements CredentialsProvider.
+func (p *EC2RoleCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	endpoint := strings.TrimSuffix(p.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+
+	token, err := p.get(ctx, "PUT", endpoint+"/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}})
+	if err != nil {
+		return Credentials{}, err
+	}
+	h := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	const credsPath = "/latest/meta-data/iam/security-credentials/"
+	roles, err := p.get(ctx, "GET", endpoint+credsPath, h)
+	if err != nil {
+		return Credentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return Credentials{}, fmt.Errorf("no IAM role is attached to the EC2 instance")
+	}
+	body, err := p.get(ctx, "GET", endpoint+credsPath+role, h)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var result struct {
+		Code            string
+		Message         string
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Credentials{}, fmt.Errorf("decoding EC2 role credentials: %w", err)
+	}
+	if result.Code != "Success" {
+		return Credentials{}, fmt.Errorf("EC2 role credentials: %s: %s", result.Code, result.Message)
+	}
+	return Credentials{
+		AccessKey:    result.AccessKeyId,
+		SecretKey:    result.SecretAccessKey,
+		SessionToken: result.Token,
+		Expires:      result.Expiration,
+	}, nil
+}
+
+// get issues a request to the instance metadata service and returns the
+// response body.
+func (p *EC2RoleCredentials) get(ctx context.Context, method, url string, h http.Header) ([]byte, error) {
+	return fetch(ctx, p.Client, method, url, h, nil)
+}
+
+// fetch issues a request with client, or http.DefaultClient if it is nil,
+// and returns the response body, or an error if the response status is not
+// 200 OK.
+func fetch(ctx context.Context, client *http.Client, method, url string, h http.Header, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		req.Header = h
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, url, resp.Status)
+	}
+	return data, nil
+}
@@ -0,0 +1,117 @@
+package s3vfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetadataServer serves the temporary credentials of an EC2 instance's
+// IAM role, which expire after expiry. Each retrieval returns new credentials.
+type fakeMetadataServer struct {
+	*httptest.Server
+	expiry time.Duration
+
+	mu          sync.Mutex
+	retrievals  int
+	tokenIssued bool
+}
+
+func newFakeMetadataServer(t *testing.T, expiry time.Duration) *fakeMetadataServer {
+	m := &fakeMetadataServer{expiry: expiry}
+	m.Server = httptest.NewServer(m)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *fakeMetadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds") == "" {
+			http.Error(w, "missing TTL", http.StatusBadRequest)
+			return
+		}
+		m.tokenIssued = true
+		w.Write([]byte("imds-token"))
+		return
+	}
+	if !m.tokenIssued || r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/latest/meta-data/iam/security-credentials/":
+		w.Write([]byte("myrole"))
+	case "/latest/meta-data/iam/security-credentials/myrole":
+		m.retrievals++
+		n := strconv.Itoa(m.retrievals)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Code":            "Success",
+			"AccessKeyId":     "ASIA" + n,
+			"SecretAccessKey": "secret" + n,
+			"Token":           "token" + n,
+			"Expiration":      time.Now().Add(m.expiry).UTC().Format(time.RFC3339),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEC2RoleCredentials(t *testing.T) {
+	for _, test := range []struct {
+		expiry time.Duration
+		want   []string // session tokens of successive requests
+	}{
+		{time.Hour, []string{"token1", "token1", "token1"}},
+		// Credentials that expire within the expiry window are refreshed
+		// for every request.
+		{time.Minute, []string{"token1", "token2", "token3"}},
+	} {
+		m := newFakeMetadataServer(t, test.expiry)
+		f := newFakeS3(t)
+		fs, err := New(f.bucketURL(), f.config(), &Options{Credentials: &EC2RoleCredentials{Endpoint: m.URL}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.put("f", []byte("x"))
+		for range test.want {
+			if _, err := fs.Stat("f"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var got []string
+		for _, req := range f.received() {
+			got = append(got, req.Header.Get("X-Amz-Security-Token"))
+		}
+		if len(got) != len(test.want) {
+			t.Fatalf("expiry %s: got %d requests, want %d", test.expiry, len(got), len(test.want))
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("expiry %s: request %d: got session token %q, want %q", test.expiry, i, got[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestEC2RoleCredentials_error(t *testing.T) {
+	m := httptest.NewServer(http.NotFoundHandler())
+	defer m.Close()
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{Credentials: &EC2RoleCredentials{Endpoint: m.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Open("f"); err == nil {
+		t.Error("got no error for unavailable credentials")
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests without credentials, want 0", n)
+	}
+}
@@ -0,0 +1,81 @@
+package s3vfs
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DirModTimeStrategy determines how the modification time of a directory
+// (which S3 does not have) is computed. See Options.DirModTime.
+type DirModTimeStrategy int
+
+const (
+	// DirModTimeZero reports the zero time for directories.
+	DirModTimeZero DirModTimeStrategy = iota
+
+	// DirModTimeMaxChild reports the modification time of the newest
+	// object anywhere under the directory, so that a directory's ModTime
+	// changes whenever anything in it is written. It lists the whole
+	// directory tree, which takes one request per 1000 objects.
+	DirModTimeMaxChild
+
+	// DirModTimeMarker reports the modification time of the directory's
+	// marker object (see Options.DirMarkers), or the zero time if it has
+	// none. It takes a HEAD request per marker convention tried.
+	DirModTimeMarker
+)
+
+// dirInfo returns the FileInfo of the directory name (a cleaned path
+// without a leading slash).
+func (fs *S3FS) dirInfo(ctx context.Context, name string) (*fileInfo, error) {
+	t, err := fs.dirModTime(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: fs.outPath(name), mode: os.ModeDir, modTime: t}, nil
+}
+
+// dirModTime returns the modification time of the directory name (a cleaned
+// path without a leading slash), according to Options.DirModTime.
+func (fs *S3FS) dirModTime(ctx context.Context, name string) (time.Time, error) {
+	switch fs.opt.DirModTime {
+	case DirModTimeMaxChild:
+		var max time.Time
+		var marker string
+		for {
+			page, err := fs.listPage(ctx, dirPrefix(name), "", marker)
+			if err != nil {
+				return time.Time{}, err
+			}
+			for _, o := range page.Contents {
+				if t := newManifestEntry(o).LastModified; t.After(max) {
+					max = t
+				}
+			}
+			if !page.IsTruncated {
+				return max, nil
+			}
+			marker = page.nextMarker()
+		}
+
+	case DirModTimeMarker:
+		suffixes := []string{"/", "/" + keepMarker, folderMarkerSuffix}
+		if s := fs.opt.DirMarkerSuffix; s != "" {
+			suffixes = append([]string{s}, suffixes...)
+		}
+		for _, suffix := range suffixes {
+			resp, err := fs.headContext(ctx, "/"+name+suffix)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return time.Time{}, err
+			}
+			resp.Body.Close()
+			t, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+			return t, nil
+		}
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,46 @@
+package s3vfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDirModTime(t *testing.T) {
+	f := newFakeS3(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, key := range []string{"d/", "d/a", "d/e/b", "d/c"} {
+		f.put(key, nil)
+		o, _ := f.get(key)
+		o.modTime = base.Add(time.Duration(i) * time.Hour)
+	}
+	// The newest object is in a subdirectory.
+	o, _ := f.get("d/e/b")
+	o.modTime = base.Add(10 * time.Hour)
+
+	tests := map[DirModTimeStrategy]time.Time{
+		DirModTimeZero:     {},
+		DirModTimeMaxChild: base.Add(10 * time.Hour),
+		DirModTimeMarker:   base,
+	}
+	for strategy, want := range tests {
+		fs, err := New(f.bucketURL(), f.config(), &Options{DirModTime: strategy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := fs.Stat("d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(want) {
+			t.Errorf("strategy %d: Stat: got ModTime %s, want %s", strategy, fi.ModTime(), want)
+		}
+
+		fis, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) != 1 || !fis[0].ModTime().Equal(want) {
+			t.Errorf("strategy %d: ReadDir: got %v, want d with ModTime %s", strategy, fis, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package s3vfs
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// DiskCacheFileSystem is a filesystem that keeps copies of the objects
+// opened through it in files in a local directory, so that objects that are
+// opened again and again (e.g., index files) are not downloaded each time.
+// Each Open revalidates the cached copy with a conditional GET on its ETag,
+// so changes made by other clients are seen, but the data of an unchanged
+// object is read from disk instead of being transferred again.
+//
+// The cached copies take up at most a fixed number of bytes; the least
+// recently opened ones are removed to make room for new ones, and objects
+// larger than that are not cached. Only Open uses the cache; other methods
+// are passed through. The index of the cache is held in memory, so the
+// cache starts out empty. It is safe for concurrent use.
+type DiskCacheFileSystem struct {
+	rwvfs.FileSystem
+
+	fs       *S3FS
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*diskCacheEntry // by cacheKey
+	lru     *list.List                 // of *diskCacheEntry, most recently opened first
+	size    int64                      // total size of the cached copies
+}
+
+type diskCacheEntry struct {
+	key  string
+	file string // path of the cached copy
+	etag string
+	size int64
+	elem *list.Element
+}
+
+// diskCacheFilePattern is the pattern of the names of cached copies.
+const diskCacheFilePattern = "s3vfs-cache-*"
+
+// NewDiskCache returns a filesystem that caches the objects of fs that are
+// opened through it in dir, which is created if necessary, using at most
+// maxBytes of disk space. Cached copies left in dir by an earlier
+// DiskCacheFileSystem are removed.
+func NewDiskCache(fs *S3FS, dir string, maxBytes int64) (*DiskCacheFileSystem, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("s3vfs: disk cache size must be positive, not %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, diskCacheFilePattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stale {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	return &DiskCacheFileSystem{
+		FileSystem: fs,
+		fs:         fs,
+		dir:        dir,
+		maxBytes:   maxBytes,
+		entries:    map[string]*diskCacheEntry{},
+		lru:        list.New(),
+	}, nil
+}
+
+func (c *DiskCacheFileSystem) String() string {
+	return fmt.Sprintf("diskcache(%s)", c.fs)
+}
+
+// Open returns the cached copy of the object at name if the object's ETag
+// is unchanged, and otherwise downloads the object into the cache and
+// returns the new copy. Objects that can't be cached (e.g., because they are
+// larger than the cache) are read from the same response, as with
+// (*S3FS).Open.
+func (c *DiskCacheFileSystem) Open(name string) (vfs.ReadSeekCloser, error) {
+	key := cacheKey(name)
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+
+	h := make(http.Header)
+	if e != nil {
+		h.Set("If-None-Match", e.etag)
+	}
+	resp, err := c.fs.get(name, h)
+	if e != nil && errors.Is(err, errNotModified) {
+		if f, ok := c.openEntry(e); ok {
+			return f, nil
+		}
+		// The copy was removed since it was revalidated.
+		resp, err = c.fs.get(name, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength < 0 || resp.ContentLength > c.maxBytes || resp.Header.Get("ETag") == "" {
+		// The object can't be cached, so it is read from the response.
+		return c.fs.newReader(context.Background(), name, resp), nil
+	}
+	return c.store(key, name, resp)
+}
+
+// openEntry opens the cached copy e and marks it as the most recently
+// opened, if it is still in the cache.
+func (c *DiskCacheFileSystem) openEntry(e *diskCacheEntry) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[e.key] != e {
+		return nil, false
+	}
+	f, err := os.Open(e.file)
+	if err != nil {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e.elem)
+	return f, true
+}
+
+// store writes the body of resp, the response to a GET of the object at
+// name, to a new cached copy, replacing any older copy, and returns the new
+// copy opened at its start.
+func (c *DiskCacheFileSystem) store(key, name string, resp *http.Response) (*os.File, error) {
+	defer resp.Body.Close()
+	f, err := ioutil.TempFile(c.dir, diskCacheFilePattern)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if err == nil && n != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, &os.PathError{Op: "read", Path: c.fs.url(name), Err: err}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[key]; old != nil {
+		c.remove(old)
+	}
+	e := &diskCacheEntry{key: key, file: f.Name(), etag: resp.Header.Get("ETag"), size: n}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	c.size += n
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*diskCacheEntry))
+	}
+	return f, nil
+}
+
+// remove removes e from the cache and deletes its file. Files that are open
+// remain readable on systems that allow removing open files.
+func (c *DiskCacheFileSystem) remove(e *diskCacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	os.Remove(e.file)
+}
+
+// Rename renames oldpath to newpath in the underlying filesystem. The
+// cached copies are revalidated by their ETags when they are next opened.
+func (c *DiskCacheFileSystem) Rename(oldpath, newpath string) error {
+	return c.fs.Rename(oldpath, newpath)
+}
+
+// RemoveAll removes path and everything under it in the underlying
+// filesystem. Cached copies of the removed objects are not served again,
+// because each Open first checks the object with a conditional GET.
+func (c *DiskCacheFileSystem) RemoveAll(path string) error {
+	return c.fs.RemoveAll(path)
+}
+
+// Clear removes all cached copies.
+func (c *DiskCacheFileSystem) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back().Value.(*diskCacheEntry))
+	}
+}
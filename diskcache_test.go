@@ -0,0 +1,88 @@
+package s3vfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+)
+
+func TestDiskCache(t *testing.T) {
+	f := newFakeS3(t)
+	dir := t.TempDir()
+	c, err := NewDiskCache(f.fs(), dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := func() int {
+		names, _ := filepath.Glob(filepath.Join(dir, diskCacheFilePattern))
+		return len(names)
+	}
+	// read reads the file at name through the cache and returns the
+	// methods and If-None-Match headers of the requests it made.
+	read := func(name, want string) string {
+		t.Helper()
+		f.reset()
+		data, err := vfs.ReadFile(c, name)
+		if err != nil || string(data) != want {
+			t.Fatalf("%s: got %q, %v; want %q", name, data, err, want)
+		}
+		var reqs []string
+		for _, req := range f.received() {
+			reqs = append(reqs, fmt.Sprintf("%s %q", req.Method, req.Header.Get("If-None-Match")))
+		}
+		return fmt.Sprint(reqs)
+	}
+
+	f.put("a", []byte("aaaa"))
+	o, _ := f.get("a")
+	etag := o.etag()
+	if got, want := read("a", "aaaa"), `[GET ""]`; got != want {
+		t.Errorf("first open: got requests %s, want %s", got, want)
+	}
+	if got, want := read("/a", "aaaa"), fmt.Sprintf("[GET %q]", etag); got != want {
+		t.Errorf("second open: got requests %s, want %s", got, want)
+	}
+	if n := cached(); n != 1 {
+		t.Errorf("got %d cached copies, want 1", n)
+	}
+
+	// A changed object is downloaded again.
+	f.put("a", []byte("AAAA"))
+	read("a", "AAAA")
+	if n := cached(); n != 1 {
+		t.Errorf("after change: got %d cached copies, want 1", n)
+	}
+
+	// The least recently opened copy is evicted to make room.
+	f.put("b", []byte("bbbb"))
+	f.put("c", []byte("cccc"))
+	read("b", "bbbb")
+	read("a", "AAAA")
+	read("c", "cccc")
+	if n := cached(); n != 2 {
+		t.Errorf("after eviction: got %d cached copies, want 2", n)
+	}
+	if got := read("b", "bbbb"); got != `[GET ""]` {
+		t.Errorf("evicted object: got requests %s, want an unconditional GET", got)
+	}
+
+	// Objects larger than the cache are not cached.
+	f.put("big", []byte("0123456789"))
+	read("big", "0123456789")
+	if got := read("big", "0123456789"); got != `[GET ""]` {
+		t.Errorf("large object: got requests %s, want a single GET", got)
+	}
+	if n := cached(); n != 2 {
+		t.Errorf("after large object: got %d cached copies, want 2", n)
+	}
+
+	c.Clear()
+	if n := cached(); n != 0 {
+		t.Errorf("after Clear: got %d cached copies, want 0", n)
+	}
+	if _, err := NewDiskCache(f.fs(), dir, 0); err == nil {
+		t.Error("zero size: got nil error")
+	}
+}
@@ -0,0 +1,37 @@
+package s3vfs
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// BucketURL returns the URL of the named bucket on the S3 or S3-compatible
+// service at endpoint, for use with S3 and New. The endpoint is a URL (e.g.,
+// "http://minio.local:9000") or a host (e.g., "s3.us-west-2.amazonaws.com"),
+// in which case https is used.
+//
+// With path-style addressing, the bucket is the first element of the URL
+// path (e.g., "http://minio.local:9000/mybucket/"), which MinIO, Ceph, and
+// most other S3-compatible services require. Otherwise, the URL is
+// virtual-hosted-style, with the bucket in the host (e.g.,
+// "https://mybucket.s3.us-west-2.amazonaws.com/"), which AWS prefers.
+func BucketURL(endpoint, bucket string, pathStyle bool) (*url.URL, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: must be a host or the URL of one", endpoint)
+	}
+	if bucket == "" || strings.ContainsAny(bucket, "/?#") {
+		return nil, fmt.Errorf("invalid S3 bucket name %q", bucket)
+	}
+	if pathStyle {
+		return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + bucket + "/"}, nil
+	}
+	return &url.URL{Scheme: u.Scheme, Host: bucket + "." + u.Host, Path: "/"}, nil
+}
@@ -0,0 +1,53 @@
+package s3vfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBucketURL(t *testing.T) {
+	tests := []struct {
+		endpoint, bucket string
+		pathStyle        bool
+		want             string
+	}{
+		{"http://minio.local:9000", "b", true, "http://minio.local:9000/b/"},
+		{"https://ceph.example.com/", "b", true, "https://ceph.example.com/b/"},
+		{"s3.us-west-2.amazonaws.com", "b", false, "https://b.s3.us-west-2.amazonaws.com/"},
+		{"s3.us-west-2.amazonaws.com", "b", true, "https://s3.us-west-2.amazonaws.com/b/"},
+		{"http://minio.local:9000/path", "b", true, ""},
+		{"minio.local", "", true, ""},
+		{"minio.local", "a/b", true, ""},
+	}
+	for _, test := range tests {
+		u, err := BucketURL(test.endpoint, test.bucket, test.pathStyle)
+		var got string
+		if err == nil {
+			got = u.String()
+		}
+		if got != test.want {
+			t.Errorf("BucketURL(%q, %q, %v): got %q (error %v), want %q", test.endpoint, test.bucket, test.pathStyle, got, err, test.want)
+		}
+	}
+}
+
+func TestBucketURL_pathStyleRequests(t *testing.T) {
+	f := newFakeS3(t)
+	u, err := BucketURL(f.URL, fakeBucket, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := S3(u, f.config())
+	createFile(t, fs, "a/b", []byte("x"))
+	if _, err := fs.Stat("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadDir("a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range f.received() {
+		if req.Host != strings.TrimPrefix(f.URL, "http://") || !strings.HasPrefix(req.URL.Path, "/"+fakeBucket) {
+			t.Errorf("got request for %s%s, want path-style", req.Host, req.URL.Path)
+		}
+	}
+}
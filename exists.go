@@ -0,0 +1,128 @@
+package s3vfs
+
+import (
+	"context"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Exists reports whether an object exists at path, with a single HEAD
+// request. Unlike Stat, it checks only for the object with exactly that key:
+// directories (which are not objects) are reported as not existing, and a
+// path with a trailing slash refers to a "dir/" marker object. A missing
+// object is not an error; the error is only non-nil if S3 could not say
+// whether the object exists (e.g., because access is denied).
+func (fs *S3FS) Exists(path string) (bool, error) {
+	resp, err := fs.head(path)
+	if err == os.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, &os.PathError{Op: "exists", Path: fs.url(path), Err: err}
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// existsHeadConcurrency is the number of HEAD requests ExistsMany sends
+// concurrently when it falls back to checking keys individually.
+const existsHeadConcurrency = 16
+
+// ExistsMany reports which of keys, which are paths relative to the
+// directory prefix, name existing objects. Directories (which are not
+// objects) are reported as not existing.
+//
+// It lists the objects under prefix and checks membership client-side,
+// which takes far fewer requests than a HEAD per key when the prefix holds
+// relatively few objects. Because the listing is in key order, it stops as
+// soon as every key has been passed. If the listing turns out to need at
+// least as many requests as there are keys left to check, ExistsMany stops
+// listing and checks the remaining keys with concurrent HEAD requests
+// instead, so it never makes many more requests than per-key HEADs would.
+func (fs *S3FS) ExistsMany(prefix string, keys []string) (map[string]bool, error) {
+	dir := dirPrefix(prefix)
+	exists := make(map[string]bool, len(keys))
+	byKey := make(map[string][]string, len(keys)) // object key -> keys naming it
+	for _, k := range keys {
+		exists[k] = false
+		obj := strings.TrimPrefix(pathpkg.Clean("/"+dir+k), "/")
+		if obj == "" {
+			continue // the root directory
+		}
+		byKey[obj] = append(byKey[obj], k)
+	}
+	pending := make([]string, 0, len(byKey)) // sorted object keys not yet checked
+	for obj := range byKey {
+		pending = append(pending, obj)
+	}
+	sort.Strings(pending)
+
+	found := func(obj string) {
+		for _, k := range byKey[obj] {
+			exists[k] = true
+		}
+	}
+
+	var marker string
+	for pages := 0; len(pending) > 0; pages++ {
+		if pages >= len(pending) {
+			if err := fs.headMany(pending, found); err != nil {
+				return nil, &os.PathError{Op: "exists", Path: fs.url(prefix), Err: err}
+			}
+			break
+		}
+
+		page, err := fs.listPage(context.Background(), dir, "", marker)
+		if err != nil {
+			return nil, &os.PathError{Op: "exists", Path: fs.url(prefix), Err: err}
+		}
+		for _, o := range page.Contents {
+			if _, ok := byKey[o.Key]; ok {
+				found(o.Key)
+			}
+		}
+		if !page.IsTruncated {
+			break
+		}
+		marker = page.nextMarker()
+		// Keys up to the marker have been listed.
+		i := sort.Search(len(pending), func(i int) bool { return pending[i] > marker })
+		pending = pending[i:]
+	}
+	return exists, nil
+}
+
+// headMany sends HEAD requests for the objects with the given keys
+// concurrently and calls found (serially) for each one that exists.
+func (fs *S3FS) headMany(objs []string, found func(obj string)) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs errorList
+		sem  = make(chan struct{}, existsHeadConcurrency)
+	)
+	for _, obj := range objs {
+		obj := obj
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := fs.head("/" + obj)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				resp.Body.Close()
+				found(obj)
+			case !os.IsNotExist(err):
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errs.err()
+}
@@ -0,0 +1,96 @@
+package s3vfs
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExists(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("a", nil)
+	f.put("d/e", nil)
+
+	for path, want := range map[string]bool{
+		"a":       true,
+		"missing": false,
+		"d":       false, // a directory, not an object
+		"d/":      false,
+		"d/e":     true,
+	} {
+		got, err := fs.Exists(path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+	if n := len(f.received()); n != 5 {
+		t.Errorf("got %d requests, want 5", n)
+	}
+
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusForbidden, Body: http.NoBody, Request: r}, nil
+	})}
+	fs = S3(f.bucketURL(), config).(*S3FS)
+	if ok, err := fs.Exists("a"); ok || err == nil {
+		t.Errorf("access denied: got %v, %v, want error", ok, err)
+	}
+}
+
+func TestExistsMany(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 2
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 10; i++ {
+		f.put(fmt.Sprintf("p/%d", i), nil)
+	}
+	f.put("p/d/e", nil)
+	f.put("q", nil)
+
+	countRequests := func() (lists, heads int) {
+		for _, req := range f.received() {
+			switch req.Method {
+			case "GET":
+				lists++
+			case "HEAD":
+				heads++
+			}
+		}
+		return
+	}
+
+	tests := []struct {
+		keys         []string
+		lists, heads int
+	}{
+		// The listing stops after the last key.
+		{[]string{"0", "1", "2", "3"}, 2, 0},
+		// Listing further would take more requests than there are keys
+		// left, so HEADs are used.
+		{[]string{"8", "9"}, 2, 2},
+		{[]string{"0", "d", "d/e", "missing", "q"}, 4, 4},
+	}
+	for _, test := range tests {
+		f.reset()
+		got, err := fs.ExistsMany("/p", test.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]bool{}
+		for _, k := range test.keys {
+			want[k] = k == "d/e" || len(k) == 1 && k[0] >= '0' && k[0] <= '9'
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", test.keys, got, want)
+		}
+		if lists, heads := countRequests(); lists != test.lists || heads != test.heads {
+			t.Errorf("%v: got %d lists and %d HEADs, want %d and %d", test.keys, lists, heads, test.lists, test.heads)
+		}
+	}
+}
@@ -0,0 +1,720 @@
+package s3vfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sqs/s3"
+	"github.com/sqs/s3/s3util"
+)
+
+const fakeBucket = "testbucket"
+
+// fakeS3 is an in-memory, path-style S3 server used by tests that don't
+// require a real bucket. It implements just enough of the S3 REST API for
+// the requests this package issues.
+type fakeS3 struct {
+	*httptest.Server
+
+	region string // reported by GetBucketLocation
+
+	// visibilityLag is the number of GET or HEAD requests for which a newly
+	// written object still appears not to exist, simulating an eventually
+	// consistent store.
+	visibilityLag int
+
+	// slowDowns is the number of upcoming requests to reject with 503 Slow
+	// Down.
+	slowDowns int
+
+	// tooManyRequests, if set, makes throttled requests fail with 429 Too
+	// Many Requests instead, with the given Retry-After header (if any).
+	tooManyRequests bool
+	retryAfter      string
+
+	// serverErrors is the number of upcoming requests to reject with 500
+	// Internal Error.
+	serverErrors int
+
+	// corruptWrites is the number of upcoming PUT requests whose body is
+	// corrupted (in transit) before it is received.
+	corruptWrites int
+
+	// corruptReads makes GET responses carry corrupted data.
+	corruptReads bool
+
+	// undeletable is the set of keys that DeleteObjects fails to delete.
+	undeletable map[string]bool
+
+	mu        sync.Mutex
+	objects   map[string]*fakeObject
+	uploads   map[string]map[int][]byte
+	checksums map[string]string        // checksum algorithm per upload ID
+	versions  map[string][]*fakeObject // all versions per key, oldest first
+	lagging   map[string]int           // remaining lagged reads per key
+	requests  []*http.Request          // requests received, in order
+
+	nextVersion int // number of the last version ID assigned
+}
+
+type fakeObject struct {
+	data      []byte
+	header    http.Header
+	modTime   time.Time
+	versionID string
+	tagging   string // X-Amz-Tagging header it was created with
+}
+
+func (o *fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (o *fakeObject) storageClass() string {
+	if sc := o.header.Get("X-Amz-Storage-Class"); sc != "" {
+		return sc
+	}
+	return "STANDARD"
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	f := newUnstartedFakeS3(t)
+	f.Start()
+	return f
+}
+
+// newUnstartedFakeS3 returns a fake S3 server that the caller must start
+// (e.g., with StartTLS).
+func newUnstartedFakeS3(t *testing.T) *fakeS3 {
+	f := &fakeS3{
+		objects:   map[string]*fakeObject{},
+		versions:  map[string][]*fakeObject{},
+		uploads:   map[string]map[int][]byte{},
+		checksums: map[string]string{},
+		lagging:   map[string]int{},
+	}
+	f.Server = httptest.NewUnstartedServer(f)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// bucketURL returns the URL of the fake bucket.
+func (f *fakeS3) bucketURL() *url.URL {
+	u, _ := url.Parse(f.URL + "/" + fakeBucket)
+	return u
+}
+
+// fs returns a filesystem backed by the fake bucket.
+func (f *fakeS3) fs() *S3FS {
+	return S3(f.bucketURL(), f.config()).(*S3FS)
+}
+
+func (f *fakeS3) config() *s3util.Config {
+	return &s3util.Config{
+		Keys:    &s3.Keys{AccessKey: "key", SecretKey: "secret"},
+		Service: s3.DefaultService,
+	}
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.store(key, &fakeObject{data: data, header: http.Header{}, modTime: time.Now().UTC()})
+}
+
+// store makes o the current version of the object at key.
+func (f *fakeS3) store(key string, o *fakeObject) {
+	f.nextVersion++
+	o.versionID = "v" + strconv.Itoa(f.nextVersion)
+	f.objects[key] = o
+	f.versions[key] = append(f.versions[key], o)
+}
+
+// version returns the version of the object at key with the given ID.
+func (f *fakeS3) version(key, id string) (*fakeObject, bool) {
+	for _, o := range f.versions[key] {
+		if o.versionID == id {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeS3) get(key string) (*fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.objects[key]
+	return o, ok
+}
+
+// reset clears the log of received requests.
+func (f *fakeS3) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+}
+
+func (f *fakeS3) received() []*http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*http.Request(nil), f.requests...)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+
+	if f.serverErrors > 0 {
+		f.serverErrors--
+		fakeError(w, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	if f.slowDowns > 0 {
+		f.slowDowns--
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		if f.tooManyRequests {
+			fakeError(w, http.StatusTooManyRequests, "TooManyRequests")
+		} else {
+			fakeError(w, http.StatusServiceUnavailable, "SlowDown")
+		}
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") == "aws-chunked" {
+		var ok bool
+		if body, ok = decodeAWSChunked(body, r.Header); !ok {
+			fakeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+	}
+	if r.Method == "PUT" && f.corruptWrites > 0 && len(body) > 0 {
+		f.corruptWrites--
+		body[0] ^= 0xff
+	}
+	if r.Method == "PUT" && !checksumsMatch(r.Header, body) {
+		fakeError(w, http.StatusBadRequest, "BadDigest")
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if p != fakeBucket && !strings.HasPrefix(p, fakeBucket+"/") {
+		fakeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(p, fakeBucket), "/")
+	q := r.URL.Query()
+
+	if key == "" {
+		if r.Method == "GET" && q["location"] != nil {
+			writeXML(w, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				Region  string   `xml:",chardata"`
+			}{Region: f.region})
+			return
+		}
+		if r.Method == "GET" {
+			f.list(w, q)
+			return
+		}
+		if r.Method == "POST" && q["delete"] != nil {
+			f.deleteObjects(w, body)
+			return
+		}
+		fakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && q["tagging"] != nil:
+		o, ok := f.objects[key]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		type tag struct{ Key, Value string }
+		var tags []tag
+		vs, _ := url.ParseQuery(o.tagging)
+		for k := range vs {
+			tags = append(tags, tag{k, vs.Get(k)})
+		}
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"Tagging"`
+			Tags    []tag    `xml:"TagSet>Tag"`
+		}{Tags: tags})
+	case r.Method == "POST" && q["uploads"] != nil:
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadId string
+		}{UploadId: id})
+		f.objects["\x00upload/"+id] = &fakeObject{header: objectHeader(r.Header), tagging: r.Header.Get("X-Amz-Tagging")}
+		f.checksums[id] = r.Header.Get("X-Amz-Checksum-Algorithm")
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copy(w, r, key)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if u := f.objects["\x00upload/"+q.Get("uploadId")]; !hasCustomerKey(u.header, r.Header, "X-Amz-Server-Side-Encryption-Customer-") {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		parts[n] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		id := q.Get("uploadId")
+		parts, ok := f.uploads[id]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if _, exists := f.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if f.checksums[id] != "" {
+			var complete struct {
+				Part []struct{ ChecksumCRC32C, ChecksumSHA256 string }
+			}
+			xml.Unmarshal(body, &complete)
+			for _, part := range complete.Part {
+				if part.ChecksumCRC32C == "" && part.ChecksumSHA256 == "" {
+					fakeError(w, http.StatusBadRequest, "InvalidRequest")
+					return
+				}
+			}
+		}
+		var nums []int
+		for n := range parts {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		var data []byte
+		for _, n := range nums {
+			data = append(data, parts[n]...)
+		}
+		o := f.objects["\x00upload/"+id]
+		delete(f.objects, "\x00upload/"+id)
+		delete(f.uploads, id)
+		o.data, o.modTime = data, time.Now().UTC()
+		f.store(key, o)
+		f.lagging[key] = f.visibilityLag
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Key     string
+			ETag    string
+		}{Key: key, ETag: o.etag()})
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		delete(f.uploads, q.Get("uploadId"))
+		delete(f.objects, "\x00upload/"+q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		cur, exists := f.objects[key]
+		if im := r.Header.Get("If-Match"); im != "" && (!exists || cur.etag() != im) ||
+			r.Header.Get("If-None-Match") == "*" && exists {
+			fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		f.store(key, &fakeObject{data: body, header: objectHeader(r.Header), modTime: time.Now().UTC(), tagging: r.Header.Get("X-Amz-Tagging")})
+		f.lagging[key] = f.visibilityLag
+		w.Header().Set("ETag", f.objects[key].etag())
+	case r.Method == "DELETE" && q.Get("versionId") != "":
+		vs := f.versions[key]
+		for i, o := range vs {
+			if o.versionID == q.Get("versionId") {
+				vs = append(vs[:i:i], vs[i+1:]...)
+				break
+			}
+		}
+		f.versions[key] = vs
+		if o, ok := f.objects[key]; ok && o.versionID == q.Get("versionId") {
+			if len(vs) > 0 {
+				f.objects[key] = vs[len(vs)-1]
+			} else {
+				delete(f.objects, key)
+			}
+		}
+		w.Header().Set("X-Amz-Version-Id", q.Get("versionId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" || r.Method == "HEAD":
+		o, ok := f.objects[key]
+		if id := q.Get("versionId"); id != "" {
+			if o, ok = f.version(key, id); !ok {
+				fakeError(w, http.StatusNotFound, "NoSuchVersion")
+				return
+			}
+		}
+		if f.lagging[key] > 0 {
+			f.lagging[key]--
+			ok = false
+		}
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if im := r.Header.Get("If-Match"); im != "" && im != o.etag() {
+			fakeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if !hasCustomerKey(o.header, r.Header, "X-Amz-Server-Side-Encryption-Customer-") {
+			fakeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		if inm := r.Header.Get("If-None-Match"); inm != "" && inm == o.etag() {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil &&
+			r.Header.Get("If-None-Match") == "" && !o.modTime.Truncate(time.Second).After(ims) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		for k, v := range o.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", o.etag())
+		w.Header().Set("X-Amz-Version-Id", o.versionID)
+		w.Header().Set("Last-Modified", o.modTime.Format(http.TimeFormat))
+		data := o.data
+		if f.corruptReads && len(data) > 0 {
+			data = append([]byte{data[0] ^ 0xff}, data[1:]...)
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			start, end, err := resolveRange(rng, int64(len(data)))
+			if err != nil {
+				fakeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			if r.Method == "GET" {
+				w.Write(data[start : end+1])
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	default:
+		fakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// deleteObjects handles DeleteObjects requests.
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, body []byte) {
+	var req struct {
+		Quiet   bool
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		fakeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	type deleteError struct{ Key, Code, Message string }
+	var res struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Deleted []struct{ Key string }
+		Errors  []deleteError `xml:"Error"`
+	}
+	for _, o := range req.Objects {
+		if f.undeletable[o.Key] {
+			res.Errors = append(res.Errors, deleteError{o.Key, "AccessDenied", "Access Denied"})
+			continue
+		}
+		delete(f.objects, o.Key)
+		if !req.Quiet {
+			res.Deleted = append(res.Deleted, struct{ Key string }{o.Key})
+		}
+	}
+	writeXML(w, res)
+}
+
+// copy handles CopyObject and UploadPartCopy requests.
+func (f *fakeS3) copy(w http.ResponseWriter, r *http.Request, key string) {
+	src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	o, ok := f.objects[strings.TrimPrefix(src, "/"+fakeBucket+"/")]
+	if !ok {
+		fakeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if !hasCustomerKey(o.header, r.Header, "X-Amz-Copy-Source-Server-Side-Encryption-Customer-") {
+		fakeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	q := r.URL.Query()
+
+	if id := q.Get("uploadId"); id != "" {
+		parts, ok := f.uploads[id]
+		if !ok {
+			fakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		data := o.data
+		if rng := r.Header.Get("X-Amz-Copy-Source-Range"); rng != "" {
+			start, end, err := resolveRange(rng, int64(len(data)))
+			if err != nil {
+				fakeError(w, http.StatusBadRequest, "InvalidArgument")
+				return
+			}
+			data = data[start : end+1]
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		parts[n] = data
+		sum := md5.Sum(data)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyPartResult"`
+			ETag    string
+		}{ETag: `"` + hex.EncodeToString(sum[:]) + `"`})
+		return
+	}
+
+	header := objectHeader(r.Header)
+	if r.Header.Get("X-Amz-Metadata-Directive") != "REPLACE" {
+		// Only the attributes that are not metadata may be changed.
+		for k, v := range o.header {
+			if _, ok := header[k]; !ok || !isCopySettable(k) {
+				header[k] = v
+			}
+		}
+	}
+	n := &fakeObject{data: o.data, header: header, modTime: time.Now().UTC(), tagging: o.tagging}
+	f.store(key, n)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		ETag    string
+	}{ETag: n.etag()})
+}
+
+// isCopySettable reports whether the object header k may be set by a
+// CopyObject request whose metadata directive is COPY.
+func isCopySettable(k string) bool {
+	k = strings.ToLower(k)
+	return k == "x-amz-storage-class" || strings.HasPrefix(k, "x-amz-server-side-encryption")
+}
+
+// resolveRange returns the first and last byte offsets of the range described
+// by the Range header value rng in an object of the given size.
+func resolveRange(rng string, size int64) (start, end int64, err error) {
+	start, end, err = parseRangeHeader(rng)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case start < 0:
+		start, end = size-end, size-1
+		if start < 0 {
+			start = 0
+		}
+	case end < 0 || end >= size:
+		end = size - 1
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("range %q not satisfiable", rng)
+	}
+	return start, end, nil
+}
+
+// list implements the ListObjects operation.
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	prefix, delim, marker := q.Get("prefix"), q.Get("delimiter"), q.Get("marker")
+	maxKeys := 1000
+	if s := q.Get("max-keys"); s != "" {
+		maxKeys, _ = strconv.Atoi(s)
+	}
+
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) && !strings.HasPrefix(k, "\x00") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct{ Prefix string }
+	var res struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Prefix         string
+		Marker         string
+		NextMarker     string `xml:",omitempty"`
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}
+	res.Prefix, res.Marker = prefix, marker
+
+	seen := map[string]bool{}
+	n := 0
+	for _, k := range keys {
+		if k <= marker {
+			continue
+		}
+		entry := k
+		isPrefix := false
+		if delim != "" {
+			if i := strings.Index(k[len(prefix):], delim); i >= 0 {
+				entry = k[:len(prefix)+i+len(delim)]
+				isPrefix = true
+			}
+		}
+		if isPrefix && (seen[entry] || entry <= marker) {
+			continue
+		}
+		if n == maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		n++
+		if isPrefix {
+			seen[entry] = true
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{entry})
+		} else {
+			o := f.objects[k]
+			res.Contents = append(res.Contents, content{
+				Key:          k,
+				LastModified: o.modTime.Format(time.RFC3339Nano),
+				ETag:         o.etag(),
+				Size:         int64(len(o.data)),
+				StorageClass: o.storageClass(),
+			})
+		}
+		res.NextMarker = entry
+	}
+	if !res.IsTruncated {
+		res.NextMarker = ""
+	}
+	writeXML(w, res)
+}
+
+// objectHeader returns the subset of request headers that S3 stores with an
+// object and returns on GET and HEAD.
+func objectHeader(h http.Header) http.Header {
+	oh := http.Header{}
+	for k, v := range h {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "content-") && lk != "content-length" && lk != "content-md5" ||
+			strings.HasPrefix(lk, "x-amz-meta-") || lk == "cache-control" || lk == "expires" ||
+			lk == "x-amz-storage-class" || strings.HasPrefix(lk, "x-amz-server-side-encryption") &&
+			lk != "x-amz-server-side-encryption-customer-key" {
+			oh[k] = v
+		}
+	}
+	return oh
+}
+
+// hasCustomerKey reports whether the request header h gives the
+// customer-provided key (with the SSE-C headers with the given prefix) that
+// the object whose header is oh is encrypted with, if it is encrypted with
+// one.
+func hasCustomerKey(oh, h http.Header, prefix string) bool {
+	want := oh.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5")
+	if want == "" {
+		return h.Get(prefix+"Key") == ""
+	}
+	key, _ := base64.StdEncoding.DecodeString(h.Get(prefix + "Key"))
+	sum := md5.Sum(key)
+	return h.Get(prefix+"Key-Md5") == want && base64.StdEncoding.EncodeToString(sum[:]) == want
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	xml.NewEncoder(&buf).Encode(v)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf.Bytes())
+}
+
+// decodeAWSChunked decodes an aws-chunked request body, adding its trailers
+// to h. It reports whether body is well formed.
+func decodeAWSChunked(body []byte, h http.Header) ([]byte, bool) {
+	var data []byte
+	for {
+		i := bytes.Index(body, []byte("\r\n"))
+		if i < 0 {
+			return nil, false
+		}
+		n, err := strconv.ParseInt(string(body[:i]), 16, 64)
+		if err != nil || int64(len(body)) < int64(i)+2+n {
+			return nil, false
+		}
+		body = body[i+2:]
+		if n == 0 {
+			break
+		}
+		data = append(data, body[:n]...)
+		if !bytes.HasPrefix(body[n:], []byte("\r\n")) {
+			return nil, false
+		}
+		body = body[n+2:]
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(body), "\r\n\r\n"), "\r\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, false
+		}
+		h.Set(kv[0], kv[1])
+	}
+	return data, true
+}
+
+// checksumsMatch reports whether the checksum headers in h (if any) match
+// body.
+func checksumsMatch(h http.Header, body []byte) bool {
+	if v := h.Get("Content-MD5"); v != "" {
+		sum := md5.Sum(body)
+		if v != base64.StdEncoding.EncodeToString(sum[:]) {
+			return false
+		}
+	}
+	for alg, name := range checksumHeaders {
+		if v := h.Get(name); v != "" {
+			sum := newChecksumHash(alg)
+			sum.Write(body)
+			if v != base64.StdEncoding.EncodeToString(sum.Sum(nil)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func fakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message><RequestId>fake</RequestId></Error>", xml.Header, code, code)
+}
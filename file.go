@@ -0,0 +1,246 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PutFile uploads the local file at localPath to the object at path, with
+// the attributes specified in opt (which may be nil). The file is streamed
+// to S3 directly, without being buffered in memory: with a single PUT if it
+// is no larger than the part size (see Options.PartSize), and otherwise with
+// a multipart upload of sections of the file.
+//
+// If the local file can't be opened (e.g., it doesn't exist), the error is
+// the *os.PathError from opening it.
+func (fs *S3FS) PutFile(path, localPath string, opt *WriteOptions) error {
+	if err := opt.check(); err != nil {
+		return &os.PathError{Op: "putfile", Path: fs.url(path), Err: err}
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	w := fs.newWriter(path, opt.header())
+	if opt != nil {
+		w.progress, w.total = opt.Progress, size
+	}
+	if size <= fs.partSize() {
+		if err := w.putBody(f, size); err != nil {
+			return &os.PathError{Op: "putfile", Path: fs.url(path), Err: err}
+		}
+		return nil
+	}
+
+	for off := int64(0); off < size; off += fs.partSize() {
+		n := fs.partSize()
+		if off+n > size {
+			n = size - off
+		}
+		if err := w.uploadPart(io.NewSectionReader(f, off, n), n); err != nil {
+			w.abort()
+			return &os.PathError{Op: "putfile", Path: fs.url(path), Err: err}
+		}
+	}
+	if err := w.complete(); err != nil {
+		w.abort()
+		return &os.PathError{Op: "putfile", Path: fs.url(path), Err: err}
+	}
+	return nil
+}
+
+// ReadFile returns the contents of the object at path, read with a single
+// GET request.
+func (fs *S3FS) ReadFile(path string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fs.download(path, &buf); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			err = &os.PathError{Op: "read", Path: fs.url(path), Err: err}
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile creates (or replaces) the object at path with the contents data:
+// with a single PUT if data is no larger than the part size (see
+// Options.PartSize), and otherwise with a multipart upload. It returns once
+// the object is written.
+func (fs *S3FS) WriteFile(path string, data []byte) error {
+	if fs.opt.ReadOnly {
+		return &os.PathError{Op: "create", Path: fs.url(path), Err: ErrReadOnly}
+	}
+	w := fs.newWriter(path, make(http.Header))
+	if int64(len(data)) <= fs.partSize() {
+		if err := w.putBody(bytes.NewReader(data), int64(len(data))); err != nil {
+			return w.fail(err)
+		}
+		return nil
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// defaultGetFileConcurrency is the number of parts GetFile downloads
+// concurrently if ReadOptions.Concurrency is not set.
+const defaultGetFileConcurrency = 4
+
+// ReadOptions specifies how GetFile downloads an object.
+type ReadOptions struct {
+	// PreserveModTime sets the modification time of the local file to the
+	// object's Last-Modified time.
+	PreserveModTime bool
+
+	// Concurrency is the maximum number of parts of a large object that
+	// are downloaded concurrently. If zero, 4 is used.
+	Concurrency int
+}
+
+// GetFile downloads the object at path to the local file at localPath,
+// creating its parent directories as needed, as specified in opt (which may
+// be nil). The object is streamed to disk without being held in memory.
+// Objects larger than the part size (see Options.PartSize) are downloaded in
+// parts of that size, several at a time, with ranged GETs that are
+// conditional on the object not changing in the meantime.
+//
+// The object is downloaded to a temporary file in the same directory, which
+// is renamed to localPath once the download is complete, so localPath never
+// contains a partial download.
+func (fs *S3FS) GetFile(path, localPath string, opt *ReadOptions) error {
+	if opt == nil {
+		opt = &ReadOptions{}
+	}
+	resp, err := fs.head(path)
+	if err != nil {
+		return &os.PathError{Op: "getfile", Path: fs.url(path), Err: err}
+	}
+	resp.Body.Close()
+	size := resp.ContentLength
+	modTime, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(localPath), "."+filepath.Base(localPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly after the rename
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+
+	if size > fs.partSize() {
+		err = fs.downloadParts(path, f, size, resp.Header.Get("ETag"), opt.Concurrency)
+	} else {
+		err = fs.download(path, f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return &os.PathError{Op: "getfile", Path: fs.url(path), Err: err}
+	}
+
+	if opt.PreserveModTime && !modTime.IsZero() {
+		if err := os.Chtimes(f.Name(), modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.Name(), localPath)
+}
+
+// download copies the whole object at path to w.
+func (fs *S3FS) download(path string, w io.Writer) error {
+	resp, err := fs.get(path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// downloadParts copies the size-byte object at path, whose ETag is etag, to
+// w in parts, with up to concurrency parts being downloaded at once.
+func (fs *S3FS) downloadParts(path string, w io.WriterAt, size int64, etag string, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = defaultGetFileConcurrency
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs errorList
+		sem  = make(chan struct{}, concurrency)
+	)
+	for off := int64(0); off < size; off += fs.partSize() {
+		end := off + fs.partSize() - 1
+		if end >= size {
+			end = size - 1
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(off, end int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			h := make(http.Header)
+			h.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+			if etag != "" {
+				h.Set("If-Match", etag)
+			}
+			err := fs.downloadRange(ctx, path, h, io.NewOffsetWriter(w, off))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && ctx.Err() == nil {
+				// Only the first error is recorded; the other downloads
+				// fail because they are canceled.
+				errs = append(errs, err)
+				cancel()
+			}
+		}(off, end)
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+// downloadRange copies the range of the object at path given by the Range
+// header in h to w.
+func (fs *S3FS) downloadRange(ctx context.Context, path string, h http.Header, w io.Writer) error {
+	resp, err := fs.getContext(ctx, path, h)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("got status %d for ranged GET, want %d", resp.StatusCode, http.StatusPartialContent)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
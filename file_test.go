@@ -0,0 +1,131 @@
+package s3vfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutFile(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	for _, data := range []string{"", "abc", "0123456789"} {
+		local := filepath.Join(dir, "f")
+		if err := ioutil.WriteFile(local, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		f.reset()
+		if err := fs.PutFile("f", local, &WriteOptions{ContentType: "text/plain"}); err != nil {
+			t.Fatalf("%q: %s", data, err)
+		}
+		o, _ := f.get("f")
+		if !bytes.Equal(o.data, []byte(data)) {
+			t.Errorf("%q: got data %q", data, o.data)
+		}
+		if got := o.header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("%q: got Content-Type %q", data, got)
+		}
+		var parts int
+		for _, req := range f.received() {
+			if req.URL.Query().Get("partNumber") != "" {
+				parts++
+			}
+		}
+		if wantParts := map[string]int{"0123456789": 3}[data]; parts != wantParts {
+			t.Errorf("%q: got %d parts, want %d", data, parts, wantParts)
+		}
+	}
+
+	if err := fs.PutFile("g", filepath.Join(dir, "missing"), nil); !os.IsNotExist(err) {
+		t.Errorf("missing local file: got error %v, want not exist", err)
+	}
+}
+
+func TestGetFile(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dir := t.TempDir()
+
+	for _, data := range []string{"", "abc", "0123456789"} {
+		f.put("f", []byte(data))
+		o, _ := f.get("f")
+		o.modTime = modTime
+		f.reset()
+
+		local := filepath.Join(dir, "a", "b", "f")
+		if err := fs.GetFile("f", local, &ReadOptions{PreserveModTime: true, Concurrency: 2}); err != nil {
+			t.Fatalf("%q: %s", data, err)
+		}
+		got, err := ioutil.ReadFile(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("%q: got local contents %q", data, got)
+		}
+		fi, _ := os.Stat(local)
+		if !fi.ModTime().Equal(modTime) {
+			t.Errorf("%q: got local mod time %s, want %s", data, fi.ModTime(), modTime)
+		}
+
+		var ranged int
+		for _, req := range f.received() {
+			if req.Header.Get("Range") != "" {
+				ranged++
+				if req.Header.Get("If-Match") != o.etag() {
+					t.Errorf("%q: ranged GET without If-Match", data)
+				}
+			}
+		}
+		if wantRanged := map[string]int{"0123456789": 3}[data]; ranged != wantRanged {
+			t.Errorf("%q: got %d ranged GETs, want %d", data, ranged, wantRanged)
+		}
+	}
+
+	if err := fs.GetFile("missing", filepath.Join(dir, "missing"), nil); !os.IsNotExist(err) {
+		t.Errorf("missing object: got error %v, want not exist", err)
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Join(dir, "a", "b")); len(entries) != 1 {
+		t.Errorf("got %d files in the download directory, want 1 (no temporary files left)", len(entries))
+	}
+}
+
+func TestReadWriteFile(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"", "small", "larger than one part"} {
+		if err := fs.WriteFile("f", []byte(data)); err != nil {
+			t.Fatalf("%q: %s", data, err)
+		}
+		got, err := fs.ReadFile("f")
+		if err != nil {
+			t.Fatalf("%q: %s", data, err)
+		}
+		if string(got) != data {
+			t.Errorf("got %q, want %q", got, data)
+		}
+	}
+
+	if _, err := fs.ReadFile("missing"); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want os.IsNotExist-satisfying", err)
+	}
+	f.corruptWrites = 1
+	if err := fs.WriteFile("g", []byte("x")); err == nil {
+		t.Error("got nil error for failed write")
+	}
+}
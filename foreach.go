@@ -0,0 +1,95 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// ForEachObject calls fn for each object whose key begins with prefix, in
+// listing order, with the object's path (in the form chosen by
+// Options.PathStyle) and body. While fn processes one object, the bodies of
+// up to concurrency-1 following objects are downloaded in the background,
+// so that downloading overlaps processing. Bodies are read fully into
+// memory before fn is called.
+//
+// Directory marker objects (see Options.DirMarkers) are skipped.
+//
+// If fn returns an error, iteration stops, the outstanding downloads are
+// canceled, and the error is returned. fn need not close r.
+func (fs *S3FS) ForEachObject(ctx context.Context, prefix string, concurrency int, fn func(path string, r io.ReadCloser) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// Don't return until all goroutines have exited, after canceling them.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type fetch struct {
+		path string
+		done chan struct{} // closed when data and err are set
+		data []byte
+		err  error
+	}
+
+	entries, errc := fs.ManifestStream(ctx, prefix)
+	sem := make(chan struct{}, concurrency) // limits the bodies held at once
+	queue := make(chan *fetch, concurrency)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(queue)
+		for e := range entries {
+			if isDirMarker(e.Key) {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			f := &fetch{path: e.Key, done: make(chan struct{})}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(f.done)
+				f.data, f.err = fs.readObject(ctx, f.path)
+			}()
+			queue <- f // never blocks, because of sem
+		}
+	}()
+
+	for f := range queue {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if f.err != nil {
+			return f.err
+		}
+		err := fn(fs.outPath(f.path), nopCloser{bytes.NewReader(f.data)})
+		<-sem
+		if err != nil {
+			return err
+		}
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// readObject returns the contents of the object at name.
+func (fs *S3FS) readObject(ctx context.Context, name string) ([]byte, error) {
+	resp, err := fs.getContext(ctx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
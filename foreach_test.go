@@ -0,0 +1,102 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestForEachObject(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 10; i++ {
+		f.put(fmt.Sprintf("p/%d", i), []byte(fmt.Sprint(i)))
+	}
+	f.put("other", nil)
+
+	// objectGETs returns the number of GET requests for objects (not
+	// listings) received so far.
+	objectGETs := func() (n int) {
+		for _, req := range f.received() {
+			if req.Method == "GET" && req.URL.RawQuery == "" {
+				n++
+			}
+		}
+		return n
+	}
+
+	var got []string
+	err := fs.ForEachObject(context.Background(), "p/", 3, func(path string, r io.ReadCloser) error {
+		if len(got) == 0 {
+			// The following objects are prefetched while the first is
+			// processed.
+			for i := 0; objectGETs() < 3; i++ {
+				if i == 100 {
+					t.Fatalf("got %d object GETs while processing the first object, want 3", objectGETs())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		got = append(got, path+"="+string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[p/0=0 p/1=1 p/2=2 p/3=3 p/4=4 p/5=5 p/6=6 p/7=7 p/8=8 p/9=9]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestForEachObject_dirMarkers(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	for _, key := range []string{"d/", "d/a", "d/e/", "d/e/.keep", "d/e/b", "d/g_$folder$", "d/g/c"} {
+		f.put(key, []byte(key))
+	}
+
+	var got []string
+	err := fs.ForEachObject(context.Background(), "d", 2, func(path string, r io.ReadCloser) error {
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[d/a d/e/b d/g/c]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestForEachObject_fnError(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 10; i++ {
+		f.put(fmt.Sprint(i), nil)
+	}
+
+	errStop := errors.New("stop")
+	var calls int
+	err := fs.ForEachObject(context.Background(), "", 2, func(path string, r io.ReadCloser) error {
+		calls++
+		if calls == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("got error %v, want %v", err, errStop)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+}
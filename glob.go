@@ -0,0 +1,97 @@
+package s3vfs
+
+import (
+	"context"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+// Glob returns the paths of all files and directories matching pattern, in
+// the form chosen by Options.PathStyle. The pattern syntax is that of
+// path.Match, applied to each slash-separated element of the path; a leading
+// slash in pattern is ignored. Directories are listed only as far as the
+// pattern requires.
+func (fs *S3FS) Glob(pattern string) ([]string, error) {
+	if _, err := pathpkg.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	pattern = strings.TrimPrefix(pathpkg.Clean("/"+pattern), "/")
+	if pattern == "" {
+		return []string{fs.outPath("")}, nil
+	}
+	matches, err := fs.glob("", strings.Split(pattern, "/"))
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matches {
+		matches[i] = fs.outPath(m)
+	}
+	return matches, nil
+}
+
+// glob returns the paths below dir that match the path elements of a
+// pattern. Literal elements are not listed, and the listing for an element
+// with magic characters only includes the names that start with its literal
+// prefix (e.g., "ab" for "ab*.txt").
+func (fs *S3FS) glob(dir string, elems []string) ([]string, error) {
+	if !hasMeta(elems[0]) {
+		p := pathpkg.Join(dir, elems[0])
+		if len(elems) > 1 {
+			return fs.glob(p, elems[1:])
+		}
+		if _, err := fs.lstat(context.Background(), p); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []string{p}, nil
+	}
+
+	fis, err := fs.readDir(context.Background(), dir, literalPrefix(elems[0]))
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, fi := range fis {
+		if ok, _ := pathpkg.Match(elems[0], fi.Name()); !ok {
+			continue
+		}
+		p := pathpkg.Join(dir, fi.Name())
+		if len(elems) == 1 {
+			matches = append(matches, p)
+		} else if fi.IsDir() {
+			sub, err := fs.glob(p, elems[1:])
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, sub...)
+		}
+	}
+	return matches, nil
+}
+
+// literalPrefix returns the unescaped literal text that a path.Match pattern
+// element starts with, before its first magic character.
+func literalPrefix(elem string) string {
+	var b strings.Builder
+	for i := 0; i < len(elem); i++ {
+		switch elem[i] {
+		case '*', '?', '[':
+			return b.String()
+		case '\\':
+			if i++; i == len(elem) {
+				return b.String()
+			}
+		}
+		b.WriteByte(elem[i])
+	}
+	return b.String()
+}
+
+// hasMeta reports whether s contains any of the magic characters recognized
+// by path.Match.
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
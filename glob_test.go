@@ -0,0 +1,143 @@
+package s3vfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	f := newFakeS3(t)
+	for _, key := range []string{"x/y/0.txt", "x/y/1.txt", "x/2.txt", "x/z/3.md"} {
+		f.put(key, nil)
+	}
+	fs := f.fs()
+
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"x/y/*.txt", "[x/y/0.txt x/y/1.txt]"},
+		{"/x/*", "[x/2.txt x/y x/z]"},
+		{"x/*/*", "[x/y/0.txt x/y/1.txt x/z/3.md]"},
+		{"*/?/3.md", "[x/z/3.md]"},
+		{"x/2.txt", "[x/2.txt]"},
+		{"x/missing", "[]"},
+		{"x/2.txt/*", "[]"},
+	}
+	for _, test := range tests {
+		matches, err := fs.Glob(test.pattern)
+		if err != nil {
+			t.Errorf("%s: %s", test.pattern, err)
+			continue
+		}
+		if got := fmt.Sprint(matches); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.pattern, got, test.want)
+		}
+	}
+
+	if _, err := fs.Glob("x/["); err == nil {
+		t.Error("bad pattern: got nil error")
+	}
+}
+
+func TestGlob_pagination(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 2
+
+	f := newFakeS3(t)
+	var want []string
+	for i := 0; i < 7; i++ {
+		f.put(fmt.Sprintf("d/%d.txt", i), nil)
+		f.put(fmt.Sprintf("d/%d.md", i), nil)
+		want = append(want, fmt.Sprintf("d/%d.txt", i))
+	}
+	matches, err := f.fs().Glob("d/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(matches); got != fmt.Sprint(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestGlob_listing(t *testing.T) {
+	f := newFakeS3(t)
+	for i := 0; i < 20; i++ {
+		f.put(fmt.Sprintf("a/b/c/d/other%d.txt", i), nil)
+	}
+	for _, key := range []string{"a/b/c/d/log1.txt", "a/b/c/d/log2.md", "a/b/c/d/l*g.txt", "a/x/c/d/log3.txt"} {
+		f.put(key, nil)
+	}
+	fs := f.fs()
+
+	tests := []struct {
+		pattern string
+		want    string
+		prefix  string // of the only LIST request
+	}{
+		{"a/b/c/d/log*.txt", "[a/b/c/d/log1.txt]", "a/b/c/d/log"},
+		{"a/b/c/d/l\\*g.t?t", "[a/b/c/d/l*g.txt]", "a/b/c/d/l*g.t"},
+		{"a/b/c/d/*.md", "[a/b/c/d/log2.md]", "a/b/c/d/"},
+	}
+	for _, test := range tests {
+		f.reset()
+		matches, err := fs.Glob(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(matches); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.pattern, got, test.want)
+		}
+		reqs := f.received()
+		if len(reqs) != 1 || reqs[0].URL.Query().Get("prefix") != test.prefix {
+			t.Errorf("%s: got %d requests, want 1 LIST with prefix %q", test.pattern, len(reqs), test.prefix)
+		}
+	}
+}
+
+func TestPathStyle(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("x/y", []byte("data"))
+
+	for style, want := range map[PathStyle][]string{
+		RelativePaths: {"x/y", "x", ".", "[x/y]", "y", "x/y"},
+		AbsolutePaths: {"/x/y", "/x", "/", "[/x/y]", "y", "/x/y"},
+	} {
+		fs, err := New(f.bucketURL(), f.config(), &Options{PathStyle: style})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, name := range []string{"/x/y", "x/", "/"} {
+			fi, err := fs.Stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fi.Name())
+		}
+		matches, err := fs.Glob("*/y")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(matches))
+		fis, err := fs.ReadDir("/x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fis[0].Name())
+		err = fs.ForEachObject(context.Background(), "x/", 1, func(path string, r io.ReadCloser) error {
+			got = append(got, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("style %d: got %q, want %q", style, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package s3vfs
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// GzipFileSystem is a filesystem that stores files gzip-compressed. Files
+// created with Create are compressed as they are written and stored with
+// Content-Encoding: gzip. Open decompresses objects stored with that
+// Content-Encoding and reads other objects as they are.
+//
+// The sizes reported by Stat, Lstat, and ReadDir are those of the stored,
+// compressed objects, not of the data that Open reads.
+type GzipFileSystem struct {
+	rwvfs.FileSystem
+	fs *S3FS
+}
+
+// NewGzip returns a filesystem that compresses the files it stores in fs.
+func NewGzip(fs *S3FS) *GzipFileSystem {
+	return &GzipFileSystem{FileSystem: fs, fs: fs}
+}
+
+func (fs *GzipFileSystem) String() string {
+	return fmt.Sprintf("gzip(%s)", fs.fs)
+}
+
+// Create opens the file at path for writing, compressing the data written.
+func (fs *GzipFileSystem) Create(path string) (io.WriteCloser, error) {
+	w, err := fs.fs.CreateWithOptions(path, &WriteOptions{ContentEncoding: "gzip"})
+	if err != nil {
+		return nil, err
+	}
+	return &gzipWriter{Writer: gzip.NewWriter(w), w: w}, nil
+}
+
+// Open opens the file at name for reading, decompressing its data if it is
+// stored gzip-compressed. The file of a compressed object can only seek to
+// the start (and report its offset with Seek(0, io.SeekCurrent)).
+func (fs *GzipFileSystem) Open(name string) (vfs.ReadSeekCloser, error) {
+	r, err := fs.fs.openReader(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	if r.header.Get("Content-Encoding") != "gzip" {
+		return r, nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, &os.PathError{Op: "open", Path: fs.fs.url(name), Err: err}
+	}
+	return &gzipReader{Reader: zr, r: r}, nil
+}
+
+// gzipWriter compresses the data written to w.
+type gzipWriter struct {
+	*gzip.Writer
+	w io.WriteCloser
+}
+
+func (w *gzipWriter) Close() error {
+	err := w.Writer.Close()
+	if err2 := w.w.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// gzipReader decompresses the data read from r.
+type gzipReader struct {
+	*gzip.Reader
+	r   *reader
+	off int64 // of the next Read in the decompressed data
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *gzipReader) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case offset == 0 && whence == io.SeekCurrent:
+		return r.off, nil
+	case offset == 0 && whence == io.SeekStart:
+		if _, err := r.r.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if err := r.Reader.Reset(r.r); err != nil {
+			return 0, &os.PathError{Op: "seek", Path: r.r.fs.url(r.r.name), Err: err}
+		}
+		r.off = 0
+		return 0, nil
+	default:
+		return 0, &os.PathError{Op: "seek", Path: r.r.fs.url(r.r.name), Err: errors.New("can only seek to the start of compressed data")}
+	}
+}
+
+func (r *gzipReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,63 @@
+package s3vfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+)
+
+func TestGzip(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzfs := NewGzip(fs)
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"small":     []byte(`{"a": 1}`),
+		"multipart": bytes.Repeat([]byte(`{"line": "log"}`+"\n"), 1000),
+	} {
+		createFile(t, gzfs, name, data)
+		o, _ := f.get(name)
+		if got := o.header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("%s: got Content-Encoding %q, want gzip", name, got)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(o.data))
+		if err != nil {
+			t.Fatalf("%s: stored data is not gzipped: %s", name, err)
+		}
+		if stored, _ := ioutil.ReadAll(zr); !bytes.Equal(stored, data) {
+			t.Errorf("%s: stored data decompresses to %q", name, stored)
+		}
+
+		rc, err := gzfs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: read %d bytes, %v, want %d", name, len(got), err, len(data))
+		}
+		if _, err := rc.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadAll(rc); !bytes.Equal(got, data) {
+			t.Errorf("%s: after Seek(0): read %d bytes, want %d", name, len(got), len(data))
+		}
+		if _, err := rc.Seek(1, io.SeekStart); err == nil && len(data) > 0 {
+			t.Errorf("%s: Seek(1): got nil error", name)
+		}
+		rc.Close()
+	}
+
+	// Uncompressed objects are read as they are.
+	f.put("plain", []byte("plain"))
+	if got, err := vfs.ReadFile(gzfs, "plain"); err != nil || string(got) != "plain" {
+		t.Errorf("plain: got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,93 @@
+package s3vfs
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RequestOp is the kind of a request to S3, as reported to
+// Options.OnRequest and Options.OnRetry.
+type RequestOp string
+
+const (
+	OpGet    RequestOp = "get"    // GET of an object
+	OpHead   RequestOp = "head"   // HEAD of an object
+	OpList   RequestOp = "list"   // listing of keys (or other bucket GET)
+	OpPut    RequestOp = "put"    // PUT of an object or part
+	OpCopy   RequestOp = "copy"   // server-side copy of an object or part
+	OpDelete RequestOp = "delete" // DELETE of an object or multipart upload
+	OpPost   RequestOp = "post"   // multipart upload initiation or completion, or batch delete
+)
+
+// HTTPStatusError is the error reported to Options.OnRequest and
+// Options.OnRetry for a response with an HTTP error status.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("HTTP status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// onRequest reports the request to Options.OnRequest, if set.
+func (fs *S3FS) onRequest(req *http.Request, resp *http.Response, err error, dur time.Duration) {
+	if fs.opt.OnRequest == nil {
+		return
+	}
+	op, key := fs.describeRequest(req)
+	fs.opt.OnRequest(op, key, statusError(resp, err), dur)
+}
+
+// onRetry reports to Options.OnRetry, if set, that req is retried after the
+// given delay because it failed with resp or err.
+func (fs *S3FS) onRetry(req *http.Request, resp *http.Response, err error, attempt int, delay time.Duration) {
+	if fs.opt.OnRetry == nil {
+		return
+	}
+	op, key := fs.describeRequest(req)
+	fs.opt.OnRetry(op, key, attempt, statusError(resp, err), delay)
+}
+
+// statusError returns err, or an *HTTPStatusError if resp has an HTTP error
+// status.
+func statusError(resp *http.Response, err error) error {
+	if err == nil && resp.StatusCode >= 300 {
+		return &HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+	return err
+}
+
+// describeRequest returns the kind of req and the object key (or, for
+// listings, the key prefix) that it is for.
+func (fs *S3FS) describeRequest(req *http.Request) (RequestOp, string) {
+	key := fs.requestKey(req)
+	op := requestOp(req, key)
+	if op == OpList {
+		key = req.URL.Query().Get("prefix")
+	}
+	return op, key
+}
+
+// requestOp returns the kind of req, a request for the object with the given
+// key, or for the bucket if key is empty.
+func requestOp(req *http.Request, key string) RequestOp {
+	switch req.Method {
+	case "GET":
+		if key == "" {
+			return OpList
+		}
+		return OpGet
+	case "HEAD":
+		return OpHead
+	case "PUT":
+		if req.Header.Get("X-Amz-Copy-Source") != "" {
+			return OpCopy
+		}
+		return OpPut
+	case "DELETE":
+		return OpDelete
+	default:
+		return OpPost
+	}
+}
@@ -0,0 +1,98 @@
+package s3vfs
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnRequest(t *testing.T) {
+	f := newFakeS3(t)
+	var mu sync.Mutex
+	var calls []string
+	fs, err := New(f.bucketURL(), f.config(), &Options{OnRequest: func(op RequestOp, key string, err error, dur time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if dur < 0 {
+			t.Errorf("%s %s: got negative duration %s", op, key, dur)
+		}
+		status := 0
+		if e, ok := err.(*HTTPStatusError); ok {
+			status = e.StatusCode
+		} else if err != nil {
+			t.Errorf("%s %s: got error %v", op, key, err)
+		}
+		calls = append(calls, fmt.Sprintf("%s %s %d", op, key, status))
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createFile(t, fs, "d/f", []byte("x"))
+	if _, err := fs.Stat("d/f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("x"); err == nil {
+		t.Fatal("got no error for missing file")
+	}
+	if err := fs.Copy("d/f", "d/g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("d/f"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprint([]string{
+		"put d/f 0",
+		"head d/f 0",
+		"head x 404", "list x/ 0", fmt.Sprintf("head x_$folder$ %d", http.StatusNotFound),
+		"head d/f 0", "copy d/g 0",
+		"delete d/f 0",
+	})
+	if got := fmt.Sprint(calls); got != want {
+		t.Errorf("got calls\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOnRetry(t *testing.T) {
+	f := newFakeS3(t)
+	var mu sync.Mutex
+	var calls []string
+	fs, err := New(f.bucketURL(), f.config(), &Options{
+		RetryBaseDelay: time.Millisecond,
+		OnRetry: func(op RequestOp, key string, attempt int, err error, delay time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			if delay <= 0 {
+				t.Errorf("%s %s: got delay %s, want positive", op, key, delay)
+			}
+			status := 0
+			if e, ok := err.(*HTTPStatusError); ok {
+				status = e.StatusCode
+			}
+			calls = append(calls, fmt.Sprintf("%s %s %d %d", op, key, attempt, status))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.put("d/f", []byte("x"))
+
+	f.mu.Lock()
+	f.slowDowns = 2
+	f.mu.Unlock()
+	if _, err := fs.Stat("d/f"); err != nil {
+		t.Fatal(err)
+	}
+	// Requests that succeed the first time are not retried.
+	if _, err := fs.Stat("d/f"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprint([]string{"head d/f 1 503", "head d/f 2 503"})
+	if got := fmt.Sprint(calls); got != want {
+		t.Errorf("got calls %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package s3vfs
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inventoryManifest is the manifest.json of an S3 Inventory report.
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	FileSchema   string `json:"fileSchema"`
+	Files        []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// ReadInventory reads the S3 Inventory report whose manifest.json is at
+// manifestPath (in this filesystem's bucket, which must be the inventory's
+// destination bucket) and sends an entry for each object listed in the
+// report's data files, which are read through the filesystem. For very
+// large buckets, this is far cheaper than listing the bucket, at the cost of
+// the report being up to a day old. Delete markers (in reports that include
+// all versions) are skipped. The entries' keys are relative to the source
+// bucket's root, regardless of Options.KeyPrefix.
+//
+// Only the CSV format is supported. The Key field is required; the Size,
+// LastModifiedDate, ETag, and StorageClass fields are used if the report
+// includes them.
+//
+// Both channels are closed when the whole report has been read. If reading
+// fails or ctx is canceled, the error is sent on the error channel first.
+func (fs *S3FS) ReadInventory(ctx context.Context, manifestPath string) (<-chan ManifestEntry, <-chan error) {
+	entries := make(chan ManifestEntry)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		defer close(errc)
+		if err := fs.readInventory(ctx, manifestPath, entries); err != nil {
+			errc <- err
+		}
+	}()
+	return entries, errc
+}
+
+func (fs *S3FS) readInventory(ctx context.Context, manifestPath string, entries chan<- ManifestEntry) error {
+	data, err := fs.readObject(ctx, manifestPath)
+	if err != nil {
+		return err
+	}
+	var m inventoryManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("parsing inventory manifest %s: %s", manifestPath, err)
+	}
+	if m.FileFormat != "CSV" {
+		return fmt.Errorf("inventory manifest %s: unsupported file format %q", manifestPath, m.FileFormat)
+	}
+	fields := map[string]int{}
+	for i, f := range strings.Split(m.FileSchema, ",") {
+		fields[strings.TrimSpace(f)] = i
+	}
+	if _, ok := fields["Key"]; !ok {
+		return fmt.Errorf("inventory manifest %s: schema %q has no Key field", manifestPath, m.FileSchema)
+	}
+
+	for _, f := range m.Files {
+		if err := fs.readInventoryFile(ctx, f.Key, fields, entries); err != nil {
+			return fmt.Errorf("inventory file %s: %s", f.Key, err)
+		}
+	}
+	return nil
+}
+
+// readInventoryFile reads a gzipped CSV inventory data file whose columns
+// are given by fields, and sends an entry for each object in it. The
+// manifest gives data files by their keys relative to the bucket root, so
+// key is not relative to Options.KeyPrefix.
+func (fs *S3FS) readInventoryFile(ctx context.Context, key string, fields map[string]int, entries chan<- ManifestEntry) error {
+	resp, err := fs.getURL(ctx, fs.keyURL(key), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(zr)
+	r.FieldsPerRecord = len(fields)
+
+	field := func(rec []string, name string) string {
+		if i, ok := fields[name]; ok {
+			return rec[i]
+		}
+		return ""
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if field(rec, "IsDeleteMarker") == "true" {
+			continue
+		}
+
+		var e ManifestEntry
+		if e.Key, err = url.QueryUnescape(field(rec, "Key")); err != nil {
+			return err
+		}
+		if s := field(rec, "Size"); s != "" {
+			if e.Size, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return err
+			}
+		}
+		if s := field(rec, "LastModifiedDate"); s != "" {
+			if e.LastModified, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				return err
+			}
+		}
+		e.ETag = field(rec, "ETag")
+		e.StorageClass = field(rec, "StorageClass")
+
+		select {
+		case entries <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
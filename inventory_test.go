@@ -0,0 +1,75 @@
+package s3vfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func gzipString(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestReadInventory(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("inv/manifest.json", []byte(`{
+		"sourceBucket": "src",
+		"fileFormat": "CSV",
+		"fileSchema": "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, ETag, StorageClass",
+		"files": [{"key": "inv/data/1.csv.gz"}, {"key": "inv/data/2.csv.gz"}]
+	}`))
+	f.put("inv/data/1.csv.gz", gzipString(
+		`"src","a%2Fb+c.txt","v1","true","false","12","2024-01-02T03:04:05.000Z","abc","STANDARD"`+"\n"+
+			`"src","deleted","v2","true","true","","2024-01-02T03:04:05.000Z","",""`+"\n"))
+	f.put("inv/data/2.csv.gz", gzipString(
+		`"src","d","v3","true","false","0","2024-01-03T00:00:00.000Z","def","GLACIER"`+"\n"))
+
+	entries, errc := fs.ReadInventory(context.Background(), "inv/manifest.json")
+	var got []string
+	for e := range entries {
+		got = append(got, fmt.Sprintf("%s:%d:%s:%s:%s", e.Key, e.Size, e.LastModified.Format("2006-01-02"), e.ETag, e.StorageClass))
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	want := "[a/b c.txt:12:2024-01-02:abc:STANDARD d:0:2024-01-03:def:GLACIER]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	// The data files are given by their keys relative to the bucket root,
+	// regardless of the filesystem's KeyPrefix.
+	pfs, err := New(f.bucketURL(), f.config(), &Options{KeyPrefix: "inv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, errc = pfs.ReadInventory(context.Background(), "manifest.json")
+	got = nil
+	for e := range entries {
+		got = append(got, fmt.Sprintf("%s:%d:%s:%s:%s", e.Key, e.Size, e.LastModified.Format("2006-01-02"), e.ETag, e.StorageClass))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("KeyPrefix: %s", err)
+	}
+	if fmt.Sprint(got) != want {
+		t.Errorf("KeyPrefix: got %v, want %s", got, want)
+	}
+}
+
+func TestReadInventory_unsupportedFormat(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("manifest.json", []byte(`{"fileFormat": "ORC", "fileSchema": "message s3.inventory {}", "files": []}`))
+	entries, errc := f.fs().ReadInventory(context.Background(), "manifest.json")
+	for range entries {
+	}
+	if err := <-errc; err == nil {
+		t.Error("got nil error")
+	}
+}
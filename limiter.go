@@ -0,0 +1,193 @@
+package s3vfs
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultMaxRetries is the number of times an idempotent request is retried
+// after a transient failure if Options.MaxRetries is not set.
+const defaultMaxRetries = 3
+
+// defaultRetryBaseDelay is the delay before the first retry of a request if
+// Options.RetryBaseDelay is not set. It doubles for each subsequent retry.
+var defaultRetryBaseDelay = 100 * time.Millisecond
+
+func (fs *S3FS) maxRetries() int {
+	switch {
+	case fs.opt.MaxRetries < 0:
+		return 0
+	case fs.opt.MaxRetries == 0:
+		return defaultMaxRetries
+	}
+	return fs.opt.MaxRetries
+}
+
+// jitter returns a random delay between d/2 and d, so that clients that
+// failed together don't all retry at once.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfter returns the delay requested by the Retry-After header in h,
+// which is either a number of seconds or an HTTP date. It reports false if
+// there is no valid Retry-After header.
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// aimdLimiter limits the number of concurrent requests, adapting the limit
+// with additive-increase/multiplicative-decrease (AIMD): the limit is halved
+// when a request is throttled and grows by about 1 for every limit's worth
+// of successful requests, up to max.
+//
+// A nil *aimdLimiter imposes no limit.
+type aimdLimiter struct {
+	max      int
+	onChange func(limit int)
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	changed  chan struct{} // closed (and replaced) when a slot may be free
+	closed   chan struct{} // closed by close
+}
+
+func newAIMDLimiter(max int, onChange func(limit int)) *aimdLimiter {
+	return &aimdLimiter{
+		max:      max,
+		onChange: onChange,
+		limit:    float64(max),
+		changed:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// close makes all current and future calls to acquire fail with ErrClosed.
+func (l *aimdLimiter) close() {
+	if l != nil {
+		close(l.closed)
+	}
+}
+
+// acquire blocks until a request may be sent or ctx is done.
+func (l *aimdLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		if l.inflight < int(l.limit) {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.closed:
+			return ErrClosed
+		}
+	}
+}
+
+// release records that a request finished, adjusting the limit depending on
+// whether it was throttled.
+func (l *aimdLimiter) release(throttled bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.inflight--
+	old := int(l.limit)
+	if throttled {
+		l.limit /= 2
+		if l.limit < 1 {
+			l.limit = 1
+		}
+	} else {
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.max) {
+			l.limit = float64(l.max)
+		}
+	}
+	limit := int(l.limit)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	l.mu.Unlock()
+
+	if limit != old && l.onChange != nil {
+		l.onChange(limit)
+	}
+}
+
+// current returns the current effective limit.
+func (l *aimdLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// semaphore is a counting semaphore with a slot for each request that may
+// be in flight. A nil semaphore imposes no limit.
+type semaphore chan struct{}
+
+// acquire blocks until a slot is free, ctx is done, or closed is closed.
+func (s semaphore) acquire(ctx context.Context, closed <-chan struct{}) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closed:
+		return ErrClosed
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// limitedBody is a response body that releases its request's concurrency
+// limit slots when closed.
+type limitedBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *limitedBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,244 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	var changes []int
+	l := newAIMDLimiter(4, func(limit int) { changes = append(changes, limit) })
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		if err := l.acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx2); err != context.DeadlineExceeded {
+		t.Fatalf("acquire past limit: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	l.release(true) // 4 -> 2
+	l.release(true) // 2 -> 1
+	l.release(true) // stays at 1
+	if got := l.current(); got != 1 {
+		t.Errorf("after throttling: got limit %d, want 1", got)
+	}
+	l.release(false) // 1 -> 2
+	for i := 0; i < 20; i++ {
+		l.acquire(ctx)
+		l.release(false)
+	}
+	if got := l.current(); got != 4 {
+		t.Errorf("after recovery: got limit %d, want 4", got)
+	}
+	if want := []int{2, 1, 2, 3, 4}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("got limit changes %v, want %v", changes, want)
+	}
+
+	var nilLimiter *aimdLimiter
+	if err := nilLimiter.acquire(ctx); err != nil {
+		t.Errorf("nil limiter: %s", err)
+	}
+	nilLimiter.release(true)
+}
+
+func TestSlowDownRetry(t *testing.T) {
+	defer func(d time.Duration) { defaultRetryBaseDelay = d }(defaultRetryBaseDelay)
+	defaultRetryBaseDelay = time.Millisecond
+
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+
+	var mu sync.Mutex
+	var changes []int
+	fs, err := New(f.bucketURL(), f.config(), &Options{
+		MaxConcurrentRequests: 8,
+		OnConcurrencyChange: func(limit int) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, limit)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.slowDowns = 2
+	rc, err := fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "x" {
+		t.Errorf("got %q, want %q", b, "x")
+	}
+	if got := len(f.received()); got != 3 {
+		t.Errorf("got %d requests, want 3 (2 throttled, 1 successful)", got)
+	}
+	if got := fs.limiter.current(); got != 2 {
+		t.Errorf("got effective concurrency %d, want 2", got)
+	}
+	mu.Lock()
+	if want := []int{4, 2}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("got concurrency changes %v, want %v", changes, want)
+	}
+	mu.Unlock()
+
+	// Give up after defaultMaxRetries.
+	f.slowDowns = defaultMaxRetries + 1
+	if _, err := fs.Open("f"); err == nil {
+		t.Error("Open: got nil error, want error after exhausting retries")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+	var connErrors int
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if connErrors > 0 {
+			connErrors--
+			return nil, errors.New("connection reset by peer")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	fs, err := New(f.bucketURL(), config, &Options{RetryBaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(){
+		"503":           func() { f.slowDowns = 2 },
+		"500":           func() { f.serverErrors = 2 },
+		"network error": func() { connErrors = 2 },
+	}
+	for name, setup := range tests {
+		f.mu.Lock()
+		setup()
+		f.mu.Unlock()
+		if _, err := fs.Stat("f"); err != nil {
+			t.Errorf("%s: Stat: %s", name, err)
+		}
+	}
+
+	// DELETE is idempotent, so it is retried too.
+	f.mu.Lock()
+	f.serverErrors = 2
+	f.mu.Unlock()
+	if err := fs.Remove("f"); err != nil {
+		t.Errorf("Remove: %s", err)
+	}
+
+	// PUT is not.
+	f.mu.Lock()
+	f.serverErrors = 1
+	f.mu.Unlock()
+	w, _ := fs.Create("f")
+	if err := w.Close(); err == nil {
+		t.Error("Create: got nil error, want error without retry")
+	}
+
+	noRetries, err := New(f.bucketURL(), f.config(), &Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.reset()
+	f.mu.Lock()
+	f.serverErrors = 1
+	f.mu.Unlock()
+	if _, err := noRetries.Stat("f"); err == nil {
+		t.Error("MaxRetries -1: got nil error")
+	}
+	if n := len(f.received()); n != 1 {
+		t.Errorf("MaxRetries -1: got %d requests, want 1", n)
+	}
+}
+
+func TestMaxConcurrentReadsWrites(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+	fs, err := New(f.bucketURL(), f.config(), &Options{MaxConcurrentReads: 1, MaxConcurrentWrites: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An open response body holds the only read slot.
+	resp, err := fs.get("f", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := fs.getContext(ctx, "f", nil); err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("read past limit: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Writes have their own limit.
+	w, _ := fs.Create("g")
+	if err := w.Close(); err != nil {
+		t.Errorf("write while reads are at their limit: %s", err)
+	}
+
+	resp.Body.Close()
+	if _, err := fs.Stat("f"); err != nil {
+		t.Errorf("read after slot was released: %s", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:28:05 GMT", 5 * time.Second, true},
+		{"Wed, 21 Oct 2015 07:27:00 GMT", 0, true},
+	}
+	for _, test := range tests {
+		h := http.Header{}
+		if test.header != "" {
+			h.Set("Retry-After", test.header)
+		}
+		got, ok := retryAfter(h, now)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%q: got %s, %v, want %s, %v", test.header, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestTooManyRequestsRetry(t *testing.T) {
+	// The computed backoff would make the test time out, so it passes only
+	// if Retry-After is honored instead.
+	defer func(d time.Duration) { defaultRetryBaseDelay = d }(defaultRetryBaseDelay)
+	defaultRetryBaseDelay = time.Hour
+
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+	fs := f.fs()
+	for _, retryAfter := range []string{"0", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)} {
+		f.mu.Lock()
+		f.slowDowns, f.tooManyRequests, f.retryAfter = 2, true, retryAfter
+		f.mu.Unlock()
+		if _, err := fs.Stat("f"); err != nil {
+			t.Errorf("Retry-After %q: %s", retryAfter, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package s3vfs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listPageSize is the maximum number of keys requested per ListObjects call.
+// S3 never returns more than 1000.
+var listPageSize = 1000
+
+// listResult is a page of results from the ListObjects operation.
+type listResult struct {
+	IsTruncated    bool
+	NextMarker     string
+	Contents       []listObject
+	CommonPrefixes []struct{ Prefix string }
+}
+
+type listObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+// nextMarker returns the marker to request the page after r.
+func (r *listResult) nextMarker() string {
+	if r.NextMarker != "" {
+		return r.NextMarker
+	}
+	// NextMarker is only returned when a delimiter is specified; otherwise,
+	// the last key is the marker.
+	var marker string
+	if len(r.Contents) > 0 {
+		marker = r.Contents[len(r.Contents)-1].Key
+	}
+	if n := len(r.CommonPrefixes); n > 0 && r.CommonPrefixes[n-1].Prefix > marker {
+		marker = r.CommonPrefixes[n-1].Prefix
+	}
+	return marker
+}
+
+// trimPrefix removes prefix from the keys, common prefixes, and marker in r.
+func (r *listResult) trimPrefix(prefix string) {
+	r.NextMarker = strings.TrimPrefix(r.NextMarker, prefix)
+	for i := range r.Contents {
+		r.Contents[i].Key = strings.TrimPrefix(r.Contents[i].Key, prefix)
+	}
+	for i := range r.CommonPrefixes {
+		r.CommonPrefixes[i].Prefix = strings.TrimPrefix(r.CommonPrefixes[i].Prefix, prefix)
+	}
+}
+
+// listPage lists the keys in the bucket that begin with prefix and come
+// after marker. Keys, prefix, and markers are relative to
+// Options.KeyPrefix. If delimiter is non-empty, keys that contain it after
+// the prefix are rolled up into common prefixes. Callers list all the keys
+// by listing pages after the nextMarker of each page until one is not
+// truncated.
+func (fs *S3FS) listPage(ctx context.Context, prefix, delimiter, marker string) (*listResult, error) {
+	q := make(url.Values)
+	q.Set("prefix", fs.keyPrefix+prefix)
+	if delimiter != "" {
+		q.Set("delimiter", delimiter)
+	}
+	if marker != "" {
+		q.Set("marker", fs.keyPrefix+marker)
+	}
+	q.Set("max-keys", strconv.Itoa(listPageSize))
+	u := fs.bucket.ResolveReference(&url.URL{RawQuery: q.Encode()})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fs.newS3Error(resp)
+	}
+	defer resp.Body.Close()
+
+	var result listResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if fs.keyPrefix != "" {
+		result.trimPrefix(fs.keyPrefix)
+	}
+	if result.IsTruncated && result.nextMarker() <= marker {
+		// Don't loop forever on a broken S3-compatible service.
+		return nil, fmt.Errorf("truncated listing of prefix %q does not advance past marker %q", prefix, marker)
+	}
+	return &result, nil
+}
+
+// ManifestEntry describes an object, as reported by a bucket listing or an
+// inventory report. The Key of entries from listings (ManifestStream and
+// ReadDir) is relative to Options.KeyPrefix; the Key of entries from
+// ReadInventory is relative to the root of the inventory's source bucket.
+type ManifestEntry struct {
+	Key          string // object key; see above
+	ETag         string // without surrounding quotes
+	Size         int64
+	LastModified time.Time
+	StorageClass string
+}
+
+func newManifestEntry(o listObject) ManifestEntry {
+	t, _ := time.Parse(time.RFC3339Nano, o.LastModified)
+	return ManifestEntry{
+		Key:          o.Key,
+		ETag:         strings.Trim(o.ETag, `"`),
+		Size:         o.Size,
+		LastModified: t,
+		StorageClass: o.StorageClass,
+	}
+}
+
+// ManifestStream lists every object whose key begins with prefix and sends
+// an entry for each on the returned entries channel as the listing is
+// paginated, so that arbitrarily large listings don't need to be held in
+// memory. The ETag and size come from the listing itself; no per-object
+// requests are made.
+//
+// Both channels are closed when the listing is complete. If the listing
+// fails or ctx is canceled, the error is sent on the error channel first.
+func (fs *S3FS) ManifestStream(ctx context.Context, prefix string) (<-chan ManifestEntry, <-chan error) {
+	entries := make(chan ManifestEntry)
+	errc := make(chan error, 1)
+	prefix = strings.TrimPrefix(prefix, "/")
+
+	go func() {
+		defer close(entries)
+		defer close(errc)
+
+		var marker string
+		for {
+			page, err := fs.listPage(ctx, prefix, "", marker)
+			if err != nil {
+				errc <- err
+				return
+			}
+			for _, o := range page.Contents {
+				select {
+				case entries <- newManifestEntry(o):
+				case <-ctx.Done():
+					errc <- ctx.Err()
+					return
+				}
+			}
+			if !page.IsTruncated {
+				return
+			}
+			marker = page.nextMarker()
+		}
+	}()
+	return entries, errc
+}
+
+// ListDirs returns the names of the immediate subdirectories of path, in
+// order: the common prefixes of a listing of path with the delimiter "/",
+// which is how the S3 console shows folders. The files in path are not
+// returned, and (unlike ReadDir) Hadoop-style "dir_$folder$" markers are not
+// treated as directories.
+func (fs *S3FS) ListDirs(path string) ([]string, error) {
+	prefix := dirPrefix(path)
+	var names []string
+	var marker string
+	for {
+		page, err := fs.listPage(context.Background(), prefix, "/", marker)
+		if err != nil {
+			return nil, &os.PathError{Op: "listdirs", Path: fs.url(path), Err: err}
+		}
+		for _, p := range page.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(p.Prefix[len(prefix):], "/"))
+		}
+		if !page.IsTruncated {
+			return names, nil
+		}
+		marker = page.nextMarker()
+	}
+}
+
+// Usage returns the number of objects in the tree rooted at the directory
+// path and their total size in bytes, summed from the sizes reported in a
+// recursive listing (so no object is requested individually). Directory
+// marker objects are counted like any other object. It returns zeros if
+// there are no objects under path.
+func (fs *S3FS) Usage(path string) (objectCount int64, totalBytes int64, err error) {
+	prefix := dirPrefix(path)
+	var marker string
+	for {
+		page, err := fs.listPage(context.Background(), prefix, "", marker)
+		if err != nil {
+			return 0, 0, &os.PathError{Op: "usage", Path: fs.url(path), Err: err}
+		}
+		for _, o := range page.Contents {
+			objectCount++
+			totalBytes += o.Size
+		}
+		if !page.IsTruncated {
+			return objectCount, totalBytes, nil
+		}
+		marker = page.nextMarker()
+	}
+}
+
+// ListAll returns the files and directories in the tree rooted at the
+// directory path, sorted by name. Names are relative to path (e.g., "a/b"
+// for the file at path/a/b). The whole tree is listed recursively with as
+// few requests as possible; a directory entry is synthesized for each
+// intermediate key prefix and for each directory marker object (see
+// Options.DirMarkers).
+//
+// For files, the FileInfo's Sys method returns the ManifestEntry from the
+// listing. For very large trees, use ListAllStream instead.
+func (fs *S3FS) ListAll(path string) ([]os.FileInfo, error) {
+	fic, errc := fs.ListAllStream(context.Background(), path)
+	var fis []os.FileInfo
+	for fi := range fic {
+		fis = append(fis, fi)
+	}
+	if err := <-errc; err != nil {
+		return nil, &os.PathError{Op: "listall", Path: fs.url(path), Err: err}
+	}
+	sort.Sort(byName(fis))
+	return fis, nil
+}
+
+// ListAllStream is like ListAll, but it sends each entry on the returned
+// channel as the listing is paginated instead of returning a sorted slice.
+// Entries are sent in key order, with each directory sent before the first
+// entry inside it; only the set of directory names seen so far is held in
+// memory.
+//
+// Both channels are closed when the listing is complete. If the listing
+// fails or ctx is canceled, the error is sent on the error channel first.
+func (fs *S3FS) ListAllStream(ctx context.Context, path string) (<-chan os.FileInfo, <-chan error) {
+	fic := make(chan os.FileInfo)
+	errc := make(chan error, 1)
+	prefix := dirPrefix(path)
+	entries, listErrc := fs.ManifestStream(ctx, prefix)
+
+	go func() {
+		defer close(fic)
+		defer close(errc)
+
+		seen := map[string]bool{}
+		send := func(fi os.FileInfo) bool {
+			select {
+			case fic <- fi:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// sendDirs sends the directory dir and its ancestors, outermost
+		// first, unless they were already sent.
+		var sendDirs func(dir string) bool
+		sendDirs = func(dir string) bool {
+			if dir == "." || dir == "" || seen[dir] {
+				return true
+			}
+			if !sendDirs(pathpkg.Dir(dir)) {
+				return false
+			}
+			seen[dir] = true
+			return send(&fileInfo{name: dir, mode: os.ModeDir})
+		}
+
+		for e := range entries {
+			name := e.Key[len(prefix):]
+			var ok bool
+			switch {
+			case name == "":
+				ok = true // the marker of the listed directory itself
+			case strings.HasSuffix(name, "/"):
+				ok = sendDirs(strings.TrimSuffix(name, "/"))
+			case pathpkg.Base(name) == keepMarker:
+				ok = sendDirs(pathpkg.Dir(name))
+			case strings.HasSuffix(name, folderMarkerSuffix):
+				ok = sendDirs(strings.TrimSuffix(name, folderMarkerSuffix))
+			default:
+				ok = sendDirs(pathpkg.Dir(name)) && send(&fileInfo{
+					name:    name,
+					size:    e.Size,
+					modTime: e.LastModified,
+					sys:     e,
+				})
+			}
+			if !ok {
+				errc <- ctx.Err()
+				return
+			}
+		}
+		if err := <-listErrc; err != nil {
+			errc <- err
+		}
+	}()
+	return fic, errc
+}
@@ -0,0 +1,277 @@
+package s3vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestManifestStream(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 3
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	want := map[string]int64{}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("p/%d/%d", i%2, i)
+		f.put(key, make([]byte, i))
+		want[key] = int64(i)
+	}
+	f.put("other", nil)
+
+	entries, errc := fs.ManifestStream(context.Background(), "/p/")
+	got := map[string]int64{}
+	for e := range entries {
+		got[e.Key] = e.Size
+		if o, _ := f.get(e.Key); `"`+e.ETag+`"` != o.etag() {
+			t.Errorf("%s: got ETag %q, want %s", e.Key, e.ETag, o.etag())
+		}
+		if e.LastModified.IsZero() {
+			t.Errorf("%s: got zero LastModified", e.Key)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+
+	var lists int
+	for _, req := range f.received() {
+		if req.Method == "GET" {
+			lists++
+		}
+	}
+	if lists != 4 {
+		t.Errorf("got %d list requests, want 4", lists)
+	}
+}
+
+func TestManifestStream_cancel(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 10; i++ {
+		f.put(fmt.Sprint(i), nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entries, errc := fs.ManifestStream(ctx, "")
+	<-entries
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, ok := <-entries; ok {
+		t.Error("got entry after cancellation")
+	}
+}
+
+func TestListAll(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 2
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for _, key := range []string{
+		"x/a/b/c",
+		"x/a/b/d",
+		"x/a.txt",
+		"x/e/",               // directory marker
+		"x/f/.keep",          // directory marker
+		"x/g_$folder$",       // directory marker
+		"x/h/i_$folder$",     // directory marker in an implicit directory
+		"x/a_$folder$",       // marker of a directory that also has keys
+		"x/",                 // marker of the listed directory
+		"x/.keep",            // marker of the listed directory
+		"y/outside/the/tree", // not listed
+	} {
+		f.put(key, []byte("data"))
+	}
+
+	fis, err := fs.ListAll("/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		s := fi.Name()
+		if fi.IsDir() {
+			s += "/"
+		} else if fi.Size() != 4 {
+			t.Errorf("%s: got size %d, want 4", fi.Name(), fi.Size())
+		}
+		got = append(got, s)
+	}
+	want := "[a/ a.txt a/b/ a/b/c a/b/d e/ f/ g/ h/ h/i/]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestReadDir_pagination(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 2
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	var want []string
+	for i := 0; i < 5; i++ {
+		f.put(fmt.Sprintf("d/f%d", i), nil)
+		f.put(fmt.Sprintf("d/s%d/x", i), nil)
+		want = append(want, fmt.Sprintf("f%d", i))
+	}
+	for i := 0; i < 5; i++ {
+		want = append(want, fmt.Sprintf("s%d/", i))
+	}
+
+	fis, err := fs.ReadDir("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		got = append(got, name)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := len(f.received()); n != 5 {
+		t.Errorf("got %d list requests, want 5", n)
+	}
+}
+
+func TestWalkPrefix(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 2
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 5; i++ {
+		f.put(fmt.Sprintf("d/f%d", i), []byte("x"))
+	}
+	f.put("d/s/x", nil)
+
+	var got []string
+	err := fs.WalkPrefix("d", func(fi os.FileInfo) error {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		got = append(got, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last page has f4 and the directory s, which is passed first.
+	if want := "[f0 f1 f2 f3 s/ f4]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := len(f.received()); n != 3 {
+		t.Errorf("got %d list requests, want 3", n)
+	}
+
+	// Stopping in the first page lists no more pages.
+	f.reset()
+	var n int
+	err = fs.WalkPrefix("d", func(fi os.FileInfo) error {
+		if n++; n == 2 {
+			return ErrStopWalk
+		}
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Errorf("got error %v after %d entries, want nil after 2", err, n)
+	}
+	if n := len(f.received()); n != 1 {
+		t.Errorf("got %d list requests after stopping, want 1", n)
+	}
+
+	fnErr := errors.New("fn failed")
+	if err := fs.WalkPrefix("d", func(os.FileInfo) error { return fnErr }); err != fnErr {
+		t.Errorf("got error %v, want %v", err, fnErr)
+	}
+}
+
+func TestListDirs(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 2
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for _, key := range []string{"d/a/x", "d/a/y/z", "d/b/x", "d/c/", "d/f", "d/g", "d/h", "e/x"} {
+		f.put(key, nil)
+	}
+	dirs, err := fs.ListDirs("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[a b c]"; fmt.Sprint(dirs) != want {
+		t.Errorf("got %v, want %v", dirs, want)
+	}
+	for _, req := range f.received() {
+		if got := req.URL.Query().Get("delimiter"); got != "/" {
+			t.Errorf("got list request with delimiter %q, want %q", got, "/")
+		}
+	}
+
+	if dirs, err := fs.ListDirs("/"); err != nil || fmt.Sprint(dirs) != "[d e]" {
+		t.Errorf("root: got %v, %v", dirs, err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	defer func(n int) { listPageSize = n }(listPageSize)
+	listPageSize = 3
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	var want int64
+	for i := 0; i < 10; i++ {
+		f.put(fmt.Sprintf("d/%d/%d", i%3, i), make([]byte, i))
+		want += int64(i)
+	}
+	f.put("d/e/", nil)
+	f.put("dx", make([]byte, 100))
+
+	count, size, err := fs.Usage("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 11 || size != want {
+		t.Errorf("got %d objects, %d bytes; want 11 objects, %d bytes", count, size, want)
+	}
+	if n := len(f.received()); n != 4 {
+		t.Errorf("got %d list requests, want 4", n)
+	}
+
+	if count, size, err := fs.Usage("/"); err != nil || count != 12 || size != want+100 {
+		t.Errorf("root: got %d, %d, %v", count, size, err)
+	}
+	if count, size, err := fs.Usage("empty"); err != nil || count != 0 || size != 0 {
+		t.Errorf("empty: got %d, %d, %v", count, size, err)
+	}
+}
+
+func TestReadDir_stuckPagination(t *testing.T) {
+	// A broken server that claims every page is truncated.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<ListBucketResult><IsTruncated>true</IsTruncated></ListBucketResult>")
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/" + fakeBucket)
+	if _, err := S3(u, newFakeS3(t).config()).ReadDir("d"); err == nil {
+		t.Error("got nil error")
+	}
+}
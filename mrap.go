@@ -0,0 +1,174 @@
+package s3vfs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sqs/s3"
+)
+
+// sigV4AAlgorithm identifies the Signature Version 4A signing algorithm,
+// which is required for requests to Multi-Region Access Points.
+const sigV4AAlgorithm = "AWS4-ECDSA-P256-SHA256"
+
+// parseMRAPARN parses the ARN of an S3 Multi-Region Access Point (e.g.,
+// "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap") and returns the
+// URL of its global endpoint.
+func parseMRAPARN(arn string) (*url.URL, error) {
+	// arn:partition:service:region:account:resource
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "s3" || parts[3] != "" || !strings.HasPrefix(parts[5], "accesspoint/") {
+		return nil, fmt.Errorf("invalid S3 Multi-Region Access Point ARN %q", arn)
+	}
+	alias := strings.TrimPrefix(parts[5], "accesspoint/")
+	if alias == "" || strings.Contains(alias, "/") {
+		return nil, fmt.Errorf("invalid S3 Multi-Region Access Point ARN %q", arn)
+	}
+	domain := "amazonaws.com"
+	if parts[1] == "aws-cn" {
+		domain = "amazonaws.com.cn"
+	}
+	return &url.URL{Scheme: "https", Host: alias + ".accesspoint.s3-global." + domain, Path: "/"}, nil
+}
+
+// signV4A signs req with AWS Signature Version 4A for all regions, using an
+// unsigned payload (of the kind given by the X-Amz-Content-Sha256 header, if
+// set).
+func signV4A(req *http.Request, keys s3.Keys, now time.Time) error {
+	priv, err := deriveV4AKey(keys.AccessKey, keys.SecretKey)
+	if err != nil {
+		return err
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Region-Set", "*")
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if keys.SecurityToken != "" {
+		req.Header.Set("X-Amz-Security-Token", keys.SecurityToken)
+	}
+
+	creq, signedHeaders := canonicalRequest(req, req.Header.Get("X-Amz-Content-Sha256"))
+	sum := sha256.Sum256([]byte(creq))
+	stringToSign := sigV4AAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		sigV4AAlgorithm, keys.AccessKey, scope, signedHeaders, sig))
+	return nil
+}
+
+// canonicalRequest returns the canonical form of req, whose payload has the
+// given hash (or is unsigned), used in Signature Version 4 and 4A and the
+// list of signed headers.
+func canonicalRequest(req *http.Request, payloadHash string) (creq, signedHeaders string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "content-md5" {
+			headers[lk] = strings.Join(strings.Fields(strings.Join(v, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders bytes.Buffer
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	var canonQuery []string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			canonQuery = append(canonQuery, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(canonQuery)
+
+	signedHeaders = strings.Join(names, ";")
+	creq = strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		strings.Join(canonQuery, "&"),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return creq, signedHeaders
+}
+
+// uriEncode percent-encodes s as AWS signatures require: every byte except
+// the unreserved characters (and '/', unless encodeSlash is set).
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// deriveV4AKey derives the ECDSA P-256 signing key for Signature Version 4A
+// from an access key pair, using the NIST SP 800-108 KDF in counter mode
+// with HMAC-SHA256.
+func deriveV4AKey(accessKey, secretKey string) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	nMinusTwo := new(big.Int).Sub(curve.Params().N, big.NewInt(2))
+
+	for counter := byte(1); counter < 0xff; counter++ {
+		var fixedInput bytes.Buffer
+		fixedInput.WriteString(sigV4AAlgorithm)
+		fixedInput.WriteByte(0)
+		fixedInput.WriteString(accessKey)
+		fixedInput.WriteByte(counter)
+
+		mac := hmac.New(sha256.New, []byte("AWS4A"+secretKey))
+		binary.Write(mac, binary.BigEndian, uint32(1))
+		mac.Write(fixedInput.Bytes())
+		binary.Write(mac, binary.BigEndian, uint32(256))
+		c := new(big.Int).SetBytes(mac.Sum(nil))
+
+		if c.Cmp(nMinusTwo) < 0 {
+			priv := &ecdsa.PrivateKey{D: c.Add(c, big.NewInt(1))}
+			priv.Curve = curve
+			priv.X, priv.Y = curve.ScalarBaseMult(priv.D.Bytes())
+			return priv, nil
+		}
+	}
+	return nil, fmt.Errorf("s3vfs: can't derive Signature Version 4A key for access key %s", accessKey)
+}
@@ -0,0 +1,117 @@
+package s3vfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sqs/s3"
+	"github.com/sqs/s3/s3util"
+)
+
+func TestParseMRAPARN(t *testing.T) {
+	tests := map[string]string{
+		"arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap":    "https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com/",
+		"arn:aws-cn:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap": "https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com.cn/",
+		"arn:aws:s3:us-east-1:123456789012:accesspoint/regional":     "",
+		"arn:aws:sqs::123456789012:accesspoint/x.mrap":               "",
+		"arn:aws:s3::123456789012:bucket/x":                          "",
+		"arn:aws:s3::123456789012:accesspoint/":                      "",
+	}
+	for arn, want := range tests {
+		u, err := parseMRAPARN(arn)
+		var got string
+		if err == nil {
+			got = u.String()
+		}
+		if got != want {
+			t.Errorf("%s: got %q (error %v), want %q", arn, got, err, want)
+		}
+	}
+}
+
+func TestNew_MRAP(t *testing.T) {
+	arn := "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"
+	u, err := url.Parse(arn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req *http.Request
+	config := *DefaultS3Config.Service
+	fs, err := New(u, &s3util.Config{
+		Service: &config,
+		Keys:    &s3.Keys{AccessKey: "AKID", SecretKey: "secret"},
+		Client: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			req = r
+			return nil, fmt.Errorf("not sent")
+		})},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Open("a/b")
+	if req == nil {
+		t.Fatal("no request sent")
+	}
+	if got, want := req.URL.String(), "https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com/a/b"; got != want {
+		t.Errorf("got URL %s, want %s", got, want)
+	}
+	if auth := req.Header.Get("Authorization"); !regexp.MustCompile(`^AWS4-ECDSA-P256-SHA256 Credential=AKID/\d{8}/s3/aws4_request, `).MatchString(auth) {
+		t.Errorf("got Authorization %q, want Signature Version 4A", auth)
+	}
+	if got, want := fs.copySource("a/b c"), "arn%3Aaws%3As3%3A%3A123456789012%3Aaccesspoint/mfzwi23gnjvgw.mrap/object/a/b%20c"; got != want {
+		t.Errorf("got copy source %q, want %q", got, want)
+	}
+}
+
+func TestDeriveV4AKey(t *testing.T) {
+	// Test vector from the AWS SDKs.
+	priv, err := deriveV4AKey("AKISORANDOMAASORANDOM", "q+jcrXGc+0zWN6uzclKVhvMmUsIfRPa4rlRandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%x", priv.D), "7fd3bd010c0d9c292141c2b77bfbde1042c92e6836fff749d1269ec890fca1bd"; got != want {
+		t.Errorf("got key %s, want %s", got, want)
+	}
+}
+
+func TestSignV4A(t *testing.T) {
+	keys := s3.Keys{AccessKey: "AKID", SecretKey: "secret", SecurityToken: "token"}
+	req, _ := http.NewRequest("GET", "https://example.com/a%20b/c?uploads&x=1", nil)
+	req.Header.Set("Content-Type", "text/plain")
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := signV4A(req, keys, now); err != nil {
+		t.Fatal(err)
+	}
+
+	creq, signedHeaders := canonicalRequest(req, "UNSIGNED-PAYLOAD")
+	wantCreq := "GET\n/a%20b/c\nuploads=&x=1\n" +
+		"content-type:text/plain\nhost:example.com\nx-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+		"x-amz-date:20240102T030405Z\nx-amz-region-set:*\nx-amz-security-token:token\n\n" +
+		"content-type;host;x-amz-content-sha256;x-amz-date;x-amz-region-set;x-amz-security-token\n" +
+		"UNSIGNED-PAYLOAD"
+	if creq != wantCreq {
+		t.Errorf("got canonical request\n%s\nwant\n%s", creq, wantCreq)
+	}
+
+	m := regexp.MustCompile(`^AWS4-ECDSA-P256-SHA256 Credential=AKID/20240102/s3/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]+)$`).FindStringSubmatch(req.Header.Get("Authorization"))
+	if m == nil {
+		t.Fatalf("malformed Authorization %q", req.Header.Get("Authorization"))
+	}
+	if m[1] != signedHeaders {
+		t.Errorf("got signed headers %q, want %q", m[1], signedHeaders)
+	}
+	sig, _ := hex.DecodeString(m[2])
+	sum := sha256.Sum256([]byte(creq))
+	digest := sha256.Sum256([]byte("AWS4-ECDSA-P256-SHA256\n20240102T030405Z\n20240102/s3/aws4_request\n" + hex.EncodeToString(sum[:])))
+	priv, _ := deriveV4AKey(keys.AccessKey, keys.SecretKey)
+	if !ecdsa.VerifyASN1(&priv.PublicKey, digest[:], sig) {
+		t.Error("signature does not verify")
+	}
+}
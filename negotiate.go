@@ -0,0 +1,49 @@
+package s3vfs
+
+import (
+	"io"
+	"os"
+)
+
+// encodingSuffixes maps content codings to the file name suffixes of
+// precompressed variants. Codings not listed here use "." + the coding name.
+var encodingSuffixes = map[string]string{
+	"br":      ".br",
+	"gzip":    ".gz",
+	"zstd":    ".zst",
+	"deflate": ".zz",
+}
+
+// OpenNegotiated opens the best available variant of the file at path for a
+// client that accepts the given content codings (e.g., from an
+// Accept-Encoding header), in order of preference. A variant for a coding is
+// stored at path plus the coding's suffix (e.g., "page.html.br" for "br" and
+// "page.html.gz" for "gzip").
+//
+// The first preferred variant that exists is returned along with its coding.
+// If none exists, the file at path itself is returned and chosenEncoding is
+// empty. The "identity" coding refers to the file at path.
+func (fs *S3FS) OpenNegotiated(path string, acceptEncodings []string) (rc io.ReadCloser, chosenEncoding string, err error) {
+	for _, enc := range acceptEncodings {
+		if enc == "identity" {
+			break
+		}
+		suffix, ok := encodingSuffixes[enc]
+		if !ok {
+			suffix = "." + enc
+		}
+		resp, err := fs.get(path+suffix, nil)
+		if err == nil {
+			return resp.Body, enc, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, "", err
+		}
+	}
+
+	resp, err := fs.get(path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, "", nil
+}
@@ -0,0 +1,46 @@
+package s3vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenNegotiated(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("page.html", []byte("plain"))
+	f.put("page.html.gz", []byte("gzipped"))
+	f.put("page.html.br", []byte("brotli"))
+	f.put("other.html", []byte("other"))
+
+	tests := []struct {
+		path     string
+		accept   []string
+		wantData string
+		wantEnc  string
+	}{
+		{"page.html", []string{"br", "gzip"}, "brotli", "br"},
+		{"page.html", []string{"zstd", "gzip", "br"}, "gzipped", "gzip"},
+		{"page.html", []string{"zstd"}, "plain", ""},
+		{"page.html", []string{"identity", "br"}, "plain", ""},
+		{"page.html", nil, "plain", ""},
+		{"other.html", []string{"br", "gzip"}, "other", ""},
+	}
+	for _, test := range tests {
+		rc, enc, err := fs.OpenNegotiated(test.path, test.accept)
+		if err != nil {
+			t.Errorf("%s %v: %s", test.path, test.accept, err)
+			continue
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(b) != test.wantData || enc != test.wantEnc {
+			t.Errorf("%s %v: got %q (encoding %q), want %q (encoding %q)", test.path, test.accept, b, enc, test.wantData, test.wantEnc)
+		}
+	}
+
+	if _, _, err := fs.OpenNegotiated("doesntexist", []string{"gzip"}); !os.IsNotExist(err) {
+		t.Errorf("missing file: got error %v, want os.IsNotExist-satisfying", err)
+	}
+}
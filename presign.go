@@ -0,0 +1,89 @@
+package s3vfs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxPresignExpiry is the longest validity S3 allows for a presigned URL.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// PresignGet returns a URL that anyone can use to download the object at
+// path, without credentials, until expiry has passed. The URL is signed with
+// AWS Signature Version 4 using the filesystem's keys, for the region of
+// the bucket (see Region), or us-east-1 if it is unknown, and it is for
+// the endpoint that requests are sent to (see Endpoint).
+//
+// The expiry must be between 1 second and 7 days. Presigned URLs are not
+// supported for Multi-Region Access Points.
+func (fs *S3FS) PresignGet(path string, expiry time.Duration) (string, error) {
+	return fs.presign("GET", path, expiry, time.Now())
+}
+
+// PresignPut is like PresignGet, but the URL is for uploading the object at
+// path with a PUT request.
+func (fs *S3FS) PresignPut(path string, expiry time.Duration) (string, error) {
+	return fs.presign("PUT", path, expiry, time.Now())
+}
+
+func (fs *S3FS) presign(method, path string, expiry time.Duration, now time.Time) (string, error) {
+	if expiry < time.Second || expiry > maxPresignExpiry {
+		return "", fmt.Errorf("s3vfs: presigned URL expiry %s is not between 1s and 7 days", expiry)
+	}
+	if fs.mrapARN != "" {
+		return "", errors.New("s3vfs: presigned URLs are not supported for Multi-Region Access Points")
+	}
+	region := fs.region()
+	if region == "" {
+		region = "us-east-1"
+	}
+	keys, err := fs.keys(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	req, err := http.NewRequest(method, fs.url(path), nil)
+	if err != nil {
+		return "", err
+	}
+	// Sign for the host of the region that S3 redirected requests to, if
+	// any, along with the region itself.
+	fs.redirect(req)
+	q := req.URL.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", keys.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if keys.SecurityToken != "" {
+		q.Set("X-Amz-Security-Token", keys.SecurityToken)
+	}
+	if fs.opt.RequesterPays {
+		q.Set("x-amz-request-payer", "requester")
+	}
+	req.URL.RawQuery = q.Encode()
+
+	creq, _ := canonicalRequest(req, "UNSIGNED-PAYLOAD")
+	sum := sha256.Sum256([]byte(creq))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + keys.SecretKey)
+	for _, s := range []string{amzDate[:8], region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		key = mac.Sum(nil)
+	}
+	q.Set("X-Amz-Signature", hex.EncodeToString(key))
+	u := *req.URL
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,35 @@
+package s3vfs
+
+import (
+	"context"
+
+	"golang.org/x/tools/godoc/vfs"
+)
+
+// A ProgressFunc is called as an object's data is transferred, with the
+// number of bytes transferred so far and the total number of bytes to
+// transfer, or -1 if the total is not known.
+type ProgressFunc func(transferred, total int64)
+
+// OpenWithProgress is like Open, but progress is called after each Read
+// from the file that returns data, with the number of bytes read from it so
+// far and the size of the object.
+func (fs *S3FS) OpenWithProgress(name string, progress ProgressFunc) (vfs.ReadSeekCloser, error) {
+	r, err := fs.openReader(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+	r.progress = progress
+	return r, nil
+}
+
+// reportProgress records that n more bytes of the object were uploaded and
+// calls the writer's ProgressFunc, if any.
+func (w *writer) reportProgress(n int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.uploaded += n
+	if w.progress != nil {
+		w.progress(w.uploaded, w.total)
+	}
+}
@@ -0,0 +1,68 @@
+package s3vfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("x"), 100)
+
+	var calls []string
+	record := func(transferred, total int64) {
+		calls = append(calls, fmt.Sprintf("%d/%d", transferred, total))
+	}
+	w, err := fs.CreateWithOptions("a", &WriteOptions{Progress: record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[30/-1 60/-1 90/-1 100/-1]"; fmt.Sprint(calls) != want {
+		t.Errorf("Create: got progress %v, want %v", calls, want)
+	}
+
+	local := filepath.Join(t.TempDir(), "b")
+	if err := ioutil.WriteFile(local, data[:50], 0644); err != nil {
+		t.Fatal(err)
+	}
+	calls = nil
+	if err := fs.PutFile("b", local, &WriteOptions{Progress: record}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[30/50 50/50]"; fmt.Sprint(calls) != want {
+		t.Errorf("PutFile: got progress %v, want %v", calls, want)
+	}
+
+	var last, lastTotal int64
+	rc, err := fs.OpenWithProgress("a", func(transferred, total int64) {
+		if transferred <= last {
+			t.Errorf("progress went from %d to %d", last, transferred)
+		}
+		last, lastTotal = transferred, total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(ioutil.Discard, rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != n || lastTotal != int64(len(data)) {
+		t.Errorf("Open: got final progress %d/%d, want %d/%d", last, lastTotal, n, len(data))
+	}
+}
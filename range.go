@@ -0,0 +1,107 @@
+package s3vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrMultipleRanges is returned by OpenHTTPRange when the Range header
+// specifies more than one byte range. S3 only serves a single range per GET.
+var ErrMultipleRanges = errors.New("s3vfs: multiple byte ranges are not supported")
+
+// OpenHTTPRange opens the byte range of the file at path described by
+// httpRange, the value of an HTTP Range header (e.g., "bytes=0-499",
+// "bytes=500-", or "bytes=-500"). The range is passed through to S3, so only
+// the requested bytes are transferred.
+//
+// The returned contentRange is the Content-Range value S3 responded with and
+// totalSize is the size of the whole object, which together are sufficient to
+// build a 206 Partial Content response. If httpRange is empty, the whole
+// object is returned and contentRange is empty.
+//
+// Requests for multiple ranges are rejected with ErrMultipleRanges.
+func (fs *S3FS) OpenHTTPRange(path string, httpRange string) (rc io.ReadCloser, contentRange string, totalSize int64, err error) {
+	h := make(http.Header)
+	if httpRange != "" {
+		if _, _, err := parseRangeHeader(httpRange); err != nil {
+			return nil, "", 0, &os.PathError{Op: "open", Path: fs.url(path), Err: err}
+		}
+		h.Set("Range", httpRange)
+	}
+	resp, err := fs.get(path, h)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		contentRange = resp.Header.Get("Content-Range")
+		totalSize, err = parseContentRangeSize(contentRange)
+		if err != nil {
+			resp.Body.Close()
+			return nil, "", 0, &os.PathError{Op: "open", Path: fs.url(path), Err: err}
+		}
+		return resp.Body, contentRange, totalSize, nil
+	}
+	return resp.Body, "", resp.ContentLength, nil
+}
+
+// parseRangeHeader parses an HTTP Range header value that specifies a single
+// byte range. A negative start means the range is a suffix range of the last
+// end bytes; a negative end means the range extends to the end of the object.
+func parseRangeHeader(s string) (start, end int64, err error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return 0, 0, fmt.Errorf("invalid range %q: unit must be bytes", s)
+	}
+	spec := strings.TrimSpace(s[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return 0, 0, ErrMultipleRanges
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	startStr, endStr := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	switch {
+	case startStr == "" && endStr == "":
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	case startStr == "":
+		// Suffix range: "bytes=-N".
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", s)
+		}
+		return -1, n, nil
+	}
+	start, err = strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	if endStr == "" {
+		return start, -1, nil
+	}
+	end, err = strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	return start, end, nil
+}
+
+// parseContentRangeSize returns the total object size from a Content-Range
+// response header value (e.g., "bytes 0-499/1234").
+func parseContentRangeSize(s string) (int64, error) {
+	i := strings.LastIndex(s, "/")
+	if !strings.HasPrefix(s, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	size, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return size, nil
+}
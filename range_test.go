@@ -0,0 +1,70 @@
+package s3vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestOpenHTTPRange(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	data := []byte("0123456789abcdefghij")
+	f.put("f", data)
+
+	tests := []struct {
+		httpRange        string
+		wantData         string
+		wantContentRange string
+	}{
+		{"", string(data), ""},
+		{"bytes=0-4", "01234", "bytes 0-4/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+		{"bytes=5-100", "56789abcdefghij", "bytes 5-19/20"},
+	}
+	for _, test := range tests {
+		rc, contentRange, totalSize, err := fs.OpenHTTPRange("f", test.httpRange)
+		if err != nil {
+			t.Errorf("%q: %s", test.httpRange, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%q: ReadAll: %s", test.httpRange, err)
+			continue
+		}
+		if string(b) != test.wantData {
+			t.Errorf("%q: got data %q, want %q", test.httpRange, b, test.wantData)
+		}
+		if contentRange != test.wantContentRange {
+			t.Errorf("%q: got Content-Range %q, want %q", test.httpRange, contentRange, test.wantContentRange)
+		}
+		if totalSize != int64(len(data)) {
+			t.Errorf("%q: got total size %d, want %d", test.httpRange, totalSize, len(data))
+		}
+	}
+}
+
+func TestOpenHTTPRange_errors(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("f", []byte("abc"))
+
+	if _, _, _, err := fs.OpenHTTPRange("f", "bytes=0-1,2-3"); err == nil || err.(*os.PathError).Err != ErrMultipleRanges {
+		t.Errorf("multiple ranges: got error %v, want ErrMultipleRanges", err)
+	}
+	if len(f.received()) != 0 {
+		t.Errorf("multiple ranges: got %d requests, want none", len(f.received()))
+	}
+	if _, _, _, err := fs.OpenHTTPRange("f", "lines=1-2"); err == nil {
+		t.Error("invalid unit: got nil error")
+	}
+	if _, _, _, err := fs.OpenHTTPRange("doesntexist", "bytes=0-1"); !os.IsNotExist(err) {
+		t.Errorf("missing file: got error %v, want os.IsNotExist-satisfying", err)
+	}
+	if _, _, _, err := fs.OpenHTTPRange("f", "bytes=10-20"); err == nil {
+		t.Error("unsatisfiable range: got nil error")
+	}
+}
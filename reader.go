@@ -0,0 +1,300 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is the error for data read from an object that does
+// not match the object's checksum (see Options.VerifyMD5).
+var ErrChecksumMismatch = errors.New("s3vfs: data does not match checksum")
+
+// reader is a file opened by Open. It reads the object lazily with HTTP
+// Range requests: sequential reads stream a single response body, and a
+// Seek only records the new offset, so that the next Read issues a GET for
+// the range starting there. It also implements io.ReaderAt, which issues a
+// GET for exactly the range read.
+//
+// All requests after the first are conditional on the object's ETag, so
+// that reads fail instead of mixing the data of different versions of an
+// object that is overwritten while it is open.
+type reader struct {
+	fs     *S3FS
+	ctx    context.Context
+	name   string
+	etag   string
+	size   int64       // -1 if not yet known
+	header http.Header // of the first response
+
+	off    int64         // offset of the next Read
+	body   io.ReadCloser // response body positioned at off, or nil
+	closed bool
+
+	md5    hash.Hash // of the data read from the start, or nil if not verified
+	md5Sum []byte    // the MD5 that the object's ETag says it has
+
+	progress ProgressFunc // called after each Read, or nil
+	read     int64        // bytes returned by Read
+}
+
+// openReader opens the object at name for reading, starting with a GET
+// request for the whole object, whose body is used for the first reads.
+func (fs *S3FS) openReader(ctx context.Context, name string) (*reader, error) {
+	resp, err := fs.getContext(ctx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fs.newReader(ctx, name, resp), nil
+}
+
+// newReader returns a reader for the object at name whose first reads use
+// the body of resp, the successful response to a GET of the whole object.
+func (fs *S3FS) newReader(ctx context.Context, name string, resp *http.Response) *reader {
+	r := &reader{
+		fs:     fs,
+		ctx:    ctx,
+		name:   name,
+		etag:   resp.Header.Get("ETag"),
+		size:   responseSize(resp),
+		header: resp.Header,
+		body:   resp.Body,
+	}
+	if fs.opt.VerifyMD5 {
+		if r.md5Sum = etagMD5(resp.Header); r.md5Sum != nil {
+			r.md5 = md5.New()
+		}
+	}
+	return r
+}
+
+// responseSize returns the size of the object from resp: the total in its
+// Content-Range if it is a ranged response, or else its Content-Length. It
+// returns -1 if the size is unknown (e.g., for a chunked response from an
+// S3-compatible gateway).
+func responseSize(resp *http.Response) int64 {
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		size, err := parseContentRangeSize(cr)
+		if err != nil {
+			return -1
+		}
+		return size
+	}
+	return resp.ContentLength
+}
+
+// isInvalidRange reports whether err is S3's response to a request for a
+// range that starts past the end of the object.
+func isInvalidRange(err error) bool {
+	var e *S3Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusRequestedRangeNotSatisfiable
+}
+
+// etagMD5 returns the MD5 of an object's data according to the ETag in the
+// response header h, or nil if the ETag is not known to be the MD5.
+func etagMD5(h http.Header) []byte {
+	if strings.HasPrefix(h.Get("X-Amz-Server-Side-Encryption"), "aws:kms") ||
+		h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.Trim(h.Get("ETag"), `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, &os.PathError{Op: "read", Path: r.fs.url(r.name), Err: os.ErrClosed}
+	}
+	if r.size >= 0 && r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		resp, err := r.get(fmt.Sprintf("bytes=%d-", r.off))
+		if err != nil {
+			if r.size < 0 && isInvalidRange(err) {
+				return 0, io.EOF // r.off is past the end
+			}
+			return 0, err
+		}
+		if r.size < 0 {
+			r.size = responseSize(resp)
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	if r.read += int64(n); n > 0 && r.progress != nil {
+		r.progress(r.read, r.size)
+	}
+	if err == io.EOF {
+		r.body.Close()
+		r.body = nil
+		if r.size < 0 {
+			r.size = r.off // the body extended to the end of the object
+		} else if r.off < r.size {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if r.md5 != nil {
+		r.md5.Write(p[:n])
+		if r.size >= 0 && r.off >= r.size {
+			sum := r.md5.Sum(nil)
+			r.md5 = nil
+			if !bytes.Equal(sum, r.md5Sum) {
+				return n, &os.PathError{Op: "read", Path: r.fs.url(r.name), Err: ErrChecksumMismatch}
+			}
+		}
+	}
+	if err != nil && err != io.EOF {
+		err = r.readError(err)
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes starting at off with a single ranged GET. It
+// does not affect the offset of Read, and it may be called concurrently.
+// If the size of the object is not known, the end of the object is
+// determined from the response.
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, &os.PathError{Op: "read", Path: r.fs.url(r.name), Err: os.ErrClosed}
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "read", Path: r.fs.url(r.name), Err: fmt.Errorf("negative offset %d", off)}
+	}
+	size := r.size // not updated here, since ReadAt may be called concurrently
+	if size >= 0 && off >= size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := off + int64(len(p))
+	if size >= 0 && end > size {
+		end = size
+	}
+	resp, err := r.get(fmt.Sprintf("bytes=%d-%d", off, end-1))
+	if err != nil {
+		if size < 0 && isInvalidRange(err) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, p[:end-off])
+	if err != nil {
+		if size < 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			if total := responseSize(resp); total >= 0 && off+int64(n) >= total {
+				return n, io.EOF
+			}
+		}
+		return n, r.readError(err)
+	}
+	if end < off+int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek sets the offset of the next Read. It makes no request, unless it is
+// relative to the end and the size of the object is not known, in which
+// case it gets the size with a ranged GET.
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, &os.PathError{Op: "seek", Path: r.fs.url(r.name), Err: os.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		if r.size < 0 {
+			if err := r.fetchSize(); err != nil {
+				return 0, err
+			}
+		}
+		offset += r.size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: r.fs.url(r.name), Err: fmt.Errorf("invalid whence %d", whence)}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: r.fs.url(r.name), Err: fmt.Errorf("negative offset %d", offset)}
+	}
+	if offset != r.off {
+		if r.body != nil {
+			r.body.Close()
+			r.body = nil
+		}
+		r.md5 = nil
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Size returns the size of the object, from the Content-Length of the GET
+// response that opened it, so it is usually known before the first Read.
+// It is -1 if that response had no Content-Length and the size has not
+// been determined since.
+func (r *reader) Size() int64 {
+	return r.size
+}
+
+// fetchSize sets r.size from the Content-Range of a GET of the first byte
+// of the object.
+func (r *reader) fetchSize() error {
+	resp, err := r.get("bytes=0-0")
+	if isInvalidRange(err) {
+		r.size = 0 // the object is empty
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if r.size = responseSize(resp); r.size < 0 {
+		return &os.PathError{Op: "seek", Path: r.fs.url(r.name), Err: errors.New("object size is unknown")}
+	}
+	return nil
+}
+
+func (r *reader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// get issues a GET request for the given range of the object, conditional
+// on the object not having changed since it was opened.
+func (r *reader) get(rangeHeader string) (*http.Response, error) {
+	h := make(http.Header)
+	h.Set("Range", rangeHeader)
+	if r.etag != "" {
+		h.Set("If-Match", r.etag)
+	}
+	return r.fs.getContext(r.ctx, r.name, h)
+}
+
+// readError returns the error for err, which occurred while reading a
+// response body.
+func (r *reader) readError(err error) error {
+	if r.ctx.Err() != nil {
+		err = r.ctx.Err()
+	}
+	return &os.PathError{Op: "read", Path: r.fs.url(r.name), Err: err}
+}
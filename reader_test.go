@@ -0,0 +1,273 @@
+package s3vfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+)
+
+func TestOpen_seek(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	f.put("f", data)
+
+	rc, err := fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if _, err := rc.Seek(50, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	f.reset()
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(rc, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "0123" {
+		t.Errorf("after Seek(50): got %q, want %q", b, "0123")
+	}
+	if reqs := f.received(); len(reqs) != 1 || reqs[0].Header.Get("Range") != "bytes=50-" {
+		t.Errorf("got %d requests, want 1 for range bytes=50-", len(reqs))
+	}
+
+	// Sequential reads continue the same response.
+	rest, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, data[54:]) {
+		t.Errorf("got rest %q, want %q", rest, data[54:])
+	}
+	if n := len(f.received()); n != 1 {
+		t.Errorf("got %d requests after reading to the end, want 1", n)
+	}
+
+	if _, err := rc.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(rc); string(b) != "789" {
+		t.Errorf("after Seek(-3, SeekEnd): got %q, want %q", b, "789")
+	}
+}
+
+func TestOpen_size(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	for _, size := range []int{0, 1, 1000} {
+		f.put("f", bytes.Repeat([]byte("x"), size))
+		rc, err := fs.Open("f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, ok := rc.(interface{ Size() int64 })
+		if !ok {
+			t.Fatal("file has no Size method")
+		}
+		if got := s.Size(); got != int64(size) {
+			t.Errorf("got size %d, want %d", got, size)
+		}
+		rc.Close()
+	}
+}
+
+func TestOpen_unknownSize(t *testing.T) {
+	f := newFakeS3(t)
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	f.put("f", data)
+	// A gateway that sends chunked responses, without Content-Length.
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err == nil {
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+		}
+		return resp, err
+	})}
+	fs, err := New(f.bucketURL(), config, &Options{VerifyMD5: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := func() vfs.ReadSeekCloser {
+		t.Helper()
+		rc, err := fs.Open("f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rc
+	}
+
+	rc := open()
+	defer rc.Close()
+	size := rc.(interface{ Size() int64 })
+	if got := size.Size(); got != -1 {
+		t.Errorf("got size %d before reading, want -1", got)
+	}
+	if b, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("got %q, %v, want the data", b, err)
+	}
+	if got := size.Size(); got != int64(len(data)) {
+		t.Errorf("got size %d after reading, want %d", got, len(data))
+	}
+
+	rc = open()
+	defer rc.Close()
+	if _, err := rc.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(rc); err != nil || string(b) != "789" {
+		t.Errorf("after Seek(-3, SeekEnd): got %q, %v, want %q", b, err, "789")
+	}
+
+	rc = open()
+	defer rc.Close()
+	ra := rc.(io.ReaderAt)
+	b := make([]byte, 5)
+	if n, err := ra.ReadAt(b, 98); n != 2 || err != io.EOF || string(b[:n]) != "89" {
+		t.Errorf("ReadAt(98): got %d, %v, %q, want 2, EOF, %q", n, err, b[:n], "89")
+	}
+	if n, err := ra.ReadAt(b, 100); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(100): got %d, %v, want 0, EOF", n, err)
+	}
+	if _, err := rc.Seek(200, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := rc.Read(b); n != 0 || err != io.EOF {
+		t.Errorf("Read past the end: got %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestOpen_readAt(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("f", bytes.Repeat([]byte("0123456789"), 10))
+
+	rc, err := fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	ra, ok := rc.(io.ReaderAt)
+	if !ok {
+		t.Fatal("file does not implement io.ReaderAt")
+	}
+
+	b := make([]byte, 5)
+	if n, err := ra.ReadAt(b, 23); n != 5 || err != nil || string(b) != "34567" {
+		t.Errorf("ReadAt(23): got %d, %v, %q, want 5, nil, %q", n, err, b, "34567")
+	}
+	if n, err := ra.ReadAt(b, 98); n != 2 || err != io.EOF || string(b[:n]) != "89" {
+		t.Errorf("ReadAt(98): got %d, %v, %q, want 2, EOF, %q", n, err, b[:n], "89")
+	}
+	if n, err := ra.ReadAt(b, 100); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(100): got %d, %v, want 0, EOF", n, err)
+	}
+
+	// Reads fail once the object is replaced.
+	f.put("f", []byte("new"))
+	if _, err := ra.ReadAt(b, 0); err == nil {
+		t.Error("ReadAt after the object changed: got nil error")
+	}
+}
+
+func TestOpen_zip(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte(name), 1000))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.put("archive.zip", buf.Bytes())
+
+	f.reset()
+	rc, err := fs.Open("archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	file := rc.(interface {
+		io.ReaderAt
+		Size() int64
+	})
+	zr, err := zip.NewReader(file, file.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	zf, err := zr.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zf)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte("b.txt"), 1000)) {
+		t.Errorf("got %d bytes of b.txt, %v", len(data), err)
+	}
+
+	// After the GET that opened it, the archive is only read in ranges.
+	for _, req := range f.received()[1:] {
+		if req.Header.Get("Range") == "" {
+			t.Errorf("got %s request without a Range header", req.Method)
+		}
+	}
+}
+
+func TestOpen_VerifyMD5(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{VerifyMD5: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	f.put("f", data)
+
+	rc, err := fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("got %q, %v, want the data", b, err)
+	}
+	rc.Close()
+
+	f.corruptReads = true
+	rc, err = fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(rc); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got error %v for corrupted data, want ErrChecksumMismatch", err)
+	}
+	rc.Close()
+
+	// Data that is not read from the start can't be verified.
+	rc, err = fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Seek(50, io.SeekStart)
+	if _, err := ioutil.ReadAll(rc); err != nil {
+		t.Errorf("after Seek: got error %v", err)
+	}
+	rc.Close()
+
+	// Without the option, corrupted data is not detected.
+	if b, err := vfs.ReadFile(f.fs(), "f"); err != nil || bytes.Equal(b, data) {
+		t.Errorf("without VerifyMD5: got %q, %v, want corrupted data", b, err)
+	}
+}
@@ -0,0 +1,65 @@
+package s3vfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// ReadOnlyFileSystem is a filesystem whose write operations (Create, Mkdir,
+// MkdirAll, Remove, RemoveAll, and Rename) fail with ErrReadOnly without
+// calling the underlying filesystem, as with a read-only mount. Reads are
+// passed through. Unlike Options.ReadOnly, it can wrap any filesystem, so
+// code can be handed a read-only view of a filesystem that is writable
+// elsewhere.
+type ReadOnlyFileSystem struct {
+	rwvfs.FileSystem
+}
+
+// ReadOnly returns a read-only view of fs.
+func ReadOnly(fs rwvfs.FileSystem) *ReadOnlyFileSystem {
+	return &ReadOnlyFileSystem{FileSystem: fs}
+}
+
+func (fs *ReadOnlyFileSystem) String() string {
+	return fmt.Sprintf("readonly(%s)", fs.FileSystem)
+}
+
+func (fs *ReadOnlyFileSystem) Create(path string) (io.WriteCloser, error) {
+	return nil, &os.PathError{Op: "create", Path: path, Err: ErrReadOnly}
+}
+
+func (fs *ReadOnlyFileSystem) Mkdir(name string) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+// MkdirAll implements rwvfs.MkdirAllOverrider.
+func (fs *ReadOnlyFileSystem) MkdirAll(name string) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+}
+
+func (fs *ReadOnlyFileSystem) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+func (fs *ReadOnlyFileSystem) RemoveAll(path string) error {
+	return &os.PathError{Op: "removeall", Path: path, Err: ErrReadOnly}
+}
+
+func (fs *ReadOnlyFileSystem) Rename(oldpath, newpath string) error {
+	return &os.PathError{Op: "rename", Path: oldpath, Err: ErrReadOnly}
+}
+
+// Glob returns the paths that match pattern, using the underlying
+// filesystem's Glob method if it has one (as *S3FS does), and otherwise
+// walking the filesystem.
+func (fs *ReadOnlyFileSystem) Glob(pattern string) ([]string, error) {
+	if g, ok := fs.FileSystem.(interface {
+		Glob(pattern string) ([]string, error)
+	}); ok {
+		return g.Glob(pattern)
+	}
+	return rwvfs.Glob(rwvfs.Walkable(fs.FileSystem), "", pattern)
+}
@@ -0,0 +1,54 @@
+package s3vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReadOnlyFileSystem(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("d/f", []byte("x"))
+	fs := ReadOnly(f.fs())
+
+	if _, err := fs.Create("g"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Create: got error %v, want ErrReadOnly", err)
+	}
+	for name, fn := range map[string]func() error{
+		"Mkdir":     func() error { return fs.Mkdir("e") },
+		"MkdirAll":  func() error { return fs.MkdirAll("e/f") },
+		"Remove":    func() error { return fs.Remove("d/f") },
+		"RemoveAll": func() error { return fs.RemoveAll("d") },
+		"Rename":    func() error { return fs.Rename("d/f", "g") },
+	} {
+		if err := fn(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got error %v, want ErrReadOnly", name, err)
+		}
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests for write operations, want none", n)
+	}
+
+	rc, err := fs.Open("d/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "x" {
+		t.Errorf("Open: got %q, %v", data, err)
+	}
+	if _, err := fs.Stat("d/f"); err != nil {
+		t.Errorf("Stat: %s", err)
+	}
+	if fis, err := fs.ReadDir("d"); err != nil || len(fis) != 1 {
+		t.Errorf("ReadDir: got %v, %v", fis, err)
+	}
+	if matches, err := fs.Glob("d/*"); err != nil || fmt.Sprint(matches) != "[d/f]" {
+		t.Errorf("Glob: got %v, %v", matches, err)
+	}
+	if _, ok := f.get("d/f"); !ok {
+		t.Error("d/f removed through read-only filesystem")
+	}
+}
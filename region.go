@@ -0,0 +1,261 @@
+package s3vfs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// region returns the AWS region of the bucket: the region that S3
+// redirected requests to (see followRegionRedirect), if any, or else the
+// region configured in Options.Region or determined from the bucket URL's
+// host. It returns the empty string if the region is unknown (e.g., for
+// non-AWS endpoints).
+func (fs *S3FS) region() string {
+	fs.redirectMu.Lock()
+	region := fs.redirectRegion
+	fs.redirectMu.Unlock()
+	if region != "" {
+		return region
+	}
+	if fs.opt.Region != "" {
+		return fs.opt.Region
+	}
+	return regionFromHost(fs.bucket.Host)
+}
+
+// followRegionRedirect reports whether resp is S3's redirect of req to the
+// region that the bucket actually resides in (given by the
+// X-Amz-Bucket-Region header), and if so, prepares req to be resent to
+// that region's endpoint, which is also used for all later requests. It
+// only redirects requests to AWS endpoints whose host names a region, and
+// requests whose body can be sent again.
+func (fs *S3FS) followRegionRedirect(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusBadRequest:
+	default:
+		return false
+	}
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	if region == "" || region == fs.region() || fs.mrapARN != "" {
+		return false
+	}
+	host := regionHost(fs.bucket.Host, region)
+	if host == "" {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		req.Body = body
+	}
+
+	fs.redirectMu.Lock()
+	fs.redirectRegion, fs.redirectHost = region, host
+	fs.redirectMu.Unlock()
+	fs.logf("bucket %s is in region %s; redirecting requests to %s", fs.bucket, region, host)
+	req.URL.Host, req.Host = host, ""
+	return true
+}
+
+// Region returns the AWS region of the bucket: the region that S3
+// redirected requests to, if it did, or else
+// Options.Region or the region named in the bucket URL's host. It returns
+// the empty string if the region is unknown (e.g., for S3-compatible
+// services).
+func (fs *S3FS) Region() string {
+	return fs.region()
+}
+
+// Bucket returns the name of the bucket, from the host of a
+// virtual-hosted-style AWS S3 bucket URL or else the first element of the
+// URL path, or the ARN of the Multi-Region Access Point the filesystem was
+// created with. It returns the empty string if the URL does not name the
+// bucket in a way it recognizes.
+func (fs *S3FS) Bucket() string {
+	if fs.mrapARN != "" {
+		return fs.mrapARN
+	}
+	if b := virtualHostBucket(fs.bucket.Hostname()); b != "" {
+		return b
+	}
+	return strings.SplitN(strings.TrimPrefix(fs.bucket.Path, "/"), "/", 2)[0]
+}
+
+// Endpoint returns the URL of the bucket that requests are sent to. It
+// differs from the URL given to New if UseDualStack or DisableSSL is set,
+// or once S3 has redirected requests to the bucket's region.
+func (fs *S3FS) Endpoint() string {
+	u := *fs.bucket
+	fs.redirectMu.Lock()
+	if fs.redirectHost != "" {
+		u.Host = fs.redirectHost
+	}
+	fs.redirectMu.Unlock()
+	return u.String()
+}
+
+// redirect makes req, a request to the bucket URL's host, go to the host of
+// the region that S3 redirected earlier requests to, if any.
+func (fs *S3FS) redirect(req *http.Request) {
+	fs.redirectMu.Lock()
+	host := fs.redirectHost
+	fs.redirectMu.Unlock()
+	if host != "" && req.URL.Host == fs.bucket.Host {
+		req.URL.Host, req.Host = host, ""
+	}
+}
+
+// regionHost returns the host of the S3 endpoint for region that corresponds
+// to host, an AWS S3 endpoint host for another region (e.g.,
+// "s3.eu-central-1.amazonaws.com" for "s3-us-west-2.amazonaws.com"). It
+// returns the empty string if host is not an AWS S3 endpoint.
+func regionHost(host, region string) string {
+	if regionFromHost(host) == "" {
+		return ""
+	}
+	var port string
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host, port = host[:i], host[i:]
+	}
+	labels := strings.Split(strings.TrimSuffix(host, ".amazonaws.com"), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		switch l := labels[i]; {
+		case l == "s3" && i == len(labels)-1, strings.HasPrefix(l, "s3-"):
+			labels = append(labels[:i], "s3", region)
+		case l == "s3":
+			labels[len(labels)-1] = region
+		default:
+			continue
+		}
+		return strings.Join(labels, ".") + ".amazonaws.com" + port
+	}
+	return ""
+}
+
+// dualStackHost returns the host of the dual-stack endpoint of region that
+// corresponds to the AWS S3 endpoint host, keeping the bucket name of a
+// virtual-hosted-style host (e.g., "b.s3.dualstack.us-west-2.amazonaws.com"
+// for "b.s3.amazonaws.com"). It returns the empty string if host is not an
+// AWS S3 endpoint.
+func dualStackHost(host, region string) string {
+	if regionFromHost(host) == "" || region == "" {
+		return ""
+	}
+	var port string
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host, port = host[:i], host[i:]
+	}
+	labels := strings.Split(strings.TrimSuffix(host, ".amazonaws.com"), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if l := labels[i]; l == "s3" || strings.HasPrefix(l, "s3-") {
+			labels = append(labels[:i], "s3", "dualstack", region)
+			return strings.Join(labels, ".") + ".amazonaws.com" + port
+		}
+	}
+	return ""
+}
+
+// DetectRegion returns the AWS region that the bucket at the given URL
+// resides in, as reported by S3 in the X-Amz-Bucket-Region header of the
+// response to an unauthenticated HEAD request for the bucket (which S3
+// sends even if the request is denied). The request is sent with client,
+// or http.DefaultClient if it is nil.
+func DetectRegion(bucket *url.URL, client *http.Client) (string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Head(bucket.String())
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	if region == "" {
+		return "", fmt.Errorf("s3vfs: no region reported for bucket %s (HTTP status %d)", bucket, resp.StatusCode)
+	}
+	return region, nil
+}
+
+// regionFromHost returns the AWS region named in an S3 endpoint host, such as
+// "s3-us-west-2.amazonaws.com", "mybucket.s3.eu-west-1.amazonaws.com", or
+// "s3.amazonaws.com" (us-east-1). It returns the empty string if host is not
+// an AWS S3 endpoint.
+func regionFromHost(host string) string {
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	if !strings.HasSuffix(host, ".amazonaws.com") {
+		return ""
+	}
+	// Scan from the right so that bucket names in virtual-hosted-style hosts
+	// aren't mistaken for the endpoint.
+	labels := strings.Split(strings.TrimSuffix(host, ".amazonaws.com"), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		switch l := labels[i]; {
+		case l == "s3" && i == len(labels)-1, l == "s3-external-1":
+			return "us-east-1"
+		case l == "s3":
+			return labels[len(labels)-1]
+		case strings.HasPrefix(l, "s3-"):
+			return strings.TrimPrefix(l, "s3-")
+		}
+	}
+	return ""
+}
+
+// BucketLocation returns the region the bucket resides in, as reported by
+// S3's GetBucketLocation operation.
+func (fs *S3FS) BucketLocation() (string, error) {
+	u := fs.bucket.ResolveReference(&url.URL{RawQuery: "location"})
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fs.newS3Error(resp)
+	}
+	defer resp.Body.Close()
+
+	var loc struct {
+		Region string `xml:",chardata"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&loc); err != nil {
+		return "", err
+	}
+	switch loc.Region {
+	case "":
+		return "us-east-1", nil
+	case "EU":
+		return "eu-west-1", nil
+	}
+	return loc.Region, nil
+}
+
+// verifyRegion returns an error if the bucket's actual region differs from
+// the configured region.
+func (fs *S3FS) verifyRegion() error {
+	want := fs.region()
+	if want == "" {
+		return fmt.Errorf("s3vfs: can't verify region of bucket %s: no region configured", fs.bucket)
+	}
+	got, err := fs.BucketLocation()
+	if err != nil {
+		return fmt.Errorf("s3vfs: getting location of bucket %s: %s", fs.bucket, err)
+	}
+	if got != want {
+		return fmt.Errorf("s3vfs: bucket %s is in %s but configured region is %s", fs.bucket, got, want)
+	}
+	return nil
+}
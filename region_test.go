@@ -0,0 +1,226 @@
+package s3vfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sqs/s3/s3util"
+)
+
+func TestRegionFromHost(t *testing.T) {
+	tests := map[string]string{
+		"s3.amazonaws.com":                      "us-east-1",
+		"s3-external-1.amazonaws.com":           "us-east-1",
+		"s3-us-west-2.amazonaws.com":            "us-west-2",
+		"mybucket.s3-us-west-2.amazonaws.com":   "us-west-2",
+		"mybucket.s3.eu-west-1.amazonaws.com":   "eu-west-1",
+		"s3-mybucket.s3.amazonaws.com":          "us-east-1",
+		"s3.dualstack.ap-south-1.amazonaws.com": "ap-south-1",
+		"s3-us-west-2.amazonaws.com:443":        "us-west-2",
+		"minio.example.com":                     "",
+		"127.0.0.1:9000":                        "",
+	}
+	for host, want := range tests {
+		if got := regionFromHost(host); got != want {
+			t.Errorf("regionFromHost(%q): got %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestNew_VerifyRegion(t *testing.T) {
+	f := newFakeS3(t)
+	f.region = "eu-west-1"
+
+	if _, err := New(f.bucketURL(), f.config(), &Options{Region: "eu-west-1", VerifyRegion: true}); err != nil {
+		t.Errorf("matching region: %s", err)
+	}
+
+	_, err := New(f.bucketURL(), f.config(), &Options{Region: "us-west-2", VerifyRegion: true})
+	if err == nil || !strings.Contains(err.Error(), "is in eu-west-1 but configured region is us-west-2") {
+		t.Errorf("mismatched region: got error %v", err)
+	}
+
+	if _, err := New(f.bucketURL(), f.config(), &Options{VerifyRegion: true}); err == nil {
+		t.Error("unknown region: got nil error")
+	}
+
+	f.region = ""
+	if _, err := New(f.bucketURL(), f.config(), &Options{Region: "us-east-1", VerifyRegion: true}); err != nil {
+		t.Errorf("empty location constraint: %s", err)
+	}
+}
+
+func TestRegionHost(t *testing.T) {
+	tests := map[string]string{
+		"s3.amazonaws.com":                      "s3.eu-central-1.amazonaws.com",
+		"s3-external-1.amazonaws.com":           "s3.eu-central-1.amazonaws.com",
+		"s3-us-west-2.amazonaws.com":            "s3.eu-central-1.amazonaws.com",
+		"mybucket.s3-us-west-2.amazonaws.com":   "mybucket.s3.eu-central-1.amazonaws.com",
+		"mybucket.s3.us-west-2.amazonaws.com":   "mybucket.s3.eu-central-1.amazonaws.com",
+		"s3-mybucket.s3.amazonaws.com":          "s3-mybucket.s3.eu-central-1.amazonaws.com",
+		"s3.dualstack.ap-south-1.amazonaws.com": "s3.dualstack.eu-central-1.amazonaws.com",
+		"s3.us-west-2.amazonaws.com:443":        "s3.eu-central-1.amazonaws.com:443",
+		"minio.example.com":                     "",
+	}
+	for host, want := range tests {
+		if got := regionHost(host, "eu-central-1"); got != want {
+			t.Errorf("%s: got %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestDualStackHost(t *testing.T) {
+	tests := map[string]string{
+		"s3.amazonaws.com":                      "s3.dualstack.eu-central-1.amazonaws.com",
+		"s3-external-1.amazonaws.com":           "s3.dualstack.eu-central-1.amazonaws.com",
+		"mybucket.s3-us-west-2.amazonaws.com":   "mybucket.s3.dualstack.eu-central-1.amazonaws.com",
+		"mybucket.s3.us-west-2.amazonaws.com":   "mybucket.s3.dualstack.eu-central-1.amazonaws.com",
+		"s3.dualstack.ap-south-1.amazonaws.com": "s3.dualstack.eu-central-1.amazonaws.com",
+		"s3.us-west-2.amazonaws.com:443":        "s3.dualstack.eu-central-1.amazonaws.com:443",
+		"minio.example.com":                     "",
+	}
+	for host, want := range tests {
+		if got := dualStackHost(host, "eu-central-1"); got != want {
+			t.Errorf("%s: got %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestNew_UseDualStack(t *testing.T) {
+	var got *url.URL
+	config := newFakeS3(t).config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.URL
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})}
+	tests := []struct {
+		bucket, region string
+		want           string
+	}{
+		{"https://s3.us-west-2.amazonaws.com/mybucket", "", "s3.dualstack.us-west-2.amazonaws.com/mybucket/a"},
+		{"https://mybucket.s3.us-west-2.amazonaws.com", "", "mybucket.s3.dualstack.us-west-2.amazonaws.com/a"},
+		{"https://mybucket.s3.amazonaws.com", "eu-west-1", "mybucket.s3.dualstack.eu-west-1.amazonaws.com/a"},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.bucket)
+		fs, err := New(u, config, &Options{UseDualStack: true, Region: test.region})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat("a"); err != nil {
+			t.Fatal(err)
+		}
+		if got.Host+got.Path != test.want {
+			t.Errorf("%s: got request for %s, want %s", test.bucket, got.Host+got.Path, test.want)
+		}
+	}
+
+	u, _ := url.Parse("http://minio.example.com/mybucket")
+	if _, err := New(u, config, &Options{UseDualStack: true}); err == nil {
+		t.Error("got no error for non-AWS endpoint")
+	}
+}
+
+// regionRedirectConfig returns a config whose client sends requests for
+// s3.eu-central-1.amazonaws.com to the fake S3 server, and responds to those
+// for any other host with a redirect to eu-central-1.
+func regionRedirectConfig(f *fakeS3, hosts *[]string) *s3util.Config {
+	config := f.config()
+	fakeURL, _ := url.Parse(f.URL)
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		*hosts = append(*hosts, r.URL.Host)
+		if r.URL.Host != "s3.eu-central-1.amazonaws.com" {
+			return &http.Response{
+				StatusCode: http.StatusMovedPermanently,
+				Header:     http.Header{"X-Amz-Bucket-Region": {"eu-central-1"}},
+				Body:       ioutil.NopCloser(strings.NewReader("<Error><Code>PermanentRedirect</Code></Error>")),
+				Request:    r,
+			}, nil
+		}
+		r = r.Clone(r.Context())
+		r.URL.Scheme, r.URL.Host, r.Host = fakeURL.Scheme, fakeURL.Host, ""
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	return config
+}
+
+func TestRegionRedirect(t *testing.T) {
+	f := newFakeS3(t)
+	var hosts []string
+	bucket, _ := url.Parse("https://s3.us-west-2.amazonaws.com/" + fakeBucket)
+	fs, err := New(bucket, regionRedirectConfig(f, &hosts), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createFile(t, fs, "f", []byte("data"))
+	if b, err := fs.ReadFile("f"); err != nil || string(b) != "data" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	want := "[s3.us-west-2.amazonaws.com s3.eu-central-1.amazonaws.com s3.eu-central-1.amazonaws.com]"
+	if got := fmt.Sprint(hosts); got != want {
+		t.Errorf("got requests to %s, want %s", got, want)
+	}
+	if got := fs.Region(); got != "eu-central-1" {
+		t.Errorf("got region %q, want eu-central-1", got)
+	}
+	if got, want := fs.Endpoint(), "https://s3.eu-central-1.amazonaws.com/"+fakeBucket; got != want {
+		t.Errorf("got endpoint %q, want %q", got, want)
+	}
+	// Presigned URLs are for the region's endpoint.
+	u, err := fs.PresignGet("f", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://s3.eu-central-1.amazonaws.com/" + fakeBucket + "/f?"; !strings.HasPrefix(u, want) || !strings.Contains(u, "%2Feu-central-1%2Fs3%2F") {
+		t.Errorf("got presigned URL %s, want one for %s in eu-central-1", u, want)
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	tests := []struct {
+		url    string
+		opt    *Options
+		bucket string
+		region string
+	}{
+		{"https://mybucket.s3.us-west-2.amazonaws.com/", nil, "mybucket", "us-west-2"},
+		{"https://s3.amazonaws.com/mybucket", nil, "mybucket", "us-east-1"},
+		{"https://s3.amazonaws.com/mybucket", &Options{Region: "eu-west-1"}, "mybucket", "eu-west-1"},
+		{"http://minio.local:9000/mybucket/", nil, "mybucket", ""},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		fs, err := New(u, nil, test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fs.Bucket(); got != test.bucket {
+			t.Errorf("%s: got bucket %q, want %q", test.url, got, test.bucket)
+		}
+		if got := fs.Region(); got != test.region {
+			t.Errorf("%s: got region %q, want %q", test.url, got, test.region)
+		}
+		if got := fs.Endpoint(); got != test.url {
+			t.Errorf("%s: got endpoint %q", test.url, got)
+		}
+	}
+}
+
+func TestDetectRegion(t *testing.T) {
+	f := newFakeS3(t)
+	var hosts []string
+	bucket, _ := url.Parse("https://s3.amazonaws.com/" + fakeBucket)
+	region, err := DetectRegion(bucket, regionRedirectConfig(f, &hosts).Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region != "eu-central-1" {
+		t.Errorf("got region %q, want eu-central-1", region)
+	}
+}
@@ -0,0 +1,207 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// maxDeleteKeys is the number of keys deleted per DeleteObjects request.
+// S3 allows at most 1000.
+var maxDeleteKeys = 1000
+
+// defaultRemoveAllConcurrency is the number of concurrent DeleteObjects
+// requests RemoveAllWithProgress sends if RemoveAllOptions.Concurrency is
+// not set.
+const defaultRemoveAllConcurrency = 4
+
+// RemoveAllOptions specifies how RemoveAllWithProgress removes a tree.
+type RemoveAllOptions struct {
+	// Concurrency is the maximum number of batches of keys being deleted
+	// concurrently. If zero, 4 is used.
+	Concurrency int
+
+	// Progress, if set, is called (serially) after each batch of keys is
+	// deleted, with the number of objects deleted so far and the total
+	// number to delete. The total is -1 until the whole tree has been
+	// listed.
+	Progress func(deleted, total int64)
+}
+
+// RemoveAll removes path and everything under it, like os.RemoveAll. It is
+// equivalent to RemoveAllWithProgress with nil options.
+func (fs *S3FS) RemoveAll(path string) error {
+	return fs.RemoveAllWithProgress(path, nil)
+}
+
+// RemoveAllWithProgress removes path and everything under it (including
+// directory marker objects). The tree is listed and its keys are deleted in
+// batches of up to 1000 with the DeleteObjects operation, several batches
+// at a time, as specified by opt (which may be nil).
+//
+// Keys that fail to be deleted don't stop the removal; the errors for all of
+// them are returned at the end. If path does not exist, RemoveAllWithProgress
+// does nothing and returns nil.
+func (fs *S3FS) RemoveAllWithProgress(path string, opt *RemoveAllOptions) error {
+	if fs.opt.ReadOnly {
+		return &os.PathError{Op: "removeall", Path: fs.url(path), Err: ErrReadOnly}
+	}
+	if opt == nil {
+		opt = &RemoveAllOptions{}
+	}
+	concurrency := opt.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultRemoveAllConcurrency
+	}
+
+	var (
+		mu              sync.Mutex
+		errs            errorList
+		listed, deleted int64
+		listingDone     bool
+		wg              sync.WaitGroup
+		batches         = make(chan []string)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for keys := range batches {
+				failed, err := fs.deleteObjects(keys)
+				mu.Lock()
+				errs = append(errs, failed...)
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					deleted += int64(len(keys) - len(failed))
+				}
+				if opt.Progress != nil {
+					total := int64(-1)
+					if listingDone {
+						total = listed
+					}
+					opt.Progress(deleted, total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// The object at path itself and its Hadoop-style directory marker
+	// aren't under the prefix, so they are deleted if they exist.
+	prefix := dirPrefix(path)
+	var exact []string
+	if name := strings.TrimSuffix(prefix, "/"); name != "" {
+		for _, key := range []string{name, name + folderMarkerSuffix} {
+			if resp, err := fs.head("/" + key); err == nil {
+				resp.Body.Close()
+				exact = append(exact, key)
+			} else if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	var listErr error
+	pending := exact
+	send := func(keys []string) {
+		mu.Lock()
+		listed += int64(len(keys))
+		mu.Unlock()
+		batches <- keys
+	}
+	var marker string
+	for {
+		page, err := fs.listPage(context.Background(), prefix, "", marker)
+		if err != nil {
+			listErr = err
+			break
+		}
+		for _, o := range page.Contents {
+			// A full batch is sent only once another key follows it, so
+			// that the last batch is sent after the listing is done.
+			if len(pending) == maxDeleteKeys {
+				send(pending)
+				pending = nil
+			}
+			pending = append(pending, o.Key)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		marker = page.nextMarker()
+	}
+	mu.Lock()
+	listed += int64(len(pending))
+	listingDone = listErr == nil
+	mu.Unlock()
+	if len(pending) > 0 {
+		batches <- pending
+	}
+	close(batches)
+	wg.Wait()
+
+	if listErr != nil {
+		errs = append(errs, listErr)
+	}
+	if err := errs.err(); err != nil {
+		return &os.PathError{Op: "removeall", Path: fs.url(path), Err: err}
+	}
+	return nil
+}
+
+// deleteObjects deletes the objects with the given keys (which are relative
+// to the bucket root) with a single DeleteObjects request. It returns an
+// error for each key that S3 failed to delete, or an error if the whole
+// request failed.
+func (fs *S3FS) deleteObjects(keys []string) (failed []error, err error) {
+	type object struct{ Key string }
+	objs := make([]object, len(keys))
+	for i, k := range keys {
+		objs[i] = object{Key: fs.keyPrefix + k}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"Delete"`
+		Quiet   bool
+		Objects []object `xml:"Object"`
+	}{Quiet: true, Objects: objs})
+	if err != nil {
+		return nil, err
+	}
+
+	u := fs.bucket.ResolveReference(&url.URL{RawQuery: "delete"})
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(body)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fs.newS3Error(resp)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Errors []struct{ Key, Code, Message string } `xml:"Error"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	for _, e := range result.Errors {
+		failed = append(failed, fmt.Errorf("deleting %s: %s: %s", strings.TrimPrefix(e.Key, fs.keyPrefix), e.Code, e.Message))
+	}
+	return failed, nil
+}
@@ -0,0 +1,118 @@
+package s3vfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRemoveAll(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	keys := []string{"qux/p", "qux/p/c", "qux/c", "qux/p1/p2", "qux/p1/p2/p3/c", "qux/p1/p2/p3/.keep"}
+	for _, key := range keys {
+		createFile(t, fs, key, []byte("x"))
+	}
+	createFile(t, fs, "quux/c", []byte("x"))
+
+	if err := fs.RemoveAll("/qux"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if _, ok := f.get(key); ok {
+			t.Errorf("%s not removed", key)
+		}
+	}
+	if _, ok := f.get("quux/c"); !ok {
+		t.Error("quux/c removed")
+	}
+	for _, req := range f.received() {
+		if req.Method == "DELETE" {
+			t.Errorf("got DELETE %s, want keys deleted with DeleteObjects", req.URL.Path)
+		}
+	}
+
+	if err := fs.RemoveAll("/qux"); err != nil {
+		t.Errorf("RemoveAll of missing tree: %s", err)
+	}
+}
+
+func TestRemoveAllWithProgress(t *testing.T) {
+	defer func(n, m int) { listPageSize, maxDeleteKeys = n, m }(listPageSize, maxDeleteKeys)
+	listPageSize, maxDeleteKeys = 4, 3
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 10; i++ {
+		f.put(fmt.Sprintf("d/%d/f", i), nil)
+	}
+	f.put("d", nil)
+	f.put("d_$folder$", nil)
+	f.put("d/", nil)
+	f.put("d2", nil)
+	f.put("e/f", nil)
+
+	var mu sync.Mutex
+	var progress []string
+	err := fs.RemoveAllWithProgress("/d", &RemoveAllOptions{
+		Concurrency: 3,
+		Progress: func(deleted, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress = append(progress, fmt.Sprintf("%d/%d", deleted, total))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining []string
+	for _, key := range []string{"d", "d_$folder$", "d/", "d/0/f", "d/9/f", "d2", "e/f"} {
+		if _, ok := f.get(key); ok {
+			remaining = append(remaining, key)
+		}
+	}
+	if got, want := fmt.Sprint(remaining), "[d2 e/f]"; got != want {
+		t.Errorf("got remaining keys %s, want %s", got, want)
+	}
+
+	// 13 keys in batches of 3; the total is known by the last batch.
+	if len(progress) != 5 || progress[4] != "13/13" {
+		t.Errorf("got progress %v, want 5 calls ending with 13/13", progress)
+	}
+}
+
+func TestRemoveAllWithProgress_partialFailure(t *testing.T) {
+	defer func(m int) { maxDeleteKeys = m }(maxDeleteKeys)
+	maxDeleteKeys = 2
+
+	f := newFakeS3(t)
+	fs := f.fs()
+	for i := 0; i < 6; i++ {
+		f.put(fmt.Sprintf("d/%d", i), nil)
+	}
+	f.undeletable = map[string]bool{"d/1": true, "d/4": true}
+
+	err := fs.RemoveAllWithProgress("d", nil)
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+	for _, key := range []string{"d/1", "d/4"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not mention %s", err, key)
+		}
+	}
+	fis, _ := fs.ReadDir("d")
+	if len(fis) != 2 {
+		t.Errorf("got %d remaining objects, want 2", len(fis))
+	}
+
+	if err := fs.RemoveAllWithProgress("nonexistent", nil); err != nil {
+		t.Errorf("nonexistent path: %s", err)
+	}
+	if _, err := fs.Stat("nonexistent"); !os.IsNotExist(err) {
+		t.Errorf("got Stat error %v, want not exist", err)
+	}
+}
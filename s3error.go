@@ -0,0 +1,99 @@
+package s3vfs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// S3Error is the error for an S3 response with an unexpected status. Use
+// errors.As to inspect it (e.g., to tell AccessDenied from SlowDown).
+//
+// Errors for missing objects satisfy os.IsNotExist instead, and those for a
+// missing bucket satisfy errors.Is(err, ErrNoSuchBucket).
+type S3Error struct {
+	StatusCode int    // HTTP status code
+	Code       string // S3 error code (e.g., "AccessDenied"), or "" if none
+	Message    string // S3 error message, or "" if none
+	RequestID  string // ID of the request, for AWS support
+	Key        string // key of the object, or "" for bucket requests
+
+	body []byte
+}
+
+// newS3Error returns the error for resp, reading and closing its body. S3
+// describes errors with an XML document in the body, except for responses
+// to HEAD requests.
+func (fs *S3FS) newS3Error(resp *http.Response) *S3Error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var doc struct {
+		Code      string
+		Message   string
+		RequestID string `xml:"RequestId"`
+		Key       string
+	}
+	xml.Unmarshal(body, &doc)
+
+	e := &S3Error{
+		StatusCode: resp.StatusCode,
+		Code:       doc.Code,
+		Message:    doc.Message,
+		RequestID:  doc.RequestID,
+		Key:        strings.TrimPrefix(doc.Key, fs.keyPrefix),
+		body:       body,
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Amz-Request-Id")
+	}
+	if e.Key == "" && resp.Request != nil {
+		e.Key = fs.requestKey(resp.Request)
+	}
+	return e
+}
+
+func (e *S3Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "s3vfs: HTTP status %d", e.StatusCode)
+	if e.Code != "" {
+		fmt.Fprintf(&b, " %s", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	} else if e.Code == "" && len(e.body) > 0 {
+		fmt.Fprintf(&b, ": %q", e.body)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request ID %s)", e.RequestID)
+	}
+	return b.String()
+}
+
+// Unwrap returns ErrNoSuchBucket if the response is S3's NoSuchBucket error,
+// so that errors.Is(err, ErrNoSuchBucket) reports it.
+func (e *S3Error) Unwrap() error {
+	if e.Code == "NoSuchBucket" {
+		return ErrNoSuchBucket
+	}
+	return nil
+}
+
+// notFoundError returns the error for resp, a 404 response: an *S3Error
+// wrapping ErrNoSuchBucket if the bucket does not exist, and os.ErrNotExist
+// otherwise.
+func (fs *S3FS) notFoundError(resp *http.Response) error {
+	if e := fs.newS3Error(resp); e.Code == "NoSuchBucket" {
+		return e
+	}
+	return os.ErrNotExist
+}
+
+// requestKey returns the key of the object that req is for, or "" if it is
+// a request for the bucket.
+func (fs *S3FS) requestKey(req *http.Request) string {
+	key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, fs.bucket.Path), "/")
+	return strings.TrimPrefix(key, fs.keyPrefix)
+}
@@ -0,0 +1,75 @@
+package s3vfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestS3Error(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+
+	tests := []struct {
+		status int
+		header http.Header
+		body   string
+		want   S3Error
+	}{
+		{
+			status: http.StatusForbidden,
+			body: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>AccessDenied</Code><Message>Access Denied</Message><RequestId>4442587FB7D0A2F9</RequestId><HostId>xyz</HostId></Error>`,
+			want: S3Error{StatusCode: 403, Code: "AccessDenied", Message: "Access Denied", RequestID: "4442587FB7D0A2F9", Key: "a/b"},
+		},
+		{
+			status: http.StatusServiceUnavailable,
+			body:   `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message><RequestId>R1</RequestId></Error>`,
+			want:   S3Error{StatusCode: 503, Code: "SlowDown", Message: "Please reduce your request rate.", RequestID: "R1", Key: "a/b"},
+		},
+		{
+			status: http.StatusNotFound,
+			body:   `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>other</Key><RequestId>R2</RequestId></Error>`,
+			want:   S3Error{StatusCode: 404, Code: "NoSuchKey", Message: "The specified key does not exist.", RequestID: "R2", Key: "other"},
+		},
+		{
+			// HEAD responses have no body.
+			status: http.StatusForbidden,
+			header: http.Header{"X-Amz-Request-Id": {"R3"}},
+			want:   S3Error{StatusCode: 403, RequestID: "R3", Key: "a/b"},
+		},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", fs.url("a/b"), nil)
+		resp := &http.Response{
+			StatusCode: test.status,
+			Header:     test.header,
+			Body:       ioutil.NopCloser(strings.NewReader(test.body)),
+			Request:    req,
+		}
+		e := fs.newS3Error(resp)
+		e.body = nil
+		if !reflect.DeepEqual(*e, test.want) {
+			t.Errorf("got %+v, want %+v", *e, test.want)
+		}
+	}
+
+	// Errors from operations can be inspected.
+	fs, err := New(f.bucketURL(), f.config(), &Options{MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.serverErrors = 1
+	_, err = fs.Open("f")
+	var e *S3Error
+	if !errors.As(err, &e) || e.StatusCode != 500 || e.Code != "InternalError" || e.Key != "f" {
+		t.Errorf("got error %#v, want S3Error for InternalError", err)
+	}
+	if _, err := fs.Open("f"); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want os.IsNotExist-satisfying", err)
+	}
+}
@@ -2,7 +2,10 @@ package s3vfs
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,7 +13,9 @@ import (
 	"net/url"
 	"os"
 	pathpkg "path"
-	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/tools/godoc/vfs"
@@ -35,17 +40,547 @@ var DefaultS3Config = s3util.Config{
 //
 // The bucket URL is the full URL to the bucket on Amazon S3, including the
 // bucket name and AWS region (e.g.,
-// https://s3-us-west-2.amazonaws.com/mybucket).
+// https://s3-us-west-2.amazonaws.com/mybucket). Requests are addressed as
+// the URL is, so it may also name a bucket on an S3-compatible service (see
+// BucketURL).
 func S3(bucket *url.URL, config *s3util.Config) rwvfs.FileSystem {
 	if config == nil {
 		config = &DefaultS3Config
 	}
-	return &S3FS{bucket, config}
+	return &S3FS{bucket: bucket, config: config, closed: make(chan struct{})}
+}
+
+// Options configures optional behavior of an S3 filesystem created with
+// New. The zero value gives the same behavior as S3.
+type Options struct {
+	// DisableSSL sends requests over plain HTTP, even if the bucket URL's
+	// scheme is https. It is only intended for testing against local
+	// S3-compatible gateways that don't speak TLS.
+	DisableSSL bool
+
+	// Region is the AWS region the bucket is in. If empty, it is
+	// determined from the bucket URL's host (e.g.,
+	// s3-us-west-2.amazonaws.com).
+	Region string
+
+	// UseDualStack sends requests to the dual-stack endpoint of the
+	// bucket's region (e.g., s3.dualstack.us-west-2.amazonaws.com), which
+	// is reachable over IPv6 as well as IPv4, instead of the AWS S3
+	// endpoint in the bucket URL. Both virtual-hosted-style and path-style
+	// bucket URLs are rewritten. The region is Region, if set, or else the
+	// region in the bucket URL's host. New fails if the bucket URL is not
+	// for an AWS S3 endpoint.
+	UseDualStack bool
+
+	// VerifyRegion makes New check that the bucket is actually in the
+	// configured region, so that misconfiguration fails fast instead of
+	// causing redirect errors on later requests.
+	VerifyRegion bool
+
+	// PostUploadVerify, if nonzero, makes the writers returned by Create
+	// wait after completing a multipart upload until a HEAD request shows
+	// that the object is visible, retrying with backoff for at most this
+	// long (or until the writer's context is done). This is useful for
+	// S3-compatible stores that are not read-after-write consistent for
+	// multipart uploads. Objects written with a single PUT are not polled.
+	// (AWS S3 is strongly consistent, so it is not needed there.)
+	PostUploadVerify time.Duration
+
+	// PartSize is the size of the parts that are buffered in memory and
+	// uploaded separately when writing large objects with multipart
+	// upload. Objects no larger than PartSize are uploaded with a single
+	// PUT. If zero, DefaultPartSize is used. AWS S3 requires all parts but
+	// the last to be at least 5 MiB.
+	PartSize int64
+
+	// MaxObjectSize is the size of the largest object that writers may
+	// create; a Write that would exceed it fails with ErrObjectTooLarge
+	// (as does one that would need more than 10,000 parts of PartSize,
+	// the most a multipart upload may have). If zero, DefaultMaxObjectSize
+	// is used. It may be set for S3-compatible stores with different
+	// limits.
+	MaxObjectSize int64
+
+	// MaxWriteBufferBytes, if nonzero, limits the total memory used to
+	// buffer parts across all concurrent writers on the filesystem. A
+	// writer that needs to buffer a part blocks until enough memory is
+	// available. The limit is enforced in units of PartSize, so it must be
+	// at least PartSize.
+	MaxWriteBufferBytes int64
+
+	// UploadConcurrency, if greater than 1, is the number of parts of a
+	// multipart upload that each writer uploads concurrently. Full parts
+	// are uploaded in the background while the writer buffers the next
+	// part, so a writer holds up to UploadConcurrency+1 parts in memory
+	// (subject to MaxWriteBufferBytes). Parts spilled to disk (see
+	// SpillThreshold) are always uploaded one at a time.
+	UploadConcurrency int
+
+	// SpillThreshold, if positive, limits the data of the part being
+	// written that each writer buffers in memory: past this many bytes,
+	// the part is buffered in a temporary file in TempDir instead, which
+	// is removed when the writer is closed or fails. This keeps memory use
+	// low even with a PartSize large enough that big objects are uploaded
+	// with a single PUT.
+	SpillThreshold int64
+
+	// TempDir is the directory of the temporary files that writers spill
+	// parts to (see SpillThreshold). If empty, os.TempDir() is used.
+	TempDir string
+
+	// MaxConcurrentRequests, if nonzero, limits the number of concurrent
+	// in-flight requests to S3. The limit adapts to throttling: each time S3
+	// responds with 503 Slow Down (or 429 Too Many Requests), the effective
+	// limit is halved, and it recovers additively (to at most
+	// MaxConcurrentRequests) as requests succeed.
+	MaxConcurrentRequests int
+
+	// OnConcurrencyChange, if set, is called with the new effective
+	// concurrency limit whenever it changes (see MaxConcurrentRequests).
+	OnConcurrencyChange func(limit int)
+
+	// MaxConcurrentReads and MaxConcurrentWrites, if nonzero, limit the
+	// number of concurrent read (GET and HEAD) and write (PUT, POST, and
+	// DELETE) requests, respectively, so that reads can fan out widely
+	// while writes stay bounded. They apply in addition to
+	// MaxConcurrentRequests, if it is also set.
+	MaxConcurrentReads  int
+	MaxConcurrentWrites int
+
+	// HTTPClient, if set, is the HTTP client that all requests to S3 are
+	// sent with (e.g., one with a proxy, custom root CAs, or a Timeout),
+	// instead of the config's Client. If neither is set,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// TLSConfig, if set, is the TLS configuration used for connections to
+	// S3 (e.g., to require a minimum TLS version or restrict cipher
+	// suites). It is applied to a copy of the transport of the HTTP
+	// client (see HTTPClient), which must be an *http.Transport (or nil,
+	// meaning http.DefaultTransport). If nil, Go's default TLS settings
+	// are used.
+	TLSConfig *tls.Config
+
+	// InsecureSkipVerify disables verification of the server's TLS
+	// certificate (in addition to TLSConfig, if set). It is only intended
+	// for testing against local S3-compatible gateways (e.g., MinIO) with
+	// self-signed certificates; to trust a private CA, set
+	// TLSConfig.RootCAs instead.
+	InsecureSkipVerify bool
+
+	// ServerSideEncryption, if set, is the server-side encryption algorithm
+	// that S3 uses to encrypt written objects: "AES256" (SSE-S3) or
+	// "aws:kms" (SSE-KMS). If empty, it is "aws:kms" if SSEKMSKeyID is set.
+	ServerSideEncryption string
+
+	// SSEKMSKeyID is the ID (or ARN) of the KMS key used for SSE-KMS. If
+	// empty, the AWS managed key for S3 is used. It is ignored if
+	// ServerSideEncryption is "AES256".
+	SSEKMSKeyID string
+
+	// BucketKeyEnabled makes SSE-KMS encrypted writes use an S3 Bucket Key,
+	// which greatly reduces the number of requests S3 makes to KMS. It is
+	// ignored unless SSE-KMS is used.
+	BucketKeyEnabled bool
+
+	// SSECustomerKey, if set, is the 256-bit key that S3 encrypts written
+	// objects with (SSE-C). S3 does not store the key, so it is sent with
+	// every request that writes, reads, or copies an object's data (which
+	// fails for objects encrypted with a different key, or without one).
+	// It may not be combined with ServerSideEncryption or SSEKMSKeyID.
+	// Presigned URLs do not include it.
+	SSECustomerKey []byte
+
+	// ChecksumAlgorithm, if set, makes writes send a checksum of the data
+	// ("CRC32C" or "SHA256") that S3 verifies before storing it, rejecting
+	// data that was corrupted in transit. Each part of a multipart upload
+	// has its own checksum. Requests signed with Signature Version 4A (see
+	// New) send the checksum as a trailer computed while the data is
+	// streamed; since trailers aren't supported by the older signature
+	// version used otherwise, other requests compute the checksum before
+	// sending the data.
+	ChecksumAlgorithm string
+
+	// StorageClass, if set, is the storage class of written objects (e.g.,
+	// "STANDARD_IA" or "GLACIER"), unless WriteOptions.StorageClass
+	// overrides it. If empty, the bucket's default (usually STANDARD) is
+	// used.
+	StorageClass string
+
+	// ACL, if set, is the canned ACL of written objects (e.g.,
+	// "public-read" or "bucket-owner-full-control"), unless
+	// WriteOptions.ACL overrides it. If empty, objects get the bucket's
+	// default ACL.
+	ACL string
+
+	// DirMarkers makes Mkdir and MkdirAll create an empty marker object for
+	// the directory, so that empty directories persist (S3 itself has no
+	// directories). The marker's key is the directory's path followed by
+	// DirMarkerSuffix.
+	//
+	// Regardless of this setting, Stat and ReadDir recognize the common
+	// marker conventions of other tools: "dir/" (AWS console), "dir/.keep",
+	// and "dir_$folder$" (Hadoop and EMR).
+	DirMarkers bool
+
+	// DirMarkerSuffix is the suffix of directory marker keys (e.g., "/",
+	// "/.keep", or "_$folder$"). If empty, "/" is used.
+	DirMarkerSuffix string
+
+	// PathStyle determines whether the paths that the filesystem returns
+	// (by Stat, Lstat, Glob, and ForEachObject) are absolute ("/x/y") or
+	// relative to the bucket root ("x/y"). The default is RelativePaths.
+	// Names returned by ReadDir are always base names.
+	PathStyle PathStyle
+
+	// KeyPrefix, if set, scopes the filesystem to the keys in the bucket
+	// that begin with it (e.g., "myapp/"), so that one bucket can hold
+	// several filesystems. Paths are relative to the prefix: Create("/foo")
+	// writes the key "myapp/foo", and the prefix is omitted from listings
+	// and the paths the filesystem returns. A trailing slash is added to
+	// the prefix if it lacks one.
+	KeyPrefix string
+
+	// DirModTime determines the ModTime of directories (other than the
+	// root) returned by Stat, Lstat, and ReadDir. The default,
+	// DirModTimeZero, makes no extra requests.
+	DirModTime DirModTimeStrategy
+
+	// ReadOnly makes the filesystem reject every operation that would
+	// modify the bucket with ErrReadOnly, without sending any request to
+	// S3, regardless of the permissions of the credentials. Reads are
+	// unaffected.
+	ReadOnly bool
+
+	// RequesterPays makes every request (and presigned URL) acknowledge
+	// that the requester pays for it, with the X-Amz-Request-Payer header,
+	// as Requester Pays buckets require of all requests except the bucket
+	// owner's.
+	RequesterPays bool
+
+	// DetectContentType makes writes that don't specify a Content-Type
+	// (see WriteOptions) set one, inferred from the extension of the path
+	// (e.g., "text/html; charset=utf-8" for ".html") or, if it is unknown,
+	// by sniffing the first 512 bytes of the data with
+	// http.DetectContentType. Empty objects without a known extension
+	// (e.g., directory markers) get no Content-Type.
+	DetectContentType bool
+
+	// VerifyMD5 makes files returned by Open check, when they are read
+	// sequentially from the start to the end, that the MD5 of the data
+	// matches the object's ETag, failing the last Read with
+	// ErrChecksumMismatch if it does not. (Uploads are always protected by
+	// sending Content-MD5.) Only objects whose ETag is the MD5 of their data
+	// are checked: that excludes objects uploaded with multipart upload and
+	// objects encrypted with SSE-KMS or SSE-C. Files are not checked after
+	// a Seek to another offset.
+	VerifyMD5 bool
+
+	// MaxRetries is the number of times an idempotent request (GET, HEAD,
+	// or DELETE) is retried after a transient failure: a 500, 503, or 429
+	// response, or a network error. Each retry waits for the delay given by
+	// the response's Retry-After header or, if there is none, for an
+	// exponentially increasing, jittered delay starting at about
+	// RetryBaseDelay. If zero, 3 is used; if negative, requests are not
+	// retried.
+	MaxRetries int
+
+	// RetryBaseDelay is the delay before the first retry (see MaxRetries),
+	// which doubles for each subsequent retry. If zero, 100ms is used.
+	RetryBaseDelay time.Duration
+
+	// Timeout, if positive, limits the time that each request (and each
+	// retry of it) may take to receive the response headers. It does not
+	// limit reading the response body, so a large object can be read from
+	// Open for as long as that takes; to bound whole operations, use a
+	// context (e.g., with OpenContext) or a Timeout on the HTTP client. A
+	// request that times out fails with an error that satisfies
+	// errors.Is(err, context.DeadlineExceeded), and is retried (see
+	// MaxRetries) like one that failed with a network error.
+	Timeout time.Duration
+
+	// Credentials, if set, supplies the credentials that requests are
+	// signed with instead of the config's Keys, so that temporary
+	// credentials (e.g., those of an EC2 instance's IAM role; see
+	// EC2RoleCredentials) are used and refreshed before they expire.
+	// DefaultCredentials finds credentials the way the AWS SDKs do.
+	Credentials CredentialsProvider
+
+	// OnRequest, if set, is called after each request to S3 (including
+	// each retry) with the kind of request (see RequestOp), the object key
+	// (or, for listings, the key prefix), the error (if the request failed
+	// or S3 responded with an HTTP error status), and the time until the
+	// response headers were received. It can be used to record metrics or
+	// traces, and must be safe for concurrent use.
+	OnRequest func(op RequestOp, key string, err error, dur time.Duration)
+
+	// OnRetry, if set, is called before each retry of a request (see
+	// MaxRetries) with the kind of request, the object key (as for
+	// OnRequest), the number of the retry (1 for the first), the transient
+	// failure that caused it, and the delay before the request is sent
+	// again. It must be safe for concurrent use.
+	OnRetry func(op RequestOp, key string, attempt int, err error, delay time.Duration)
+
+	// Logf, if set, is called to log warnings (e.g., about insecure
+	// configuration).
+	Logf func(format string, v ...interface{})
+}
+
+// PathStyle is the form of the paths returned by the filesystem. See
+// Options.PathStyle.
+type PathStyle int
+
+const (
+	// RelativePaths are relative to the bucket root, without a leading
+	// slash (e.g., "x/y"). The root itself is ".".
+	RelativePaths PathStyle = iota
+
+	// AbsolutePaths begin with a slash (e.g., "/x/y"). The root itself is
+	// "/".
+	AbsolutePaths
+)
+
+// outPath returns the cleaned path p in the form chosen by
+// Options.PathStyle.
+func (fs *S3FS) outPath(p string) string {
+	p = pathpkg.Clean("/" + p)
+	if fs.opt.PathStyle == AbsolutePaths {
+		return p
+	}
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
+
+// New is like S3, but it accepts additional options and returns the
+// concrete *S3FS. If opt is nil, the zero Options are used.
+//
+// The bucket may also be given as the ARN of an S3 Multi-Region Access
+// Point (e.g., "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"), in
+// which case requests are sent to the access point's global endpoint, which
+// routes them to the nearest replica, and are signed with Signature Version
+// 4A as it requires.
+//
+// It returns an error if the bucket URL is not an absolute http or https
+// URL (or a Multi-Region Access Point ARN), or if opt.VerifyRegion is set
+// and the bucket is not in the configured region.
+func New(bucket *url.URL, config *s3util.Config, opt *Options) (*S3FS, error) {
+	var mrapARN string
+	if bucket.Scheme == "arn" {
+		mrapARN = bucket.String()
+		u, err := parseMRAPARN(mrapARN)
+		if err != nil {
+			return nil, err
+		}
+		bucket = u
+	}
+	if bucket.Host == "" || (bucket.Scheme != "http" && bucket.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 bucket URL %q: must be an absolute http or https URL", bucket)
+	}
+	if config == nil {
+		config = &DefaultS3Config
+	}
+	fs := &S3FS{config: config, mrapARN: mrapARN, closed: make(chan struct{})}
+	if opt != nil {
+		fs.opt = *opt
+	}
+
+	tmp := *bucket
+	fs.bucket = &tmp
+	if fs.opt.DisableSSL {
+		fs.bucket.Scheme = "http"
+	}
+	if fs.bucket.Scheme == "http" {
+		fs.logf("warning: S3 requests to %s are sent over plain HTTP without TLS", fs.bucket.Host)
+	}
+	if fs.opt.UseDualStack {
+		host := dualStackHost(fs.bucket.Host, fs.region())
+		if host == "" || mrapARN != "" {
+			return nil, fmt.Errorf("UseDualStack requires an AWS S3 bucket URL, not %q", bucket)
+		}
+		fs.bucket.Host = host
+	}
+
+	fs.keyPrefix = dirPrefix(fs.opt.KeyPrefix)
+
+	if fs.opt.HTTPClient != nil {
+		config := *fs.config
+		config.Client = fs.opt.HTTPClient
+		fs.config = &config
+	}
+	if tlsConfig := fs.opt.TLSConfig; tlsConfig != nil || fs.opt.InsecureSkipVerify {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		if fs.opt.InsecureSkipVerify {
+			tlsConfig.InsecureSkipVerify = true
+			fs.logf("warning: TLS certificates of %s are not verified", fs.bucket.Host)
+		}
+		if err := fs.setTLSConfig(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if fs.opt.MaxWriteBufferBytes != 0 {
+		n := fs.opt.MaxWriteBufferBytes / fs.partSize()
+		if n < 1 {
+			return nil, fmt.Errorf("MaxWriteBufferBytes (%d) must be at least PartSize (%d)", fs.opt.MaxWriteBufferBytes, fs.partSize())
+		}
+		fs.writeBuffers = make(chan struct{}, n)
+	}
+
+	if alg := fs.opt.ChecksumAlgorithm; alg != "" && checksumHeaders[alg] == "" {
+		return nil, fmt.Errorf("unsupported ChecksumAlgorithm %q", alg)
+	}
+	if key := fs.opt.SSECustomerKey; key != nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("SSECustomerKey must be 32 bytes, not %d", len(key))
+		}
+		if fs.opt.ServerSideEncryption != "" || fs.opt.SSEKMSKeyID != "" {
+			return nil, errors.New("SSECustomerKey may not be combined with ServerSideEncryption or SSEKMSKeyID")
+		}
+	}
+	if err := checkStorageClass(fs.opt.StorageClass); err != nil {
+		return nil, err
+	}
+	if err := checkACL(fs.opt.ACL); err != nil {
+		return nil, err
+	}
+
+	if fs.opt.Credentials != nil {
+		fs.creds = &credentialsCache{provider: fs.opt.Credentials}
+	}
+
+	if fs.opt.MaxConcurrentRequests > 0 {
+		fs.limiter = newAIMDLimiter(fs.opt.MaxConcurrentRequests, fs.opt.OnConcurrencyChange)
+	}
+	if fs.opt.MaxConcurrentReads > 0 {
+		fs.readSem = make(semaphore, fs.opt.MaxConcurrentReads)
+	}
+	if fs.opt.MaxConcurrentWrites > 0 {
+		fs.writeSem = make(semaphore, fs.opt.MaxConcurrentWrites)
+	}
+
+	if fs.opt.VerifyRegion {
+		if err := fs.verifyRegion(); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// setTLSConfig makes the filesystem use a copy of its HTTP client whose
+// transport uses the given TLS configuration.
+func (fs *S3FS) setTLSConfig(tlsConfig *tls.Config) error {
+	var client http.Client
+	if fs.config.Client != nil {
+		client = *fs.config.Client
+	}
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return fmt.Errorf("can't set TLS config on HTTP client transport of type %T (must be *http.Transport)", t)
+	}
+	transport.TLSClientConfig = tlsConfig
+	client.Transport = transport
+
+	config := *fs.config
+	config.Client = &client
+	fs.config = &config
+	return nil
 }
 
 type S3FS struct {
 	bucket *url.URL
 	config *s3util.Config
+	opt    Options
+
+	// writeBuffers is a semaphore with a slot for each part buffer that
+	// writers may allocate (if Options.MaxWriteBufferBytes is set).
+	writeBuffers chan struct{}
+
+	limiter *aimdLimiter // nil if there is no concurrency limit
+
+	// readSem and writeSem limit the number of concurrent read (GET and
+	// HEAD) and write requests, respectively. They are nil if there is no
+	// limit.
+	readSem, writeSem semaphore
+
+	// mrapARN is the ARN of the Multi-Region Access Point that bucket is
+	// the endpoint of, or "" if bucket is not one.
+	mrapARN string
+
+	// keyPrefix is Options.KeyPrefix, without a leading slash but with a
+	// trailing one, or "" if it is not set.
+	keyPrefix string
+
+	creds *credentialsCache // nil if Options.Credentials is not set
+
+	// redirectRegion and redirectHost are the region of the bucket and the
+	// host of its endpoint, if S3 redirected a request there because the
+	// bucket URL is for another region.
+	redirectMu                   sync.Mutex
+	redirectRegion, redirectHost string
+
+	closeOnce sync.Once
+	closed    chan struct{} // closed by Close
+}
+
+// ErrClosed is returned by operations on a filesystem after it has been
+// closed.
+var ErrClosed = errors.New("s3vfs: filesystem is closed")
+
+// ErrReadOnly is returned by operations that would modify a filesystem that
+// was created with Options.ReadOnly or is wrapped by ReadOnly.
+var ErrReadOnly = errors.New("s3vfs: filesystem is read-only")
+
+// ErrNoSuchBucket is the error (or, via errors.Is, the underlying error) of
+// operations on a filesystem whose bucket does not exist. It is distinct from
+// os.ErrNotExist, which means that an object does not exist.
+//
+// S3 does not describe the error of a HEAD request, so operations that only
+// issue HEAD requests (e.g., Transition and GetFile) report a missing bucket
+// as a missing object.
+var ErrNoSuchBucket = errors.New("s3vfs: bucket does not exist")
+
+// Close releases the resources held by the filesystem: it wakes up callers
+// that are blocked waiting for concurrency or write buffer limits (which
+// then fail with ErrClosed) and closes idle connections of the configured
+// HTTP client (unless it is http.DefaultClient, which may be shared). After
+// Close, all operations return ErrClosed.
+func (fs *S3FS) Close() error {
+	fs.closeOnce.Do(func() {
+		close(fs.closed)
+		fs.limiter.close()
+		if c := fs.config.Client; c != nil && c != http.DefaultClient {
+			c.CloseIdleConnections()
+		}
+	})
+	return nil
+}
+
+// isClosed reports whether Close has been called.
+func (fs *S3FS) isClosed() bool {
+	select {
+	case <-fs.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (fs *S3FS) logf(format string, v ...interface{}) {
+	if fs.opt.Logf != nil {
+		fs.opt.Logf(format, v...)
+	}
 }
 
 func (fs *S3FS) String() string {
@@ -53,145 +588,518 @@ func (fs *S3FS) String() string {
 }
 
 func (fs *S3FS) url(path string) string {
-	path = pathpkg.Join(fs.bucket.Path, path)
-	return fs.bucket.ResolveReference(&url.URL{Path: path}).String()
+	p := pathpkg.Join(fs.bucket.Path, fs.keyPrefix, path)
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(p, "/") {
+		p += "/" // keep the trailing slash of directory marker keys
+	}
+	return fs.bucket.ResolveReference(&url.URL{Path: p}).String()
 }
 
-func (fs *S3FS) Open(name string) (vfs.ReadSeekCloser, error) {
-	return fs.OpenRange(name, "")
+// keyURL returns the URL of the object whose key (relative to the bucket
+// root, not to Options.KeyPrefix) is key.
+func (fs *S3FS) keyURL(key string) string {
+	return fs.bucket.ResolveReference(&url.URL{Path: pathpkg.Join(fs.bucket.Path, key)}).String()
 }
 
-type rangeTransport struct {
-	http.RoundTripper
-	rangeVal string
-}
+// do signs req with the filesystem's keys and sends it using the configured
+// HTTP client (or http.DefaultClient if none is set). Requests other than
+// GET and HEAD fail with ErrReadOnly if the filesystem is read-only.
+//
+// Idempotent requests (GET, HEAD, and DELETE) are retried after transient
+// failures as described by Options.MaxRetries: a 500 Internal Error, 503
+// Slow Down, or 429 Too Many Requests (as some S3-compatible gateways
+// respond) response, or a network error. If the filesystem has concurrency
+// limits, do blocks until the request may be sent, and the request counts
+// against the limits until its response body is closed.
+func (fs *S3FS) do(req *http.Request) (*http.Response, error) {
+	if fs.isClosed() {
+		return nil, ErrClosed
+	}
+	read := req.Method == "GET" || req.Method == "HEAD"
+	if fs.opt.ReadOnly && !read {
+		return nil, ErrReadOnly
+	}
+	retryable := read || req.Method == "DELETE"
+	if fs.opt.RequesterPays {
+		req.Header.Set("X-Amz-Request-Payer", "requester")
+	}
+
+	client := fs.config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
 
-func (t rangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req = cloneRequest(req)
-	req.Header.Set("range", t.rangeVal)
+	sem := fs.writeSem
+	if read {
+		sem = fs.readSem
+	}
+	backoff := fs.opt.RetryBaseDelay
+	if backoff <= 0 {
+		backoff = defaultRetryBaseDelay
+	}
+	redirected := false
+	for attempt := 0; ; attempt++ {
+		if err := sem.acquire(req.Context(), fs.closed); err != nil {
+			return nil, err
+		}
+		if err := fs.limiter.acquire(req.Context()); err != nil {
+			sem.release()
+			return nil, err
+		}
 
-	transport := t.RoundTripper
-	if transport == nil {
-		transport = http.DefaultTransport
+		fs.redirect(req)
+		if err := fs.sign(req); err != nil {
+			fs.limiter.release(false)
+			sem.release()
+			return nil, err
+		}
+		start := time.Now()
+		resp, err := fs.send(client, req)
+		if err != nil && req.Context().Err() != nil {
+			// Report cancellation (or an exceeded deadline) as such,
+			// rather than as the resulting network error.
+			err = req.Context().Err()
+		}
+		fs.onRequest(req, resp, err, time.Since(start))
+
+		throttled := err == nil && (resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests)
+		transient := throttled || err == nil && resp.StatusCode == http.StatusInternalServerError ||
+			err != nil && req.Context().Err() == nil
+		if err != nil || throttled || (fs.limiter == nil && sem == nil) {
+			fs.limiter.release(throttled)
+			sem.release()
+		} else {
+			resp.Body = &limitedBody{ReadCloser: resp.Body, release: func() {
+				fs.limiter.release(false)
+				sem.release()
+			}}
+		}
+
+		if err == nil && !redirected && fs.followRegionRedirect(req, resp) {
+			redirected = true
+			resp.Body.Close()
+			continue
+		}
+		if transient && retryable && attempt < fs.maxRetries() {
+			var delay time.Duration
+			var ok bool
+			if resp != nil {
+				resp.Body.Close()
+				delay, ok = retryAfter(resp.Header, time.Now())
+			}
+			if !ok {
+				delay = jitter(backoff)
+			}
+			backoff *= 2
+			fs.onRetry(req, resp, err, attempt+1, delay)
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-fs.closed:
+				return nil, ErrClosed
+			}
+			continue
+		}
+		return resp, err
 	}
+}
 
-	resp, err := transport.RoundTrip(req)
-	return resp, err
+// send sends req with client, failing if the response headers are not
+// received within Options.Timeout.
+func (fs *S3FS) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	if fs.opt.Timeout <= 0 {
+		return client.Do(req)
+	}
+	// The context must outlive the response headers, for the body to be
+	// read, so it is canceled by a timer that is stopped once they arrive.
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(fs.opt.Timeout, cancel)
+	resp, err := client.Do(req.WithContext(ctx))
+	if !timer.Stop() && req.Context().Err() == nil {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("no response within %s: %w", fs.opt.Timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, release: cancel}
+	return resp, nil
 }
 
-// cloneRequest returns a clone of the provided *http.Request. The clone is a
-// shallow copy of the struct and its Header map.
-func cloneRequest(r *http.Request) *http.Request {
-	// shallow copy of the struct
-	r2 := new(http.Request)
-	*r2 = *r
-	// deep copy of the Header
-	r2.Header = make(http.Header)
-	for k, s := range r.Header {
-		r2.Header[k] = s
+// sign signs req with the filesystem's credentials.
+func (fs *S3FS) sign(req *http.Request) error {
+	keys, err := fs.keys(req.Context())
+	if err != nil {
+		return err
+	}
+	if fs.mrapARN != "" {
+		return signV4A(req, keys, time.Now())
 	}
-	return r2
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	fs.config.Sign(req, keys)
+	return nil
+}
+
+// Open opens the file at name for reading. The file's data is read lazily
+// with HTTP Range requests, so a large file can be read in part: reading
+// sequentially streams a single response, and seeking only takes effect with
+// the next Read, which requests the data from the new offset. The file also
+// implements io.ReaderAt, which requests exactly the range read, and has a
+// Size() int64 method that returns the object's size without a request.
+//
+// A name with a trailing slash always refers to a directory (even if a
+// zero-byte "dir/" marker object exists), so opening it fails with
+// syscall.EISDIR.
+//
+// Reading the file fails if the object is replaced while it is open. The
+// file holds a connection (and counts against Options.MaxConcurrentReads)
+// until it is read to the end, seeks elsewhere, or is closed.
+func (fs *S3FS) Open(name string) (vfs.ReadSeekCloser, error) {
+	return fs.OpenContext(context.Background(), name)
+}
+
+// OpenContext is like Open, but the file's requests are canceled when ctx is
+// done.
+func (fs *S3FS) OpenContext(ctx context.Context, name string) (vfs.ReadSeekCloser, error) {
+	return fs.openReader(ctx, name)
 }
 
 func (fs *S3FS) OpenRange(name string, rangeHeader string) (f vfs.ReadSeekCloser, err error) {
-	cfg := fs.config
+	h := make(http.Header)
 	if rangeHeader != "" {
-		tmp := *cfg
-		cfg = &tmp
-		var existingTransport http.RoundTripper
-		if cfg.Client != nil {
-			existingTransport = cfg.Client.Transport
-		}
-		cfg.Client = &http.Client{Transport: rangeTransport{RoundTripper: existingTransport, rangeVal: rangeHeader}}
+		h.Set("Range", rangeHeader)
 	}
-
-	rdr, err := s3util.Open(fs.url(name), cfg)
+	resp, err := fs.get(name, h)
 	if err != nil {
-		return nil, &os.PathError{Op: "open", Path: fs.url(name), Err: err}
+		return nil, err
 	}
 
-	b, err := ioutil.ReadAll(rdr)
+	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if err := resp.Body.Close(); err != nil {
 		return nil, err
 	}
-	defer func() {
-		err2 := rdr.Close()
-		if err == nil {
-			err = err2
-		}
-	}()
 	return nopCloser{bytes.NewReader(b)}, nil
 }
 
+// get issues a GET request for the object at name with the given additional
+// request headers. If the response is successful (200 or 206), the caller
+// must close its body. Otherwise, an *os.PathError is returned, whose Err is
+// os.ErrNotExist if the object does not exist (or ErrNoSuchBucket if the
+// bucket does not exist).
+func (fs *S3FS) get(name string, h http.Header) (*http.Response, error) {
+	return fs.getContext(context.Background(), name, h)
+}
+
+// getContext is like get, but the request is canceled when ctx is done.
+func (fs *S3FS) getContext(ctx context.Context, name string, h http.Header) (*http.Response, error) {
+	return fs.getVersion(ctx, name, "", h)
+}
+
+// getVersion is like getContext, but it gets the given version of the
+// object, or the current version if versionID is empty.
+func (fs *S3FS) getVersion(ctx context.Context, name, versionID string, h http.Header) (*http.Response, error) {
+	if strings.HasSuffix(name, "/") {
+		return nil, &os.PathError{Op: "open", Path: fs.url(name), Err: syscall.EISDIR}
+	}
+	return fs.getURL(ctx, fs.url(name), versionID, h)
+}
+
+// getURL is like getVersion, but the object is given by its URL, objURL.
+func (fs *S3FS) getURL(ctx context.Context, objURL, versionID string, h http.Header) (*http.Response, error) {
+	u := objURL
+	if versionID != "" {
+		u += "?versionId=" + url.QueryEscape(versionID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Read the stored bytes, even of objects with a Content-Encoding, which
+	// the HTTP client would otherwise decode (see GzipFileSystem).
+	req.Header.Set("Accept-Encoding", "identity")
+	fs.setCustomerKeyHeader(req.Header)
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: objURL, Err: err}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, &os.PathError{Op: "open", Path: objURL, Err: errNotModified}
+	case http.StatusNotFound:
+		return nil, &os.PathError{Op: "open", Path: objURL, Err: fs.notFoundError(resp)}
+	default:
+		return nil, &os.PathError{Op: "open", Path: objURL, Err: fs.newS3Error(resp)}
+	}
+}
+
 func (fs *S3FS) OpenFetcher(name string) (vfs.ReadSeekCloser, error) {
 	return rwvfs.OpenFetcher(fs, name)
 }
 
+// ReadDir lists the files and directories in path. Directory marker objects
+// (see Options.DirMarkers) are not listed themselves: a "dir_$folder$" key
+// is listed as the directory "dir", and "dir/" and "dir/.keep" keys are
+// omitted from the listing of "dir".
+//
+// If an object has the same name as a directory (e.g., "reports" and
+// "reports/2024.pdf"), only the object is listed; the directory's contents
+// are listed by ReadDir("reports/").
+//
+// For files, the FileInfo's Sys method returns the ManifestEntry from the
+// listing.
+//
+// S3 lists at most 1000 keys per request, so ReadDir requests pages of the
+// listing until it is complete. To process each entry as its page is
+// received, without holding the whole listing in memory, use WalkPrefix.
 func (fs *S3FS) ReadDir(path string) ([]os.FileInfo, error) {
-	dir, err := s3util.NewFile(fs.url(path), fs.config)
+	return fs.ReadDirContext(context.Background(), path)
+}
+
+// ReadDirContext is like ReadDir, but its requests are canceled when ctx is
+// done.
+func (fs *S3FS) ReadDirContext(ctx context.Context, path string) ([]os.FileInfo, error) {
+	return fs.readDir(ctx, path, "")
+}
+
+// readDir lists the entries of the directory path whose names start with
+// namePrefix, which S3 filters by key prefix.
+func (fs *S3FS) readDir(ctx context.Context, path, namePrefix string) ([]os.FileInfo, error) {
+	if fs.isClosed() {
+		return nil, &os.PathError{Op: "readdir", Path: fs.url(path), Err: ErrClosed}
+	}
+
+	var fis []os.FileInfo
+	files := map[string]bool{}
+	err := fs.walkDir(ctx, path, namePrefix, func(fi *fileInfo) error {
+		if !fi.IsDir() {
+			files[fi.name] = true
+		}
+		fis = append(fis, fi)
+		return nil
+	})
 	if err != nil {
 		return nil, &os.PathError{Op: "readdir", Path: fs.url(path), Err: err}
 	}
 
-	fis, err := dir.Readdir(0)
-	if err != nil {
-		return nil, err
+	// A directory that shares its name with an object in the same directory
+	// is only reachable by its path with a trailing slash, so list the object.
+	deduped := fis[:0]
+	for _, fi := range fis {
+		if !fi.IsDir() || !files[fi.Name()] {
+			deduped = append(deduped, fi)
+		}
 	}
-	for i, fi := range fis {
-		fis[i] = &fileInfo{
-			name:    pathpkg.Base(fi.Name()),
-			size:    fi.Size(),
-			mode:    fi.Mode(),
-			modTime: fi.ModTime(),
-			sys:     fi.Sys(),
+	fis = deduped
+
+	if fs.opt.DirModTime != DirModTimeZero {
+		for _, fi := range fis {
+			if fi.IsDir() {
+				if err := fs.setDirModTime(ctx, path, fi.(*fileInfo)); err != nil {
+					return nil, &os.PathError{Op: "readdir", Path: fs.url(path), Err: err}
+				}
+			}
 		}
 	}
+
+	sort.Sort(byName(fis))
 	return fis, nil
 }
 
+// ErrStopWalk may be returned by the function passed to WalkPrefix to stop
+// the walk early without WalkPrefix returning an error.
+var ErrStopWalk = errors.New("s3vfs: stop walk")
+
+// WalkPrefix calls fn for each file and directory in path, as ReadDir lists
+// them, but as each page of the listing is received instead of after the
+// whole listing has been read, so memory use does not grow with the number
+// of entries (other than the set of directory names seen so far). Entries
+// are not sorted: each page's directories are passed before its files, and
+// directories that share their name with an object are not omitted.
+//
+// If fn returns an error, no more pages are listed. WalkPrefix returns nil
+// if the error is ErrStopWalk, and the error itself otherwise.
+func (fs *S3FS) WalkPrefix(path string, fn func(os.FileInfo) error) error {
+	if fs.isClosed() {
+		return &os.PathError{Op: "walkprefix", Path: fs.url(path), Err: ErrClosed}
+	}
+	var fnErr error
+	ctx := context.Background()
+	err := fs.walkDir(ctx, path, "", func(fi *fileInfo) error {
+		if fi.IsDir() && fs.opt.DirModTime != DirModTimeZero {
+			if err := fs.setDirModTime(ctx, path, fi); err != nil {
+				return err
+			}
+		}
+		fnErr = fn(fi)
+		return fnErr
+	})
+	if err == ErrStopWalk && fnErr == ErrStopWalk {
+		return nil
+	}
+	if err != nil && err != fnErr {
+		return &os.PathError{Op: "walkprefix", Path: fs.url(path), Err: err}
+	}
+	return err
+}
+
+// walkDir lists the directory path one page at a time, calling fn with
+// each entry whose name starts with namePrefix in listing order. Each
+// directory is passed once, however many keys name it. If fn returns an
+// error, walkDir stops and returns it.
+func (fs *S3FS) walkDir(ctx context.Context, path, namePrefix string, fn func(*fileInfo) error) error {
+	prefix := dirPrefix(path)
+	dirs := map[string]bool{}
+	walkDir := func(name string) error {
+		if dirs[name] {
+			return nil
+		}
+		dirs[name] = true
+		return fn(&fileInfo{name: name, mode: os.ModeDir})
+	}
+
+	var marker string
+	for {
+		page, err := fs.listPage(ctx, prefix+namePrefix, "/", marker)
+		if err != nil {
+			return err
+		}
+		for _, p := range page.CommonPrefixes {
+			if err := walkDir(strings.TrimSuffix(p.Prefix[len(prefix):], "/")); err != nil {
+				return err
+			}
+		}
+		for _, o := range page.Contents {
+			name := o.Key[len(prefix):]
+			var err error
+			switch {
+			case name == "" || name == keepMarker:
+				// Marker of the directory being listed.
+			case strings.HasSuffix(name, folderMarkerSuffix):
+				err = walkDir(strings.TrimSuffix(name, folderMarkerSuffix))
+			default:
+				e := newManifestEntry(o)
+				err = fn(&fileInfo{
+					name:    name,
+					size:    e.Size,
+					modTime: e.LastModified,
+					sys:     e,
+				})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		marker = page.nextMarker()
+	}
+}
+
+// setDirModTime sets the modification time of fi, an entry of the directory
+// path, as specified by Options.DirModTime.
+func (fs *S3FS) setDirModTime(ctx context.Context, path string, fi *fileInfo) error {
+	t, err := fs.dirModTime(ctx, dirPrefix(path)+fi.name)
+	if err != nil {
+		return err
+	}
+	fi.modTime = t
+	return nil
+}
+
+// dirPrefix returns the key prefix shared by the objects in the directory
+// path: the cleaned path without a leading slash but with a trailing one, or
+// "" for the root directory.
+func dirPrefix(path string) string {
+	prefix := strings.TrimPrefix(pathpkg.Clean("/"+path), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+type byName []os.FileInfo
+
+func (v byName) Len() int           { return len(v) }
+func (v byName) Less(i, j int) bool { return v[i].Name() < v[j].Name() }
+func (v byName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
 func (fs *S3FS) Lstat(name string) (os.FileInfo, error) {
-	fi, err := fs.lstat(name)
+	return fs.lstatContext(context.Background(), name)
+}
+
+func (fs *S3FS) lstatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	fi, err := fs.lstat(ctx, name)
 	if err != nil {
 		return nil, &os.PathError{Op: "lstat", Path: fs.url(name), Err: err}
 	}
 	return fi, nil
 }
 
-func (fs *S3FS) lstat(name string) (os.FileInfo, error) {
-	name = strings.TrimPrefix(filepath.Clean(name), "/")
+// lstat returns the FileInfo of the file or directory at name. A key may be
+// both an object and the prefix of other objects (e.g., "reports" and
+// "reports/2024.pdf"), so name refers to the object if it exists, and to the
+// directory only if it has a trailing slash or there is no such object.
+func (fs *S3FS) lstat(ctx context.Context, name string) (os.FileInfo, error) {
+	isDir := strings.HasSuffix(name, "/")
+	name = strings.TrimPrefix(pathpkg.Clean("/"+name), "/")
 
-	if name == "." {
+	if name == "" {
 		return &fileInfo{
-			name:    ".",
+			name:    fs.outPath(name),
 			size:    0,
 			mode:    os.ModeDir,
 			modTime: time.Time{},
 		}, nil
 	}
 
-	client := fs.config.Client
-	if client == nil {
-		client = http.DefaultClient
+	if !isDir {
+		resp, err := fs.headContext(ctx, name)
+		if err == nil {
+			resp.Body.Close()
+			t, _ := time.Parse(http.TimeFormat, resp.Header.Get("last-modified"))
+			return &fileInfo{
+				name:    fs.outPath(name),
+				size:    resp.ContentLength,
+				mode:    0, // file
+				modTime: t,
+				sys:     resp.Header,
+			}, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
 	}
 
 	q := make(url.Values)
-	q.Set("prefix", name+"/")
+	q.Set("prefix", fs.keyPrefix+name+"/")
 	q.Set("max-keys", "1")
 	u := fs.bucket.ResolveReference(&url.URL{RawQuery: q.Encode()})
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
-	fs.config.Sign(req, *fs.config.Keys)
-	resp, err := client.Do(req)
+	resp, err := fs.do(req)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, newRespError(resp)
+		return nil, fs.newS3Error(resp)
 	}
 
 	result := struct{ Contents []struct{ Key string } }{}
@@ -205,76 +1113,338 @@ func (fs *S3FS) lstat(name string) (os.FileInfo, error) {
 
 	// If Contents is non-empty, then this is a dir.
 	if len(result.Contents) == 1 {
-		return &fileInfo{
-			name: name,
-			size: 0,
-			mode: os.ModeDir,
-		}, nil
+		return fs.dirInfo(ctx, name)
 	}
 
-	// Otherwise, see if a key exists here.
-	req, err = http.NewRequest("HEAD", fs.url(name), nil)
+	// Otherwise, check for a Hadoop-style directory marker.
+	resp, err = fs.headContext(ctx, name+folderMarkerSuffix)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
-	fs.config.Sign(req, *fs.config.Keys)
-	resp, err = client.Do(req)
+	resp.Body.Close()
+	return fs.dirInfo(ctx, name)
+}
+
+// head issues a HEAD request for the object at name. It returns
+// os.ErrNotExist if the object does not exist.
+func (fs *S3FS) head(name string) (*http.Response, error) {
+	return fs.headContext(context.Background(), name)
+}
+
+// headContext is like head, but the request is canceled when ctx is done.
+func (fs *S3FS) headContext(ctx context.Context, name string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", fs.url(name), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNotFound {
+	fs.setCustomerKeyHeader(req.Header)
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
 		return nil, os.ErrNotExist
-	} else if resp.StatusCode != 200 {
-		return nil, newRespError(resp)
+	default:
+		return nil, fs.newS3Error(resp)
 	}
-	t, _ := time.Parse(http.TimeFormat, resp.Header.Get("last-modified"))
-	return &fileInfo{
-		name:    name,
-		size:    resp.ContentLength,
-		mode:    0, // file
-		modTime: t,
-	}, nil
 }
 
+// Stat returns the FileInfo of the file or directory at name. Its Name is
+// the cleaned path of name, in the form chosen by Options.PathStyle.
+//
+// For files, the FileInfo's Sys method returns the http.Header of the S3
+// HEAD response, which contains the object's system metadata (e.g.,
+// Content-Type and Content-Language), and the FileInfo has an Encryption
+// method that reports the object's encryption state:
+//
+//	if fi, ok := fi.(interface{ Encryption() s3vfs.Encryption }); ok { ... }
+//
+// If an object has the same name as a directory (e.g., "reports" and
+// "reports/2024.pdf"), Stat("reports") returns the object and
+// Stat("reports/") returns the directory.
+//
+// Stat of an existing object takes a single HEAD request. Only if there is
+// no such object does it list the prefix name+"/" (and, if that is empty,
+// check for a "name_$folder$" marker) to determine whether name is a
+// directory.
 func (fs *S3FS) Stat(name string) (os.FileInfo, error) {
 	return fs.Lstat(name)
 }
 
+// StatContext is like Stat, but its requests are canceled when ctx is done.
+func (fs *S3FS) StatContext(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.lstatContext(ctx, name)
+}
+
 // Create opens the file at path for writing, creating the file if it doesn't
 // exist and truncating it otherwise.
+//
+// Data is uploaded as it is written, in parts of Options.PartSize bytes, so
+// only one part is held in memory at a time. The file is only created when
+// the writer is closed. If the upload fails, the parts already uploaded are
+// discarded; a writer that is never closed leaves them in S3 (where they
+// are billed) until a lifecycle rule that aborts incomplete multipart
+// uploads removes them.
+//
+// The writer has an Abort() error method that discards the data written
+// without creating or modifying the file:
+//
+//	if a, ok := w.(interface{ Abort() error }); ok { ... }
 func (fs *S3FS) Create(path string) (io.WriteCloser, error) {
-	wc, err := s3util.Create(fs.url(path), nil, fs.config)
-	if err != nil {
+	return fs.CreateWithOptions(path, nil)
+}
+
+// CreateContext is like Create, but the requests that upload the file
+// (issued by the writer's Write and Close methods) are canceled when ctx is
+// done.
+func (fs *S3FS) CreateContext(ctx context.Context, path string) (io.WriteCloser, error) {
+	if fs.opt.ReadOnly {
+		return nil, &os.PathError{Op: "create", Path: fs.url(path), Err: ErrReadOnly}
+	}
+	w := fs.newWriter(path, make(http.Header))
+	w.ctx = ctx
+	return w, nil
+}
+
+// WriteOptions specifies attributes of an object written with
+// CreateWithOptions. Empty fields are not set on the object.
+type WriteOptions struct {
+	ContentType     string // MIME type (e.g., "text/html")
+	ContentLanguage string // natural language of the content (e.g., "en-US")
+	ContentEncoding string // encoding applied to the data (e.g., "gzip")
+	StorageClass    string // overrides Options.StorageClass
+	ACL             string // canned ACL; overrides Options.ACL
+
+	CacheControl       string // caching directives for HTTP caches and CDNs (e.g., "max-age=3600")
+	ContentDisposition string // presentation of the data (e.g., `attachment; filename="report.pdf"`)
+
+	// Metadata is the user-defined metadata of the object, which S3 stores
+	// as x-amz-meta-* headers (e.g., the key "source" is sent as
+	// x-amz-meta-source). S3 treats keys case-insensitively.
+	// (*S3FS).SetMetadata replaces the metadata of an existing object.
+	Metadata map[string]string
+
+	// Tags are the object's tags (e.g., for cost allocation or lifecycle
+	// rules), which are read with GetTags. S3 allows at most 10 tags.
+	Tags map[string]string
+
+	// Progress, if set, is called after each part of the object (or the
+	// whole object, if it is uploaded with a single PUT) is uploaded. The
+	// total is -1 for writers from CreateWithOptions, whose size is not
+	// known until they are closed.
+	Progress ProgressFunc
+}
+
+// header returns the HTTP request headers that set the attributes in opt on
+// the object.
+func (opt *WriteOptions) header() http.Header {
+	h := make(http.Header)
+	if opt == nil {
+		return h
+	}
+	if opt.ContentType != "" {
+		h.Set("Content-Type", opt.ContentType)
+	}
+	if opt.ContentLanguage != "" {
+		h.Set("Content-Language", opt.ContentLanguage)
+	}
+	if opt.ContentEncoding != "" {
+		h.Set("Content-Encoding", opt.ContentEncoding)
+	}
+	if opt.CacheControl != "" {
+		h.Set("Cache-Control", opt.CacheControl)
+	}
+	if opt.ContentDisposition != "" {
+		h.Set("Content-Disposition", opt.ContentDisposition)
+	}
+	if opt.StorageClass != "" {
+		h.Set("X-Amz-Storage-Class", opt.StorageClass)
+	}
+	if opt.ACL != "" {
+		h.Set("X-Amz-Acl", opt.ACL)
+	}
+	for k, v := range opt.Metadata {
+		h.Set(metadataPrefix+k, v)
+	}
+	if len(opt.Tags) > 0 {
+		h.Set("X-Amz-Tagging", encodeTags(opt.Tags))
+	}
+	return h
+}
+
+// metadataPrefix is the prefix of the headers that carry user-defined object
+// metadata.
+const metadataPrefix = "X-Amz-Meta-"
+
+// check returns an error if opt specifies invalid attributes.
+func (opt *WriteOptions) check() error {
+	if opt == nil {
+		return nil
+	}
+	for k := range opt.Metadata {
+		if k == "" || strings.ContainsAny(k, " \t\r\n:") {
+			return fmt.Errorf("invalid metadata key %q", k)
+		}
+	}
+	if err := checkTags(opt.Tags); err != nil {
+		return err
+	}
+	if err := checkACL(opt.ACL); err != nil {
+		return err
+	}
+	return checkStorageClass(opt.StorageClass)
+}
+
+// CreateWithOptions is like Create, but it sets the object attributes
+// specified in opt. If opt is nil, it is equivalent to Create.
+func (fs *S3FS) CreateWithOptions(path string, opt *WriteOptions) (io.WriteCloser, error) {
+	if fs.opt.ReadOnly {
+		return nil, &os.PathError{Op: "create", Path: fs.url(path), Err: ErrReadOnly}
+	}
+	if err := opt.check(); err != nil {
 		return nil, &os.PathError{Op: "create", Path: fs.url(path), Err: err}
 	}
-	return wc, nil
+	w := fs.newWriter(path, opt.header())
+	if opt != nil {
+		w.progress = opt.Progress
+	}
+	return w, nil
+}
+
+// CreateExcl is like Create, but the object is only created if none exists
+// at path when the writer is closed: the upload is conditional on
+// If-None-Match: *, and Close returns an error satisfying os.IsExist if an
+// object exists (in which case it is left unchanged). Of concurrent
+// exclusive creations of the same path, at most one succeeds, so it can
+// serve as a lock.
+func (fs *S3FS) CreateExcl(path string) (io.WriteCloser, error) {
+	if fs.opt.ReadOnly {
+		return nil, &os.PathError{Op: "create", Path: fs.url(path), Err: ErrReadOnly}
+	}
+	w := fs.newWriter(path, make(http.Header))
+	w.exclusive = true
+	return w, nil
+}
+
+// newWriter returns a writer that creates the object at path with the
+// request headers in h and those implied by the filesystem's options.
+func (fs *S3FS) newWriter(path string, h http.Header) *writer {
+	fs.setEncryptionHeader(h)
+	if h.Get("X-Amz-Storage-Class") == "" && fs.opt.StorageClass != "" {
+		h.Set("X-Amz-Storage-Class", fs.opt.StorageClass)
+	}
+	if h.Get("X-Amz-Acl") == "" && fs.opt.ACL != "" {
+		h.Set("X-Amz-Acl", fs.opt.ACL)
+	}
+	w := &writer{
+		fs:                fs,
+		path:              path,
+		header:            h,
+		checksumAlgorithm: fs.opt.ChecksumAlgorithm,
+		detectContentType: fs.opt.DetectContentType && h.Get("Content-Type") == "",
+		total:             -1,
+	}
+	if fs.opt.UploadConcurrency > 1 {
+		w.uploads = make(chan struct{}, fs.opt.UploadConcurrency)
+	}
+	return w
+}
+
+// Names of directory marker objects. See Options.DirMarkers.
+const (
+	keepMarker         = ".keep"
+	folderMarkerSuffix = "_$folder$"
+)
+
+// isDirMarker reports whether key is the name of a directory marker object
+// in any of the conventions that ListAll recognizes: "dir/", "dir/.keep", or
+// "dir_$folder$".
+func isDirMarker(key string) bool {
+	return strings.HasSuffix(key, "/") || pathpkg.Base(key) == keepMarker || strings.HasSuffix(key, folderMarkerSuffix)
 }
 
+// Mkdir creates a directory marker object for name if Options.DirMarkers is
+// set. Otherwise, it does nothing, since S3 doesn't have directories.
 func (fs *S3FS) Mkdir(name string) error {
-	// S3 doesn't have directories.
-	return nil
+	return fs.MkdirContext(context.Background(), name)
 }
 
-// MkdirAll implements rwvfs.MkdirAllOverrider.
+// MkdirContext is like Mkdir, but the request is canceled when ctx is done.
+func (fs *S3FS) MkdirContext(ctx context.Context, name string) error {
+	if fs.opt.ReadOnly {
+		return &os.PathError{Op: "mkdir", Path: fs.url(name), Err: ErrReadOnly}
+	}
+	if !fs.opt.DirMarkers {
+		return nil
+	}
+	suffix := fs.opt.DirMarkerSuffix
+	if suffix == "" {
+		suffix = "/"
+	}
+	name = strings.TrimSuffix(pathpkg.Clean("/"+name), "/")
+	if name == "" {
+		// The root always exists. (A marker for it would be the key "/",
+		// and a PUT of the bucket URL creates the bucket.)
+		return nil
+	}
+	w := fs.newWriter(name+suffix, make(http.Header))
+	w.ctx = ctx
+	return w.Close()
+}
+
+// MkdirAll implements rwvfs.MkdirAllOverrider. Because S3 doesn't have
+// directories, only name's own directory marker (if any) is created; its
+// parents exist implicitly.
 func (fs *S3FS) MkdirAll(name string) error {
-	// S3 doesn't have directories.
-	return nil
+	return fs.Mkdir(name)
 }
 
-func (fs *S3FS) Remove(name string) (err error) {
-	var rdr io.ReadCloser
-	rdr, err = s3util.Delete(fs.url(name), fs.config)
-	defer func() {
-		if rdr != nil {
-			err2 := rdr.Close()
-			if err == nil {
-				err = err2
-			}
+// Remove deletes the object at name. Unlike os.Remove, it succeeds if the
+// object does not exist, as S3's DELETE does, so that cleanup can be
+// retried safely. (S3-compatible services that report a missing key with a
+// 404 are treated the same way.) It fails only if the object can't be
+// deleted, e.g., because access is denied or the bucket does not exist.
+func (fs *S3FS) Remove(name string) error {
+	return fs.remove(context.Background(), name, "")
+}
+
+// RemoveContext is like Remove, but the request is canceled when ctx is
+// done.
+func (fs *S3FS) RemoveContext(ctx context.Context, name string) error {
+	return fs.remove(ctx, name, "")
+}
+
+// remove deletes the given version of the object at name, or the current
+// version if versionID is empty.
+func (fs *S3FS) remove(ctx context.Context, name, versionID string) error {
+	u := fs.url(name)
+	if versionID != "" {
+		u += "?versionId=" + url.QueryEscape(versionID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: fs.url(name), Err: err}
+	}
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return resp.Body.Close()
+	case http.StatusNotFound:
+		// The object is already gone (unless the bucket is).
+		if err := fs.notFoundError(resp); err != os.ErrNotExist {
+			return &os.PathError{Op: "remove", Path: fs.url(name), Err: err}
 		}
-	}()
-	return err
+		return nil
+	default:
+		return &os.PathError{Op: "remove", Path: fs.url(name), Err: fs.newS3Error(resp)}
+	}
 }
 
 type nopCloser struct {
@@ -298,23 +1468,74 @@ func (f *fileInfo) ModTime() time.Time { return f.modTime }
 func (f *fileInfo) IsDir() bool        { return f.mode&os.ModeDir != 0 }
 func (f *fileInfo) Sys() interface{}   { return f.sys }
 
-type respError struct {
-	r *http.Response
-	b bytes.Buffer
+// S3FileInfo is implemented by the os.FileInfo values that Stat, Lstat, and
+// ReadDir return, to report S3 metadata. For files, Sys returns the raw
+// metadata: the http.Header of the HEAD response for Stat and Lstat, or the
+// ManifestEntry from the listing for ReadDir. ModTime is the object's
+// Last-Modified time.
+type S3FileInfo interface {
+	os.FileInfo
+
+	ETag() string           // without surrounding quotes; "" for directories
+	StorageClass() string   // e.g., "STANDARD"; "" for directories
+	ContentType() string    // "" for directories and ReadDir entries
+	VersionID() string      // see (*S3FS).OpenVersion
+	Encryption() Encryption // see Options.ServerSideEncryption
+
+	// Metadata returns the user-defined metadata (see
+	// WriteOptions.Metadata), with lowercase keys. It is nil for
+	// directories and for entries returned by ReadDir, whose listing does
+	// not include it.
+	Metadata() map[string]string
 }
 
-func newRespError(r *http.Response) *respError {
-	e := new(respError)
-	e.r = r
-	io.Copy(&e.b, r.Body)
-	r.Body.Close()
-	return e
+// ETag returns the ETag of the object, without surrounding quotes.
+func (f *fileInfo) ETag() string {
+	switch sys := f.sys.(type) {
+	case http.Header:
+		return strings.Trim(sys.Get("ETag"), `"`)
+	case ManifestEntry:
+		return sys.ETag
+	}
+	return ""
+}
+
+// ContentType returns the Content-Type of the object, from the HEAD
+// response.
+func (f *fileInfo) ContentType() string {
+	if h, ok := f.sys.(http.Header); ok {
+		return h.Get("Content-Type")
+	}
+	return ""
 }
 
-func (e *respError) Error() string {
-	return fmt.Sprintf(
-		"unwanted http status %d: %q",
-		e.r.StatusCode,
-		e.b.String(),
-	)
+// Metadata returns the user-defined metadata of the object, from the HEAD
+// response.
+func (f *fileInfo) Metadata() map[string]string {
+	h, ok := f.sys.(http.Header)
+	if !ok {
+		return nil
+	}
+	md := map[string]string{}
+	for k, v := range h {
+		if strings.HasPrefix(k, metadataPrefix) && len(v) > 0 {
+			md[strings.ToLower(k[len(metadataPrefix):])] = v[0]
+		}
+	}
+	return md
+}
+
+// StorageClass returns the storage class of the object. HEAD responses omit
+// it for STANDARD objects.
+func (f *fileInfo) StorageClass() string {
+	switch sys := f.sys.(type) {
+	case http.Header:
+		if sc := sys.Get("X-Amz-Storage-Class"); sc != "" {
+			return sc
+		}
+		return "STANDARD"
+	case ManifestEntry:
+		return sys.StorageClass
+	}
+	return ""
 }
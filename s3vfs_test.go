@@ -2,17 +2,26 @@ package s3vfs
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	pathpkg "path"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -40,6 +49,928 @@ func TestS3VFS(t *testing.T) {
 	}
 }
 
+func TestNew_DisableSSL(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+
+	u := f.bucketURL()
+	u.Scheme = "https"
+	var logs []string
+	fs, err := New(u, f.config(), &Options{
+		DisableSSL: true,
+		Logf:       func(format string, v ...interface{}) { logs = append(logs, fmt.Sprintf(format, v...)) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("f"); err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if len(logs) != 1 {
+		t.Errorf("got logs %q, want 1 warning about plain HTTP", logs)
+	}
+	if u.Scheme != "https" {
+		t.Errorf("New modified the bucket URL passed to it")
+	}
+}
+
+func TestNew_invalidURL(t *testing.T) {
+	for _, s := range []string{"", "mybucket", "ftp://example.com/mybucket"} {
+		u, _ := url.Parse(s)
+		if _, err := New(u, nil, nil); err == nil {
+			t.Errorf("New(%q): got nil error", s)
+		}
+	}
+}
+
+func TestCreate_PostUploadVerify(t *testing.T) {
+	f := newFakeS3(t)
+	f.visibilityLag = 2
+
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 5, PostUploadVerify: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	heads := func() (n int) {
+		for _, req := range f.received() {
+			if req.Method == "HEAD" {
+				n++
+			}
+		}
+		return n
+	}
+	createFile(t, fs, "f", []byte("0123456789ab"))
+
+	// The fake store hides the object for 2 reads, so Close must have
+	// issued 3 HEADs before returning.
+	if n := heads(); n != 3 {
+		t.Errorf("got %d HEAD requests, want 3", n)
+	}
+	if rc, err := fs.Open("f"); err != nil {
+		t.Errorf("Open after Close: %s", err)
+	} else {
+		rc.Close()
+	}
+
+	// Objects written with a single PUT are not polled.
+	f.reset()
+	createFile(t, fs, "small", []byte("x"))
+	if n := heads(); n != 0 {
+		t.Errorf("single PUT: got %d HEAD requests, want 0", n)
+	}
+
+	// Give up after the deadline.
+	f.visibilityLag = 1000
+	fs.opt.PostUploadVerify = 100 * time.Millisecond
+	w, err := fs.Create("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("0123456789ab"))
+	if err := w.Close(); err == nil {
+		t.Error("Close: got nil error, want error for object that never became visible")
+	}
+
+	// Canceling the writer's context stops the polling.
+	fs.opt.PostUploadVerify = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	w, err = fs.CreateContext(ctx, "h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("0123456789ab"))
+	start := time.Now()
+	if err := w.Close(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close with canceled context: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Close took %s after the context was canceled", d)
+	}
+}
+
+func TestCreateWithOptions(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+
+	w, err := fs.CreateWithOptions("index.de.html", &WriteOptions{ContentType: "text/html", ContentLanguage: "de-DE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("<p>Hallo</p>")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat("index.de.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := fi.Sys().(http.Header)
+	if !ok {
+		t.Fatalf("got Sys() of type %T, want http.Header", fi.Sys())
+	}
+	if got, want := h.Get("Content-Language"), "de-DE"; got != want {
+		t.Errorf("got Content-Language %q, want %q", got, want)
+	}
+	if got, want := h.Get("Content-Type"), "text/html"; got != want {
+		t.Errorf("got Content-Type %q, want %q", got, want)
+	}
+}
+
+func TestCreateWithOptions_caching(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	const (
+		cc = "public, max-age=3600"
+		cd = `attachment; filename="report.pdf"`
+	)
+	tests := map[string]*WriteOptions{
+		"cc":   {CacheControl: cc},
+		"cd":   {ContentDisposition: cd},
+		"both": {CacheControl: cc, ContentDisposition: cd},
+	}
+	for name, opt := range tests {
+		f.reset()
+		w, err := fs.CreateWithOptions(name, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		put := f.received()[0]
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range []http.Header{put.Header, fi.Sys().(http.Header)} {
+			if got := h.Get("Cache-Control"); got != opt.CacheControl {
+				t.Errorf("%s: got Cache-Control %q, want %q", name, got, opt.CacheControl)
+			}
+			if got := h.Get("Content-Disposition"); got != opt.ContentDisposition {
+				t.Errorf("%s: got Content-Disposition %q, want %q", name, got, opt.ContentDisposition)
+			}
+		}
+	}
+}
+
+func TestS3FileInfo(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	createFile(t, fs, "d/std", []byte("x"))
+	w, err := fs.CreateWithOptions("d/ia", &WriteOptions{StorageClass: "STANDARD_IA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("y"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"std": "STANDARD", "ia": "STANDARD_IA"}
+	check := func(op, name string, fi os.FileInfo) {
+		sfi, ok := fi.(S3FileInfo)
+		if !ok {
+			t.Fatalf("%s: %T does not implement S3FileInfo", op, fi)
+		}
+		o, _ := f.get("d/" + name)
+		if got, want := sfi.ETag(), strings.Trim(o.etag(), `"`); got != want {
+			t.Errorf("%s(%s): got ETag %q, want %q", op, name, got, want)
+		}
+		if got := sfi.StorageClass(); got != want[name] {
+			t.Errorf("%s(%s): got storage class %q, want %q", op, name, got, want[name])
+		}
+		if d := fi.ModTime().Sub(o.modTime); d < -time.Second || d > time.Second {
+			t.Errorf("%s(%s): got mod time %s, want %s", op, name, fi.ModTime(), o.modTime)
+		}
+	}
+	for name := range want {
+		fi, err := fs.Stat("d/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check("Stat", name, fi)
+	}
+	fis, err := fs.ReadDir("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		check("ReadDir", fi.Name(), fi)
+	}
+
+	fi, err := fs.Stat("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sfi := fi.(S3FileInfo); sfi.ETag() != "" || sfi.StorageClass() != "" {
+		t.Errorf("directory: got ETag %q, storage class %q, want empty", sfi.ETag(), sfi.StorageClass())
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	md := map[string]string{"source": "etl", "Checksum": "abc123"}
+	w, err := fs.CreateWithOptions("f", &WriteOptions{ContentType: "text/csv", Metadata: md})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("x"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := f.get("f"); o.header.Get("X-Amz-Meta-Source") != "etl" {
+		t.Errorf("got stored header %v, want x-amz-meta-source", o.header)
+	}
+
+	fi, err := fs.Stat("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fi.(S3FileInfo).Metadata()
+	if want := map[string]string{"source": "etl", "checksum": "abc123"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got metadata %v, want %v", got, want)
+	}
+	if ct := fi.(S3FileInfo).ContentType(); ct != "text/csv" {
+		t.Errorf("got Content-Type %q, want text/csv", ct)
+	}
+
+	// SetMetadata replaces the metadata and preserves the other attributes.
+	if err := fs.SetMetadata("f", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = fs.Stat("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sfi := fi.(S3FileInfo)
+	if got, want := sfi.Metadata(), map[string]string{"owner": "ops"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after SetMetadata: got metadata %v, want %v", got, want)
+	}
+	if sfi.ContentType() != "text/csv" || sfi.Size() != 1 {
+		t.Errorf("after SetMetadata: got Content-Type %q, size %d", sfi.ContentType(), sfi.Size())
+	}
+	if err := fs.SetMetadata("missing", nil); !os.IsNotExist(err) {
+		t.Errorf("missing object: got error %v, want not exist", err)
+	}
+	if err := fs.SetMetadata("f", map[string]string{"bad key": "x"}); err == nil {
+		t.Error("SetMetadata with invalid key: got nil error")
+	}
+
+	if _, err := fs.CreateWithOptions("g", &WriteOptions{Metadata: map[string]string{"bad key": "x"}}); err == nil {
+		t.Error("invalid metadata key: got nil error")
+	}
+}
+
+func TestClose(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+	config := f.config()
+	config.Client = &http.Client{Transport: &http.Transport{}}
+	fs, err := New(f.bucketURL(), config, &Options{PartSize: 10, MaxWriteBufferBytes: 10, MaxConcurrentRequests: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	if _, err := fs.Stat("f"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer blocked waiting for a write buffer is woken up by Close.
+	w1, _ := fs.Create("1")
+	io.WriteString(w1, "a")
+	w2, _ := fs.Create("2")
+	done := make(chan error)
+	go func() {
+		_, err := io.WriteString(w2, "b")
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || err.(*os.PathError).Err != ErrClosed {
+		t.Errorf("blocked Write: got error %v, want ErrClosed", err)
+	}
+
+	if _, err := fs.Stat("f"); err == nil || err.(*os.PathError).Err != ErrClosed {
+		t.Errorf("Stat after Close: got error %v, want ErrClosed", err)
+	}
+	if _, err := fs.Open("f"); err == nil || err.(*os.PathError).Err != ErrClosed {
+		t.Errorf("Open after Close: got error %v, want ErrClosed", err)
+	}
+	if _, err := fs.ReadDir("/"); err == nil || err.(*os.PathError).Err != ErrClosed {
+		t.Errorf("ReadDir after Close: got error %v, want ErrClosed", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Errorf("second Close: %s", err)
+	}
+
+	// The connection goroutines of the client's transport exit once its
+	// idle connections are closed.
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("goroutine leak: %d goroutines before, %d after Close", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNew_TLSConfig(t *testing.T) {
+	f := newUnstartedFakeS3(t)
+	f.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	f.StartTLS()
+	f.put("f", []byte("x"))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(f.Certificate())
+
+	fs, err := New(f.bucketURL(), f.config(), &Options{TLSConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("f"); err != nil {
+		t.Errorf("TLS 1.2: %s", err)
+	}
+
+	fs, err = New(f.bucketURL(), f.config(), &Options{TLSConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS13}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("f"); err == nil {
+		t.Error("TLS 1.3 required but server only supports 1.2: got nil error")
+	}
+
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+	if _, err := New(f.bucketURL(), config, &Options{TLSConfig: &tls.Config{}}); err == nil {
+		t.Error("custom RoundTripper: got nil error")
+	}
+}
+
+func TestNew_InsecureSkipVerify(t *testing.T) {
+	f := newUnstartedFakeS3(t)
+	f.StartTLS()
+	f.put("f", []byte("x"))
+
+	if _, err := f.fs().Stat("f"); err == nil {
+		t.Error("self-signed certificate: got nil error")
+	}
+	var logs []string
+	logf := func(format string, v ...interface{}) { logs = append(logs, fmt.Sprintf(format, v...)) }
+	for _, opt := range []Options{
+		{InsecureSkipVerify: true, Logf: logf},
+		{InsecureSkipVerify: true, Logf: logf, TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}},
+	} {
+		fs, err := New(f.bucketURL(), f.config(), &opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat("f"); err != nil {
+			t.Errorf("%+v: %s", opt, err)
+		}
+		if opt.TLSConfig != nil && opt.TLSConfig.InsecureSkipVerify {
+			t.Error("TLSConfig was modified")
+		}
+	}
+	if len(logs) != 2 {
+		t.Errorf("got logs %q, want a warning for each filesystem", logs)
+	}
+}
+
+func TestNew_HTTPClient(t *testing.T) {
+	f := newFakeS3(t)
+	var mu sync.Mutex
+	var sent int
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		sent++
+		mu.Unlock()
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	config := f.config()
+	config.Client = http.DefaultClient // overridden
+	fs, err := New(f.bucketURL(), config, &Options{HTTPClient: client, PartSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createFile(t, fs, "d/f", []byte("multipart"))
+	if _, err := vfs.ReadFile(fs, "d/f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadDir("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("d/f"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(f.received()); sent != n {
+		t.Errorf("sent %d requests with the client, but the server received %d", sent, n)
+	}
+}
+
+func TestDirMarkers(t *testing.T) {
+	for _, suffix := range []string{"", "/.keep", "_$folder$"} {
+		f := newFakeS3(t)
+		fs, err := New(f.bucketURL(), f.config(), &Options{DirMarkers: true, DirMarkerSuffix: suffix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.MkdirAll("/a/empty"); err != nil {
+			t.Fatal(err)
+		}
+		f.put("a/file", []byte("x"))
+
+		if suffix == "" {
+			suffix = "/"
+		}
+		if _, ok := f.get("a/empty" + suffix); !ok {
+			t.Errorf("%q: marker object not created", suffix)
+		}
+
+		fi, err := fs.Stat("a/empty")
+		if err != nil {
+			t.Errorf("%q: Stat: %s", suffix, err)
+		} else if !fi.IsDir() {
+			t.Errorf("%q: got mode %s, want dir", suffix, fi.Mode())
+		}
+
+		fis, err := fs.ReadDir("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fmt.Sprintf("%s:%v", fi.Name(), fi.IsDir()))
+		}
+		if got, want := fmt.Sprint(names), "[empty:true file:false]"; got != want {
+			t.Errorf("%q: ReadDir(a): got %s, want %s", suffix, got, want)
+		}
+
+		fis, err = fs.ReadDir("a/empty")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) != 0 {
+			t.Errorf("%q: ReadDir(a/empty): got %d entries, want none", suffix, len(fis))
+		}
+	}
+}
+
+func TestDirMarkerKeys(t *testing.T) {
+	f := newFakeS3(t)
+	for _, key := range []string{"d/", "d/f", "d/sub/", "empty/"} {
+		f.put(key, nil)
+	}
+	fs := f.fs()
+
+	for _, name := range []string{"d", "d/", "d/sub", "d/sub/", "empty", "empty/"} {
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Errorf("Stat(%s): %s", name, err)
+		} else if !fi.IsDir() {
+			t.Errorf("Stat(%s): got mode %s, want dir", name, fi.Mode())
+		}
+	}
+
+	for dir, want := range map[string]string{
+		"":      "[d:true empty:true]",
+		"d":     "[f:false sub:true]",
+		"d/sub": "[]",
+		"empty": "[]",
+	} {
+		fis, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, fi := range fis {
+			names = append(names, fmt.Sprintf("%s:%v", fi.Name(), fi.IsDir()))
+		}
+		if got := fmt.Sprint(names); got != want {
+			t.Errorf("ReadDir(%q): got %s, want %s", dir, got, want)
+		}
+	}
+
+	for _, name := range []string{"d/", "empty/"} {
+		if _, err := fs.Open(name); !errors.Is(err, syscall.EISDIR) {
+			t.Errorf("Open(%s): got error %v, want EISDIR", name, err)
+		}
+	}
+	if _, err := fs.Open("empty"); !os.IsNotExist(err) {
+		t.Errorf("Open(empty): got error %v, want os.IsNotExist-satisfying", err)
+	}
+}
+
+func TestMkdir_root(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{DirMarkers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", ".", "/"} {
+		if err := fs.Mkdir(name); err != nil {
+			t.Errorf("Mkdir(%q): %s", name, err)
+		}
+	}
+	if err := rwvfs.MkdirAll(fs, "."); err != nil {
+		t.Fatal(err)
+	}
+	if reqs := f.received(); len(reqs) != 0 {
+		t.Errorf("got %d requests (first %s %s), want none", len(reqs), reqs[0].Method, reqs[0].URL.Path)
+	}
+}
+
+func TestMkdir_noMarkers(t *testing.T) {
+	f := newFakeS3(t)
+	if err := f.fs().MkdirAll("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests, want none", n)
+	}
+}
+
+func TestTrailingSlashCoexistence(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("reports", []byte("summary"))
+	f.put("reports/2024.pdf", []byte("pdf"))
+
+	fi, err := fs.Stat("reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != int64(len("summary")) {
+		t.Errorf("Stat(reports): got mode %s size %d, want the file", fi.Mode(), fi.Size())
+	}
+	fi, err = fs.Stat("reports/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("Stat(reports/): got mode %s, want dir", fi.Mode())
+	}
+	if _, err := fs.Stat("reports/2024.pdf/"); !os.IsNotExist(err) {
+		t.Errorf("Stat(reports/2024.pdf/): got error %v, want not exist", err)
+	}
+
+	for path, want := range map[string]string{
+		"/":        "[reports:false]",
+		"reports":  "[2024.pdf:false]",
+		"reports/": "[2024.pdf:false]",
+	} {
+		fis, err := fs.ReadDir(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fmt.Sprintf("%s:%v", fi.Name(), fi.IsDir()))
+		}
+		if got := fmt.Sprint(names); got != want {
+			t.Errorf("ReadDir(%s): got %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestNoSuchBucket(t *testing.T) {
+	f := newFakeS3(t)
+	u, _ := url.Parse(f.URL + "/missing")
+	fs := S3(u, f.config()).(*S3FS)
+
+	checkErr := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrNoSuchBucket) || os.IsNotExist(err) {
+			t.Errorf("%s: got error %v, want ErrNoSuchBucket", op, err)
+		}
+	}
+	_, err := fs.Open("a")
+	checkErr("Open", err)
+	_, err = fs.Stat("a")
+	checkErr("Stat", err)
+	_, err = fs.ReadDir("/")
+	checkErr("ReadDir", err)
+	w, _ := fs.Create("a")
+	checkErr("Create", w.Close())
+	checkErr("Remove", fs.Remove("a"))
+
+	// A missing object in an existing bucket is not a missing bucket.
+	_, err = f.fs().Open("a")
+	if !os.IsNotExist(err) || errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("Open missing key: got error %v, want not exist", err)
+	}
+}
+
+func TestRemove_idempotent(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	createFile(t, fs, "a", []byte("x"))
+	for i := 0; i < 2; i++ {
+		if err := fs.Remove("a"); err != nil {
+			t.Errorf("Remove #%d: %s", i+1, err)
+		}
+	}
+	if _, ok := f.get("a"); ok {
+		t.Error("a not removed")
+	}
+
+	// Some S3-compatible services report a missing key with a 404.
+	status, code := http.StatusNotFound, "NoSuchKey"
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		fakeError(rec, status, code)
+		return rec.Result(), nil
+	})}
+	fs = S3(f.bucketURL(), config).(*S3FS)
+	if err := fs.Remove("a"); err != nil {
+		t.Errorf("Remove with 404: %s", err)
+	}
+	status, code = http.StatusForbidden, "AccessDenied"
+	if err := fs.Remove("a"); err == nil {
+		t.Error("Remove with 403: got no error")
+	}
+}
+
+func TestContext(t *testing.T) {
+	// The server hangs until the client gives up.
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		ioutil.ReadAll(r.Body) // so that the server notices the client leaving
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/" + fakeBucket)
+	fs := S3(u, newFakeS3(t).config()).(*S3FS)
+
+	ops := map[string]func(ctx context.Context) error{
+		"Open": func(ctx context.Context) error {
+			_, err := fs.OpenContext(ctx, "a")
+			return err
+		},
+		"Stat": func(ctx context.Context) error {
+			_, err := fs.StatContext(ctx, "a")
+			return err
+		},
+		"ReadDir": func(ctx context.Context) error {
+			_, err := fs.ReadDirContext(ctx, "/")
+			return err
+		},
+		"Create": func(ctx context.Context) error {
+			w, err := fs.CreateContext(ctx, "a")
+			if err != nil {
+				return err
+			}
+			w.Write([]byte("x"))
+			return w.Close()
+		},
+		"Remove": func(ctx context.Context) error {
+			return fs.RemoveContext(ctx, "a")
+		},
+	}
+	for name, op := range ops {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := op(ctx)
+		cancel()
+		if _, ok := err.(*os.PathError); !ok || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got error %#v, want *os.PathError with context.DeadlineExceeded", name, err)
+		}
+	}
+
+	// Cancel once the request is in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	if _, err := fs.OpenContext(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("a", []byte("data"))
+	// Responses for "slow" never arrive; the body of "a" arrives slowly.
+	var requests int
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requests++
+		if strings.HasSuffix(r.URL.Path, "/slow") {
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		}
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err == nil {
+			resp.Body = &slowBody{resp.Body, 50 * time.Millisecond}
+		}
+		return resp, err
+	})}
+	fs, err := New(f.bucketURL(), config, &Options{Timeout: 20 * time.Millisecond, MaxRetries: 1, RetryBaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := fs.Stat("slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2 (with 1 retry)", requests)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timed out after %s", d)
+	}
+
+	// The timeout does not apply to reading the body.
+	if data, err := fs.ReadFile("a"); err != nil || string(data) != "data" {
+		t.Errorf("got %q, %v, want %q", data, err, "data")
+	}
+}
+
+// slowBody is a response body that waits for delay before each Read.
+type slowBody struct {
+	io.ReadCloser
+	delay time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	time.Sleep(b.delay)
+	return b.ReadCloser.Read(p)
+}
+
+func TestKeyPrefix(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("outside", []byte("x"))
+	f.put("myapp-other/x", []byte("x"))
+	fs, err := New(f.bucketURL(), f.config(), &Options{KeyPrefix: "myapp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createFile(t, fs, "/foo", []byte("foo"))
+	createFile(t, fs, "/dir/a", []byte("a"))
+	if o, ok := f.get("myapp/foo"); !ok || string(o.data) != "foo" {
+		t.Errorf("got object %v at myapp/foo", o)
+	}
+
+	fis, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if want := "[dir foo]"; fmt.Sprint(names) != want {
+		t.Errorf("ReadDir: got %v, want %v", names, want)
+	}
+	if fi, err := fs.Stat("/dir/a"); err != nil || fi.Name() != "dir/a" {
+		t.Errorf("Stat: got %v, %v", fi, err)
+	}
+	if _, err := fs.Stat("/outside"); !os.IsNotExist(err) {
+		t.Errorf("Stat outside prefix: got error %v, want not exist", err)
+	}
+	if data, err := fs.ReadFile("/dir/a"); err != nil || string(data) != "a" {
+		t.Errorf("ReadFile: got %q, %v", data, err)
+	}
+	if matches, err := fs.Glob("/*/a"); err != nil || fmt.Sprint(matches) != "[dir/a]" {
+		t.Errorf("Glob: got %v, %v", matches, err)
+	}
+
+	if err := fs.Copy("/foo", "/bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.get("myapp/bar"); !ok {
+		t.Error("Copy: myapp/bar not created")
+	}
+	if err := fs.Remove("/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		"myapp/foo":     false,
+		"myapp/dir/a":   false,
+		"myapp/bar":     true,
+		"outside":       true,
+		"myapp-other/x": true,
+	} {
+		if _, ok := f.get(key); ok != want {
+			t.Errorf("%s: got exists %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	f := newFakeS3(t)
+	f.put("f", []byte("x"))
+	fs, err := New(f.bucketURL(), f.config(), &Options{ReadOnly: true, DirMarkers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isReadOnly := func(err error) bool {
+		pe, ok := err.(*os.PathError)
+		return ok && pe.Err == ErrReadOnly
+	}
+	if _, err := fs.Create("g"); !isReadOnly(err) {
+		t.Errorf("Create: got error %v, want ErrReadOnly", err)
+	}
+	if err := fs.Mkdir("d"); !isReadOnly(err) {
+		t.Errorf("Mkdir: got error %v, want ErrReadOnly", err)
+	}
+	if err := fs.MkdirAll("d/e"); !isReadOnly(err) {
+		t.Errorf("MkdirAll: got error %v, want ErrReadOnly", err)
+	}
+	if err := fs.Remove("f"); !isReadOnly(err) {
+		t.Errorf("Remove: got error %v, want ErrReadOnly", err)
+	}
+	if err := fs.Transition("f", "GLACIER"); !isReadOnly(err) {
+		t.Errorf("Transition: got error %v, want ErrReadOnly", err)
+	}
+	n := len(f.received())
+	if err := fs.RemoveAll("f"); !isReadOnly(err) {
+		t.Errorf("RemoveAll: got error %v, want ErrReadOnly", err)
+	}
+	if reqs := f.received(); len(reqs) != n {
+		t.Errorf("RemoveAll: got %d requests, want none", len(reqs)-n)
+	}
+	for _, req := range f.received() {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			t.Errorf("got %s request to read-only filesystem", req.Method)
+		}
+	}
+
+	if _, err := fs.Stat("f"); err != nil {
+		t.Errorf("Stat: %s", err)
+	}
+	rc, err := fs.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+}
+
+func TestRequesterPays(t *testing.T) {
+	for _, requesterPays := range []bool{false, true} {
+		f := newFakeS3(t)
+		fs, err := New(f.bucketURL(), f.config(), &Options{RequesterPays: requesterPays})
+		if err != nil {
+			t.Fatal(err)
+		}
+		createFile(t, fs, "d/f", []byte("x"))
+		if _, err := vfs.ReadFile(fs, "d/f"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.Stat("d"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.ReadDir("d"); err != nil {
+			t.Fatal(err)
+		}
+
+		want := ""
+		if requesterPays {
+			want = "requester"
+		}
+		for _, req := range f.received() {
+			if got := req.Header.Get("X-Amz-Request-Payer"); got != want {
+				t.Errorf("RequesterPays %v: %s %s: got X-Amz-Request-Payer %q, want %q", requesterPays, req.Method, req.URL, got, want)
+			}
+		}
+
+		u, err := fs.PresignGet("d/f", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(u, "x-amz-request-payer=requester"); got != requesterPays {
+			t.Errorf("RequesterPays %v: presigned URL %s", requesterPays, u)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
 func testGlob(t *testing.T, fs rwvfs.FileSystem) {
 	label := fmt.Sprintf("%T", fs)
 
@@ -283,10 +1214,8 @@ func testStat(t *testing.T, fs rwvfs.FileSystem, path string) {
 		t.Fatalf("%s: Stat(%s): got error %v, want os.IsNotExist-satisfying", label, path+"/z", err)
 	}
 
-	// Not sure of the best way to treat S3 keys that are
-	// delimiter-prefixes of other keys, since they can either be like
-	// dirs or files. But let's just choose a way and add a test so we
-	// can change the behavior easily later.
+	// S3 keys that are delimiter-prefixes of other keys are files, and
+	// the dir they are a prefix of is reachable with a trailing slash.
 
 	for _, x := range cases {
 		t.Logf("# parent %q, child %q", x.parent, x.child)
@@ -298,8 +1227,15 @@ func testStat(t *testing.T, fs rwvfs.FileSystem, path string) {
 		if err != nil {
 			t.Fatalf("%s: Stat(%s): %s", label, x.parent, err)
 		}
-		if !parentFI.Mode().IsDir() {
-			t.Fatalf("%s: Stat(%s) got Mode().IsDir() == false, want true", label, x.parent)
+		if !parentFI.Mode().IsRegular() {
+			t.Fatalf("%s: Stat(%s) got Mode().IsRegular() == false, want true", label, x.parent)
+		}
+		parentDirFI, err := fs.Stat(x.parent + "/")
+		if err != nil {
+			t.Fatalf("%s: Stat(%s/): %s", label, x.parent, err)
+		}
+		if !parentDirFI.Mode().IsDir() {
+			t.Fatalf("%s: Stat(%s/) got Mode().IsDir() == false, want true", label, x.parent)
 		}
 
 		childFI, err := fs.Stat(x.child)
@@ -426,3 +1362,33 @@ func removeFile(t *testing.T, fs rwvfs.FileSystem, path string) {
 type walkableFileSystem struct{ rwvfs.FileSystem }
 
 func (_ walkableFileSystem) Join(elem ...string) string { return filepath.Join(elem...) }
+
+func TestStat_requests(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	f.put("d/a", []byte("x"))
+	f.put("e_$folder$", nil)
+
+	for _, tc := range []struct {
+		path    string
+		methods string
+	}{
+		{"d/a", "[HEAD]"},
+		{"d", "[HEAD GET]"},
+		{"d/", "[GET]"},
+		{"e", "[HEAD GET HEAD]"},
+	} {
+		f.reset()
+		if _, err := fs.Stat(tc.path); err != nil {
+			t.Errorf("%s: %s", tc.path, err)
+			continue
+		}
+		var methods []string
+		for _, req := range f.received() {
+			methods = append(methods, req.Method)
+		}
+		if got := fmt.Sprint(methods); got != tc.methods {
+			t.Errorf("%s: got requests %s, want %s", tc.path, got, tc.methods)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package s3vfs
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+)
+
+// setEncryptionHeader sets the request headers that make S3 encrypt a
+// written object as configured in the filesystem's options.
+func (fs *S3FS) setEncryptionHeader(h http.Header) {
+	if fs.opt.SSECustomerKey != nil {
+		fs.setCustomerKeyHeader(h)
+		return
+	}
+	alg := fs.opt.ServerSideEncryption
+	if alg == "" && fs.opt.SSEKMSKeyID != "" {
+		alg = "aws:kms"
+	}
+	switch alg {
+	case "":
+		return
+	case "aws:kms":
+		if fs.opt.SSEKMSKeyID != "" {
+			h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", fs.opt.SSEKMSKeyID)
+		}
+		if fs.opt.BucketKeyEnabled {
+			h.Set("X-Amz-Server-Side-Encryption-Bucket-Key-Enabled", "true")
+		}
+	}
+	h.Set("X-Amz-Server-Side-Encryption", alg)
+}
+
+// setCustomerKeyHeader sets the request headers that give S3 the
+// customer-provided key of the object (see Options.SSECustomerKey), which
+// every request that writes or reads the object's data must send.
+func (fs *S3FS) setCustomerKeyHeader(h http.Header) {
+	setCustomerKey(h, "X-Amz-Server-Side-Encryption-Customer-", fs.opt.SSECustomerKey)
+}
+
+// setCopySourceKeyHeader sets the request headers that give S3 the
+// customer-provided key of the source object of a copy.
+func (fs *S3FS) setCopySourceKeyHeader(h http.Header) {
+	setCustomerKey(h, "X-Amz-Copy-Source-Server-Side-Encryption-Customer-", fs.opt.SSECustomerKey)
+}
+
+// setCustomerKey sets the SSE-C headers with the given prefix for key, if it
+// is non-nil.
+func setCustomerKey(h http.Header, prefix string, key []byte) {
+	if key == nil {
+		return
+	}
+	sum := md5.Sum(key)
+	h.Set(prefix+"Algorithm", "AES256")
+	h.Set(prefix+"Key", base64.StdEncoding.EncodeToString(key))
+	h.Set(prefix+"Key-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// Encryption describes how an object is encrypted at rest.
+type Encryption struct {
+	// Algorithm is the server-side encryption algorithm: "AES256" for
+	// SSE-S3, "aws:kms" for SSE-KMS, or "" if the object is not encrypted
+	// with an S3 or KMS managed key.
+	Algorithm string
+
+	// KMSKeyID is the ARN of the KMS key used for SSE-KMS.
+	KMSKeyID string
+
+	// BucketKeyEnabled reports whether an S3 Bucket Key was used for
+	// SSE-KMS.
+	BucketKeyEnabled bool
+
+	// CustomerAlgorithm and CustomerKeyMD5 are the algorithm and the MD5
+	// digest of the key used for encryption with a customer-provided key
+	// (SSE-C), or "" if SSE-C was not used.
+	CustomerAlgorithm string
+	CustomerKeyMD5    string
+}
+
+// Encrypted reports whether the object is encrypted with any kind of
+// server-side encryption.
+func (e Encryption) Encrypted() bool {
+	return e.Algorithm != "" || e.CustomerAlgorithm != ""
+}
+
+func encryptionFromHeader(h http.Header) Encryption {
+	return Encryption{
+		Algorithm:         h.Get("X-Amz-Server-Side-Encryption"),
+		KMSKeyID:          h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		BucketKeyEnabled:  h.Get("X-Amz-Server-Side-Encryption-Bucket-Key-Enabled") == "true",
+		CustomerAlgorithm: h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"),
+		CustomerKeyMD5:    h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"),
+	}
+}
+
+// Encryption returns the encryption state of the object, as reported by the
+// HEAD request made by Stat or Lstat. It is the zero Encryption for
+// directories and for entries returned by ReadDir, whose listing does not
+// include it.
+func (f *fileInfo) Encryption() Encryption {
+	if h, ok := f.sys.(http.Header); ok {
+		return encryptionFromHeader(h)
+	}
+	return Encryption{}
+}
@@ -0,0 +1,157 @@
+package s3vfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"golang.org/x/tools/godoc/vfs"
+)
+
+func TestServerSideEncryption(t *testing.T) {
+	tests := []struct {
+		opt  Options
+		want map[string]string
+	}{
+		{Options{}, map[string]string{}},
+		{
+			Options{ServerSideEncryption: "AES256", BucketKeyEnabled: true},
+			map[string]string{"X-Amz-Server-Side-Encryption": "AES256"},
+		},
+		{
+			Options{ServerSideEncryption: "aws:kms", SSEKMSKeyID: "k", BucketKeyEnabled: true},
+			map[string]string{
+				"X-Amz-Server-Side-Encryption":                    "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id":     "k",
+				"X-Amz-Server-Side-Encryption-Bucket-Key-Enabled": "true",
+			},
+		},
+		{
+			Options{SSEKMSKeyID: "arn:aws:kms:us-east-1:123456789012:key/k"},
+			map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "arn:aws:kms:us-east-1:123456789012:key/k",
+			},
+		},
+	}
+	for _, test := range tests {
+		opt := test.opt
+		opt.PartSize = 5
+		f := newFakeS3(t)
+		fs, err := New(f.bucketURL(), f.config(), &opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write a single-part and a multipart object.
+		for _, data := range []string{"a", "0123456789"} {
+			w, _ := fs.Create("f")
+			io.WriteString(w, data)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, req := range f.received() {
+			if req.URL.Query().Get("uploadId") != "" {
+				continue // only initiating an upload carries encryption headers
+			}
+			for _, k := range []string{
+				"X-Amz-Server-Side-Encryption",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+				"X-Amz-Server-Side-Encryption-Bucket-Key-Enabled",
+			} {
+				if got := req.Header.Get(k); got != test.want[k] {
+					t.Errorf("%+v: %s %s: got %s %q, want %q", test.opt, req.Method, req.URL, k, got, test.want[k])
+				}
+			}
+		}
+	}
+}
+
+func TestFileInfoEncryption(t *testing.T) {
+	f := newFakeS3(t)
+	for name, opt := range map[string]Options{
+		"none":   {},
+		"sse-s3": {ServerSideEncryption: "AES256"},
+		"sse-kms": {
+			ServerSideEncryption: "aws:kms",
+			SSEKMSKeyID:          "arn:aws:kms:us-east-1:123456789012:key/k",
+			BucketKeyEnabled:     true,
+		},
+	} {
+		opt := opt
+		fs, err := New(f.bucketURL(), f.config(), &opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, _ := fs.Create(name)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := fi.(interface{ Encryption() Encryption }).Encryption()
+		want := Encryption{Algorithm: opt.ServerSideEncryption, KMSKeyID: opt.SSEKMSKeyID, BucketKeyEnabled: opt.BucketKeyEnabled}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+		if got.Encrypted() != (name != "none") {
+			t.Errorf("%s: got Encrypted %v", name, got.Encrypted())
+		}
+	}
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	defer func(max, part int64) { maxCopySize, copyPartSize = max, part }(maxCopySize, copyPartSize)
+	maxCopySize, copyPartSize = 8, 5
+
+	f := newFakeS3(t)
+	key := bytes.Repeat([]byte("k"), 32)
+	for _, opt := range []Options{
+		{SSECustomerKey: key[:16]},
+		{SSECustomerKey: key, ServerSideEncryption: "AES256"},
+	} {
+		if _, err := New(f.bucketURL(), f.config(), &opt); err == nil {
+			t.Errorf("New(%+v): got nil error", opt)
+		}
+	}
+	fs, err := New(f.bucketURL(), f.config(), &Options{SSECustomerKey: key, PartSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{"small": "a", "multipart": "0123456789ab"}
+	for name, data := range files {
+		createFile(t, fs, name, []byte(data))
+		if o, _ := f.get(name); o.header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != "" {
+			t.Errorf("%s: key stored with the object", name)
+		}
+	}
+	// Copy small objects with CopyObject and large ones with multipart copy.
+	for name, data := range files {
+		if err := fs.Copy(name, name+"-copy"); err != nil {
+			t.Fatalf("Copy(%s): %s", name, err)
+		}
+		files[name+"-copy"] = data
+	}
+	for name, data := range files {
+		if got, err := vfs.ReadFile(fs, name); err != nil || string(got) != data {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, data)
+		}
+		fi, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := fi.(interface{ Encryption() Encryption }).Encryption(); e.CustomerAlgorithm != "AES256" {
+			t.Errorf("%s: got encryption %+v, want SSE-C", name, e)
+		}
+	}
+
+	// The data can't be read without the key.
+	if _, err := vfs.ReadFile(f.fs(), "small"); err == nil {
+		t.Error("read without the key: got nil error")
+	}
+}
@@ -0,0 +1,28 @@
+package s3vfs
+
+import "fmt"
+
+// storageClasses are the S3 storage classes that objects can be written
+// with.
+var storageClasses = map[string]bool{
+	"STANDARD":            true,
+	"REDUCED_REDUNDANCY":  true,
+	"STANDARD_IA":         true,
+	"ONEZONE_IA":          true,
+	"INTELLIGENT_TIERING": true,
+	"GLACIER":             true,
+	"GLACIER_IR":          true,
+	"DEEP_ARCHIVE":        true,
+	"OUTPOSTS":            true,
+	"SNOW":                true,
+	"EXPRESS_ONEZONE":     true,
+}
+
+// checkStorageClass returns an error if class is neither empty nor a known
+// S3 storage class.
+func checkStorageClass(class string) error {
+	if class != "" && !storageClasses[class] {
+		return fmt.Errorf("unknown S3 storage class %q", class)
+	}
+	return nil
+}
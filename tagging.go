@@ -0,0 +1,79 @@
+package s3vfs
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// maxObjectTags is the most tags that S3 allows an object to have.
+const maxObjectTags = 10
+
+// checkTags returns an error if S3 would reject tags.
+func checkTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("%d tags (at most %d are allowed)", len(tags), maxObjectTags)
+	}
+	for k := range tags {
+		if k == "" {
+			return errors.New("empty tag key")
+		}
+	}
+	return nil
+}
+
+// encodeTags returns the value of the X-Amz-Tagging header that sets tags:
+// the tags as URL query parameters.
+func encodeTags(tags map[string]string) string {
+	q := make(url.Values, len(tags))
+	for k, v := range tags {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+// GetTags returns the tags of the object at path (see WriteOptions.Tags).
+// If the object has no tags, GetTags returns an empty map.
+//
+// If the object does not exist, the error satisfies os.IsNotExist.
+func (fs *S3FS) GetTags(path string) (map[string]string, error) {
+	tags, err := fs.getTags(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "gettags", Path: fs.url(path), Err: err}
+	}
+	return tags, nil
+}
+
+func (fs *S3FS) getTags(path string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", fs.url(path)+"?tagging", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fs.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fs.notFoundError(resp)
+	default:
+		return nil, fs.newS3Error(resp)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Tags []struct{ Key, Value string } `xml:"TagSet>Tag"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(result.Tags))
+	for _, t := range result.Tags {
+		tags[t.Key] = t.Value
+	}
+	return tags, nil
+}
@@ -0,0 +1,58 @@
+package s3vfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{"team": "search", "cost center": "a&b=c"}
+	for name, data := range map[string][]byte{
+		"small": []byte("x"),
+		"big":   bytes.Repeat([]byte("x"), 25), // multipart
+	} {
+		w, err := fs.CreateWithOptions(name, &WriteOptions{Tags: tags})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := fs.GetTags(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tags) {
+			t.Errorf("%s: got tags %v, want %v", name, got, tags)
+		}
+	}
+
+	createFile(t, fs, "untagged", nil)
+	if got, err := fs.GetTags("untagged"); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("untagged: got %v, %v, want empty map", got, err)
+	}
+	if _, err := fs.GetTags("missing"); !os.IsNotExist(err) {
+		t.Errorf("missing: got error %v, want not exist", err)
+	}
+
+	f.reset()
+	tooMany := map[string]string{}
+	for i := 0; i <= maxObjectTags; i++ {
+		tooMany[fmt.Sprint(i)] = ""
+	}
+	if _, err := fs.CreateWithOptions("bad", &WriteOptions{Tags: tooMany}); err == nil {
+		t.Error("got no error for too many tags")
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests for invalid tags, want none", n)
+	}
+}
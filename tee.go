@@ -0,0 +1,271 @@
+package s3vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// TeePolicy determines which failures cause a TeeFileSystem write operation
+// to fail.
+type TeePolicy int
+
+const (
+	// FailIfAny fails an operation if it fails on the primary or on any
+	// secondary filesystem.
+	FailIfAny TeePolicy = iota
+
+	// FailIfPrimary fails an operation only if it fails on the primary
+	// filesystem. Failures on secondaries are reported to OnSecondaryError.
+	FailIfPrimary
+)
+
+// TeeFileSystem is a filesystem that duplicates all writes (Create, Remove,
+// Mkdir, and Rename) to one or more secondary filesystems. Reads are served
+// only by the primary filesystem. It is useful for dual-writing to an old
+// and a new bucket during a migration.
+type TeeFileSystem struct {
+	rwvfs.FileSystem // the primary filesystem
+
+	Secondaries []rwvfs.FileSystem
+	Policy      TeePolicy
+
+	// OnSecondaryError, if set, is called with each error from a secondary
+	// filesystem that does not fail the operation (under FailIfPrimary).
+	OnSecondaryError func(fs rwvfs.FileSystem, err error)
+}
+
+// Tee returns a filesystem that reads from primary and writes to primary
+// and all secondaries, using the FailIfAny policy.
+func Tee(primary rwvfs.FileSystem, secondaries ...rwvfs.FileSystem) *TeeFileSystem {
+	return &TeeFileSystem{FileSystem: primary, Secondaries: secondaries}
+}
+
+func (fs *TeeFileSystem) String() string {
+	names := make([]string, len(fs.Secondaries))
+	for i, s := range fs.Secondaries {
+		names[i] = s.String()
+	}
+	return fmt.Sprintf("tee(%s -> %s)", fs.FileSystem, strings.Join(names, ", "))
+}
+
+// all returns the primary followed by the secondaries.
+func (fs *TeeFileSystem) all() []rwvfs.FileSystem {
+	return append([]rwvfs.FileSystem{fs.FileSystem}, fs.Secondaries...)
+}
+
+// each calls f concurrently on every filesystem (with its index in
+// fs.all()) and returns the combined error according to fs.Policy.
+func (fs *TeeFileSystem) each(f func(i int, dst rwvfs.FileSystem) error) error {
+	all := fs.all()
+	errs := make([]error, len(all))
+	var wg sync.WaitGroup
+	for i, dst := range all {
+		wg.Add(1)
+		go func(i int, dst rwvfs.FileSystem) {
+			defer wg.Done()
+			errs[i] = f(i, dst)
+		}(i, dst)
+	}
+	wg.Wait()
+	return fs.combine(all, errs)
+}
+
+// combine returns the error (if any) to report for an operation whose
+// per-filesystem errors are errs, where errs[i] is the error from all[i].
+func (fs *TeeFileSystem) combine(all []rwvfs.FileSystem, errs []error) error {
+	var failed errorList
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if i > 0 && fs.Policy == FailIfPrimary {
+			if fs.OnSecondaryError != nil {
+				fs.OnSecondaryError(all[i], err)
+			}
+			continue
+		}
+		failed = append(failed, err)
+	}
+	return failed.err()
+}
+
+func (fs *TeeFileSystem) Create(path string) (io.WriteCloser, error) {
+	all := fs.all()
+	ws := make([]io.WriteCloser, len(all))
+	err := fs.each(func(i int, dst rwvfs.FileSystem) error {
+		var err error
+		ws[i], err = dst.Create(path)
+		return err
+	})
+	if err != nil {
+		for _, w := range ws {
+			if w != nil {
+				discard(w)
+			}
+		}
+		return nil, err
+	}
+
+	// Under FailIfPrimary, secondaries that failed to Create are skipped.
+	tw := &teeWriter{fs: fs, all: all, ws: ws, errs: make([]error, len(ws))}
+	for i, w := range ws {
+		if w == nil {
+			tw.errs[i] = errTeeSkipped
+		}
+	}
+	return tw, nil
+}
+
+func (fs *TeeFileSystem) Mkdir(name string) error {
+	return fs.each(func(_ int, dst rwvfs.FileSystem) error { return dst.Mkdir(name) })
+}
+
+// MkdirAll implements rwvfs.MkdirAllOverrider.
+func (fs *TeeFileSystem) MkdirAll(name string) error {
+	return fs.each(func(_ int, dst rwvfs.FileSystem) error { return rwvfs.MkdirAll(dst, name) })
+}
+
+func (fs *TeeFileSystem) Remove(name string) error {
+	return fs.each(func(_ int, dst rwvfs.FileSystem) error { return dst.Remove(name) })
+}
+
+// Rename renames oldpath to newpath on all filesystems. Every filesystem
+// must implement a Rename(oldpath, newpath string) error method.
+func (fs *TeeFileSystem) Rename(oldpath, newpath string) error {
+	return fs.each(func(_ int, dst rwvfs.FileSystem) error {
+		r, ok := dst.(interface {
+			Rename(oldpath, newpath string) error
+		})
+		if !ok {
+			return fmt.Errorf("rename %s: %s does not support Rename", oldpath, dst)
+		}
+		return r.Rename(oldpath, newpath)
+	})
+}
+
+// teeWriter writes to the writers of all of a TeeFileSystem's filesystems.
+type teeWriter struct {
+	fs   *TeeFileSystem
+	all  []rwvfs.FileSystem
+	ws   []io.WriteCloser
+	errs []error // errs[i] is the first error from ws[i]
+}
+
+func (w *teeWriter) Write(p []byte) (int, error) {
+	var wg sync.WaitGroup
+	for i, dw := range w.ws {
+		if w.errs[i] != nil {
+			continue // stop writing to failed destinations
+		}
+		wg.Add(1)
+		go func(i int, dw io.Writer) {
+			defer wg.Done()
+			if _, err := dw.Write(p); err != nil {
+				w.errs[i] = err
+			}
+		}(i, dw)
+	}
+	wg.Wait()
+	if err := w.failure(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// failure returns the error that fails the write according to the tee
+// policy, if any.
+func (w *teeWriter) failure() error {
+	if w.errs[0] != nil {
+		return w.errs[0]
+	}
+	if w.fs.Policy == FailIfAny {
+		for _, err := range w.errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the writers of the filesystems whose writes succeeded, which
+// commits their files. Writers whose writes failed are discarded instead.
+// Under FailIfAny, if a write failed on any filesystem, all the writers are
+// discarded, so that no filesystem (in particular, not the primary) is left
+// with a partially written file.
+func (w *teeWriter) Close() error {
+	abortAll := w.fs.Policy == FailIfAny && w.failure() != nil
+	errs := make([]error, len(w.ws))
+	var wg sync.WaitGroup
+	for i, dw := range w.ws {
+		if dw == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, dw io.WriteCloser) {
+			defer wg.Done()
+			if abortAll || w.errs[i] != nil {
+				discard(dw)
+			} else {
+				errs[i] = dw.Close()
+			}
+		}(i, dw)
+	}
+	wg.Wait()
+	for i, err := range w.errs {
+		if err == errTeeSkipped {
+			errs[i] = nil // already reported when Create failed
+		} else if err != nil {
+			errs[i] = err // prefer the original write error
+		}
+	}
+	return w.fs.combine(w.all, errs)
+}
+
+// discard discards the data written to w without committing it, if w has an
+// Abort() error method (as the writers of S3FS do). Otherwise, it closes w,
+// which is the only way to release it.
+func discard(w io.WriteCloser) {
+	if a, ok := w.(interface{ Abort() error }); ok {
+		a.Abort()
+		return
+	}
+	w.Close()
+}
+
+// errorList is a list of errors that occurred during an operation that
+// continued after the first failure.
+type errorList []error
+
+// err returns nil if the list is empty, the sole error if it contains one,
+// and the list itself otherwise.
+func (l errorList) err() error {
+	switch len(l) {
+	case 0:
+		return nil
+	case 1:
+		return l[0]
+	}
+	return l
+}
+
+// Unwrap returns the errors in the list, so that errors.Is and errors.As
+// match any of them.
+func (l errorList) Unwrap() []error { return l }
+
+func (l errorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(l), strings.Join(msgs, "; "))
+}
+
+// errTeeSkipped marks a secondary whose Create failed (under FailIfPrimary)
+// and that therefore receives no writes.
+var errTeeSkipped = errors.New("skipped")
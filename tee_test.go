@@ -0,0 +1,169 @@
+package s3vfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/rwvfs"
+)
+
+// failingFS is a filesystem whose writes always fail.
+type failingFS struct{ rwvfs.FileSystem }
+
+var errFailingFS = errors.New("failingFS")
+
+func (failingFS) Create(path string) (io.WriteCloser, error) { return nil, errFailingFS }
+func (failingFS) Remove(path string) error                   { return errFailingFS }
+
+// failingWriteFS is a filesystem whose files can be created, but whose
+// writes always fail.
+type failingWriteFS struct{ rwvfs.FileSystem }
+
+type failingWriter struct{}
+
+func (failingWriteFS) Create(path string) (io.WriteCloser, error) { return failingWriter{}, nil }
+func (failingWriter) Write(p []byte) (int, error)                 { return 0, errFailingFS }
+func (failingWriter) Close() error                                { return nil }
+
+func TestTee(t *testing.T) {
+	primary, secondary := map[string]string{}, map[string]string{}
+	fs := Tee(rwvfs.Map(primary), rwvfs.Map(secondary))
+
+	w, err := fs.Create("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"hello, ", "world"} {
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []map[string]string{primary, secondary} {
+		if got, want := m["a/b"], "hello, world"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	// Reads come from the primary.
+	secondary["a/b"] = "stale"
+	f, err := fs.Open("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(b) != "hello, world" {
+		t.Errorf("Open: got %q, want primary's contents", b)
+	}
+
+	if err := fs.Remove("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary) != 0 || len(secondary) != 0 {
+		t.Errorf("Remove: got primary %v and secondary %v, want both empty", primary, secondary)
+	}
+}
+
+func TestTee_policy(t *testing.T) {
+	bad := failingFS{rwvfs.Map(map[string]string{})}
+
+	fs := Tee(rwvfs.Map(map[string]string{}), bad)
+	if _, err := fs.Create("f"); err != errFailingFS {
+		t.Errorf("FailIfAny: Create: got error %v, want %v", err, errFailingFS)
+	}
+	if err := fs.Remove("f"); err != errFailingFS {
+		t.Errorf("FailIfAny: Remove: got error %v, want %v", err, errFailingFS)
+	}
+
+	primary := map[string]string{}
+	var secondaryErrs []error
+	fs = Tee(rwvfs.Map(primary), bad)
+	fs.Policy = FailIfPrimary
+	fs.OnSecondaryError = func(_ rwvfs.FileSystem, err error) { secondaryErrs = append(secondaryErrs, err) }
+	w, err := fs.Create("f")
+	if err != nil {
+		t.Fatalf("FailIfPrimary: Create: %s", err)
+	}
+	if _, err := io.WriteString(w, "x"); err != nil {
+		t.Fatalf("FailIfPrimary: Write: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("FailIfPrimary: Close: %s", err)
+	}
+	if primary["f"] != "x" {
+		t.Errorf("FailIfPrimary: primary not written")
+	}
+	if len(secondaryErrs) != 1 {
+		t.Errorf("FailIfPrimary: got secondary errors %v, want 1", secondaryErrs)
+	}
+
+	fs = Tee(bad, rwvfs.Map(map[string]string{}))
+	fs.Policy = FailIfPrimary
+	if err := fs.Remove("f"); err != errFailingFS {
+		t.Errorf("FailIfPrimary: Remove with failing primary: got error %v, want %v", err, errFailingFS)
+	}
+}
+
+func TestTee_discard(t *testing.T) {
+	f := newFakeS3(t)
+	primary := f.fs()
+	f.put("f", []byte("precious"))
+	unchanged := func(when string) {
+		t.Helper()
+		if o, _ := f.get("f"); string(o.data) != "precious" {
+			t.Errorf("%s: got primary data %q, want it unchanged", when, o.data)
+		}
+	}
+
+	// The primary's writer is discarded when a secondary's Create fails.
+	fs := Tee(primary, failingFS{rwvfs.Map(map[string]string{})})
+	if _, err := fs.Create("f"); err != errFailingFS {
+		t.Errorf("Create: got error %v, want %v", err, errFailingFS)
+	}
+	unchanged("after failed Create")
+
+	// Under FailIfAny, a failed write on a secondary discards the primary's
+	// writer at Close.
+	fs = Tee(primary, failingWriteFS{rwvfs.Map(map[string]string{})})
+	w, err := fs.Create("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "partial"); err != errFailingFS {
+		t.Errorf("Write: got error %v, want %v", err, errFailingFS)
+	}
+	if err := w.Close(); err != errFailingFS {
+		t.Errorf("Close: got error %v, want %v", err, errFailingFS)
+	}
+	unchanged("after failed Write")
+
+	// Under FailIfPrimary, the primary is written anyway.
+	fs.Policy = FailIfPrimary
+	w, err = fs.Create("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "new")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := f.get("f"); string(o.data) != "new" {
+		t.Errorf("FailIfPrimary: got primary data %q, want %q", o.data, "new")
+	}
+}
+
+func TestErrorList_unwrap(t *testing.T) {
+	err := errorList{errors.New("other"), &os.PathError{Op: "removeall", Path: "p", Err: ErrReadOnly}}.err()
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("errors.Is(%v, ErrReadOnly) = false, want true", err)
+	}
+	if errors.Is(err, ErrNoSuchBucket) {
+		t.Errorf("errors.Is(%v, ErrNoSuchBucket) = true, want false", err)
+	}
+}
@@ -0,0 +1,78 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// VersionFile is a specific version of an object, opened for reading by
+// OpenVersion.
+type VersionFile struct {
+	*bytes.Reader
+	fi        *fileInfo
+	versionID string
+}
+
+// Close implements io.Closer. It does nothing, since the version's contents
+// were already read.
+func (f *VersionFile) Close() error { return nil }
+
+// Stat returns the FileInfo of the version (not of the object's current
+// version). Its Sys method returns the http.Header of the GET response,
+// which contains the version's system and user metadata.
+func (f *VersionFile) Stat() (os.FileInfo, error) { return f.fi, nil }
+
+// VersionID returns the ID of the version.
+func (f *VersionFile) VersionID() string { return f.versionID }
+
+// VersionID returns the ID of the current version of the object, as reported
+// by the HEAD request made by Stat or Lstat, in a bucket with versioning
+// enabled. It is "" for directories, for entries returned by ReadDir, and for
+// objects in unversioned buckets.
+func (f *fileInfo) VersionID() string {
+	if h, ok := f.sys.(http.Header); ok {
+		return h.Get("X-Amz-Version-Id")
+	}
+	return ""
+}
+
+// OpenVersion opens the version of the object at path with the given ID, in
+// a bucket with versioning enabled. The returned VersionFile's Stat method
+// reports the size, modification time, and metadata of that version.
+//
+// If the object or version does not exist, the error satisfies
+// os.IsNotExist.
+func (fs *S3FS) OpenVersion(path, versionID string) (*VersionFile, error) {
+	resp, err := fs.getVersion(context.Background(), path, versionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: fs.url(path), Err: err}
+	}
+
+	t, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	return &VersionFile{
+		Reader: bytes.NewReader(b),
+		fi: &fileInfo{
+			name:    fs.outPath(path),
+			size:    int64(len(b)),
+			modTime: t,
+			sys:     resp.Header,
+		},
+		versionID: versionID,
+	}, nil
+}
+
+// RemoveVersion permanently deletes the version of the object at path with
+// the given ID. If it is the current version, the previous version (if any)
+// becomes current. Unlike Remove, it does not create a delete marker.
+func (fs *S3FS) RemoveVersion(path, versionID string) error {
+	return fs.remove(context.Background(), path, versionID)
+}
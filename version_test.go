@@ -0,0 +1,65 @@
+package s3vfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestOpenVersion(t *testing.T) {
+	f := newFakeS3(t)
+	fs := f.fs()
+	var versions []string
+	for _, data := range []string{"first", "second version"} {
+		w, _ := fs.CreateWithOptions("f", &WriteOptions{ContentLanguage: data})
+		w.Write([]byte(data))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		o, _ := f.get("f")
+		versions = append(versions, o.versionID)
+	}
+
+	vf, err := fs.OpenVersion("f", versions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vf.Close()
+	b, _ := ioutil.ReadAll(vf)
+	if string(b) != "first" {
+		t.Errorf("got contents %q, want %q", b, "first")
+	}
+	fi, _ := vf.Stat()
+	if fi.Size() != 5 || fi.ModTime().IsZero() || fi.Name() != "f" {
+		t.Errorf("got FileInfo name %q, size %d, mod time %s", fi.Name(), fi.Size(), fi.ModTime())
+	}
+	if got := fi.Sys().(http.Header).Get("Content-Language"); got != "first" {
+		t.Errorf("got Content-Language %q of the latest version", got)
+	}
+	if vf.VersionID() != versions[0] {
+		t.Errorf("got version ID %q, want %q", vf.VersionID(), versions[0])
+	}
+
+	if _, err := fs.OpenVersion("f", "nonexistent"); !os.IsNotExist(err) {
+		t.Errorf("nonexistent version: got error %v, want not exist", err)
+	}
+
+	// Removing the current version makes the previous one current.
+	if err := fs.RemoveVersion("f", versions[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.OpenVersion("f", versions[1]); !os.IsNotExist(err) {
+		t.Errorf("removed version: got error %v, want not exist", err)
+	}
+	fi, err = fs.Stat("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 5 {
+		t.Errorf("after removing the current version: got size %d, want 5", fi.Size())
+	}
+	if got := fi.(interface{ VersionID() string }).VersionID(); got != versions[0] {
+		t.Errorf("Stat: got version ID %q, want %q", got, versions[0])
+	}
+}
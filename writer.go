@@ -0,0 +1,703 @@
+package s3vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"sync"
+	"time"
+)
+
+// DefaultPartSize is the multipart upload part size used if
+// Options.PartSize is not set. It is the minimum part size that AWS S3
+// allows.
+const DefaultPartSize = 5 * 1024 * 1024
+
+func (fs *S3FS) partSize() int64 {
+	if fs.opt.PartSize > 0 {
+		return fs.opt.PartSize
+	}
+	return DefaultPartSize
+}
+
+// DefaultMaxObjectSize is the size of the largest object that AWS S3
+// allows, used if Options.MaxObjectSize is not set.
+const DefaultMaxObjectSize = 5 * 1024 * 1024 * 1024 * 1024
+
+// maxUploadParts is the most parts that a multipart upload may have.
+var maxUploadParts int64 = 10000
+
+// ErrObjectTooLarge is the error of a write that would make an object
+// larger than the filesystem allows (see Options.MaxObjectSize).
+var ErrObjectTooLarge = errors.New("s3vfs: object too large")
+
+// maxObjectSize returns the size of the largest object that a writer may
+// create.
+func (fs *S3FS) maxObjectSize() int64 {
+	max := fs.opt.MaxObjectSize
+	if max <= 0 {
+		max = DefaultMaxObjectSize
+	}
+	if parts := fs.partSize() * maxUploadParts; parts < max {
+		return parts
+	}
+	return max
+}
+
+// acquireWriteBuffer blocks until the filesystem's write buffer budget
+// allows another part buffer to be allocated, or until ctx is done or the
+// filesystem is closed.
+func (fs *S3FS) acquireWriteBuffer(ctx context.Context) error {
+	if fs.writeBuffers == nil {
+		return nil
+	}
+	select {
+	case fs.writeBuffers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-fs.closed:
+		return ErrClosed
+	}
+}
+
+func (fs *S3FS) releaseWriteBuffer() {
+	if fs.writeBuffers != nil {
+		<-fs.writeBuffers
+	}
+}
+
+// writer uploads an object to S3. Data is buffered one part at a time. If
+// the object fits in a single part, it is uploaded with a single PUT when the
+// writer is closed; otherwise each full part is uploaded as it is filled,
+// using multipart upload, which is completed when the writer is closed (or
+// aborted if the upload fails, so that no parts are left behind).
+//
+// With Options.UploadConcurrency, full parts buffered in memory are
+// uploaded in the background while the next part is written; a failed
+// background upload fails the next Write or Close.
+type writer struct {
+	fs     *S3FS
+	path   string
+	header http.Header     // sent when creating the object
+	ctx    context.Context // of the upload's requests; nil means context.Background()
+
+	checksumAlgorithm string // see Options.ChecksumAlgorithm; "" for none
+	detectContentType bool   // set Content-Type from the data before sending it
+	exclusive         bool   // fail with os.ErrExist if the object exists
+
+	buf       []byte   // current part; nil if no write buffer is held
+	spill     *os.File // current part, if spilled to disk (see Options.SpillThreshold)
+	spillSize int64    // bytes of the current part in spill
+	size      int64    // bytes written
+	uploadID  string   // multipart upload ID, or "" if not yet initiated
+	err       error    // sticky error; set after the upload has failed
+	closed    bool
+
+	uploads chan struct{}  // limits background part uploads; nil to upload parts synchronously
+	wg      sync.WaitGroup // background part uploads
+
+	mu        sync.Mutex      // guards the fields below, which background part uploads update
+	parts     []completedPart // by part number; zero until the part is uploaded
+	uploadErr error           // the first error of a background part upload
+
+	progress ProgressFunc // called after each upload of data, or nil
+	uploaded int64        // bytes uploaded
+	total    int64        // size of the object, or -1 if unknown
+}
+
+func (w *writer) context() context.Context {
+	if w.ctx != nil {
+		return w.ctx
+	}
+	return context.Background()
+}
+
+type completedPart struct {
+	PartNumber     int
+	ETag           string
+	ChecksumCRC32C string `xml:",omitempty"`
+	ChecksumSHA256 string `xml:",omitempty"`
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, &os.PathError{Op: "write", Path: w.fs.url(w.path), Err: os.ErrClosed}
+	}
+	if max := w.fs.maxObjectSize(); w.size+int64(len(p)) > max {
+		return 0, w.fail(fmt.Errorf("%w: larger than %d bytes (the limit of MaxObjectSize or of %d parts of PartSize)", ErrObjectTooLarge, max, maxUploadParts))
+	}
+	w.size += int64(len(p))
+	for len(p) > 0 {
+		// A full part is only uploaded once there is more data, so that
+		// an object of exactly one part is uploaded with a single PUT.
+		if w.partLen() == w.fs.partSize() {
+			if err := w.flushPart(); err != nil {
+				return n, w.fail(err)
+			}
+		}
+		if w.spill != nil {
+			m := len(p)
+			if rest := w.fs.partSize() - w.spillSize; int64(m) > rest {
+				m = int(rest)
+			}
+			if _, err := w.spill.Write(p[:m]); err != nil {
+				return n, w.fail(err)
+			}
+			w.spillSize += int64(m)
+			n += m
+			p = p[m:]
+			continue
+		}
+		if w.buf == nil {
+			if err := w.fs.acquireWriteBuffer(w.context()); err != nil {
+				return n, w.fail(err)
+			}
+			w.buf = make([]byte, 0, w.bufferSize())
+		}
+		if len(w.buf) == cap(w.buf) {
+			// The part is larger than the SpillThreshold.
+			if err := w.spillBuffer(); err != nil {
+				return n, w.fail(err)
+			}
+			continue
+		}
+
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// bufferSize returns the capacity of the writer's memory buffer: the part
+// size, or the SpillThreshold if it is smaller.
+func (w *writer) bufferSize() int64 {
+	if t := w.fs.opt.SpillThreshold; t > 0 && t < w.fs.partSize() {
+		return t
+	}
+	return w.fs.partSize()
+}
+
+// partLen returns the number of bytes buffered for the current part.
+func (w *writer) partLen() int64 {
+	if w.spill != nil {
+		return w.spillSize
+	}
+	return int64(len(w.buf))
+}
+
+// part returns a reader of the data buffered for the current part.
+func (w *writer) part() io.ReadSeeker {
+	if w.spill != nil {
+		return io.NewSectionReader(w.spill, 0, w.spillSize)
+	}
+	return bytes.NewReader(w.buf)
+}
+
+// spillBuffer moves the data in the memory buffer to a temporary file in
+// Options.TempDir, which holds the rest of the upload's parts, and releases
+// the write buffer.
+func (w *writer) spillBuffer() error {
+	f, err := ioutil.TempFile(w.fs.opt.TempDir, "s3vfs-upload-")
+	if err != nil {
+		return err
+	}
+	w.spill = f
+	if _, err := f.Write(w.buf); err != nil {
+		return err
+	}
+	w.spillSize = int64(len(w.buf))
+	w.buf = nil
+	w.fs.releaseWriteBuffer()
+	return nil
+}
+
+// removeSpill removes the writer's temporary file, if any.
+func (w *writer) removeSpill() {
+	if w.spill != nil {
+		w.spill.Close()
+		os.Remove(w.spill.Name())
+		w.spill = nil
+	}
+}
+
+// flushPart uploads the buffered data as the next part of a multipart upload
+// (initiating it if needed) and releases the write buffer, or empties the
+// temporary file if the part was spilled to disk.
+func (w *writer) flushPart() error {
+	if w.uploads != nil && w.spill == nil {
+		return w.flushPartAsync()
+	}
+	if err := w.uploadPart(w.part(), w.partLen()); err != nil {
+		return err
+	}
+	if w.spill != nil {
+		w.spillSize = 0
+		if err := w.spill.Truncate(0); err != nil {
+			return err
+		}
+		_, err := w.spill.Seek(0, io.SeekStart)
+		return err
+	}
+	w.buf = nil
+	w.fs.releaseWriteBuffer()
+	return nil
+}
+
+// flushPartAsync starts uploading the data in the memory buffer as the next
+// part of a multipart upload (initiating it if needed) in the background,
+// once fewer than Options.UploadConcurrency parts are being uploaded. The
+// write buffer is released when the upload finishes.
+func (w *writer) flushPartAsync() error {
+	if err := w.backgroundError(); err != nil {
+		return err
+	}
+	num, err := w.startPart(w.part())
+	if err != nil {
+		return err
+	}
+	select {
+	case w.uploads <- struct{}{}:
+	case <-w.context().Done():
+		return w.context().Err()
+	}
+	buf := w.buf
+	w.buf = nil
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		err := w.sendPart(num, bytes.NewReader(buf), int64(len(buf)))
+		w.fs.releaseWriteBuffer()
+		<-w.uploads
+		if err != nil {
+			w.mu.Lock()
+			if w.uploadErr == nil {
+				w.uploadErr = err
+			}
+			w.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// backgroundError returns the first error of the writer's background part
+// uploads so far, if any.
+func (w *writer) backgroundError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.uploadErr
+}
+
+// wait waits for the writer's background part uploads to finish and returns
+// the first error of any of them.
+func (w *writer) wait() error {
+	w.wg.Wait()
+	return w.backgroundError()
+}
+
+// uploadPart uploads the size bytes read from body as the next part of a
+// multipart upload, initiating it if needed.
+func (w *writer) uploadPart(body io.ReadSeeker, size int64) error {
+	num, err := w.startPart(body)
+	if err != nil {
+		return err
+	}
+	return w.sendPart(num, body, size)
+}
+
+// startPart initiates the multipart upload if needed (detecting the
+// Content-Type from body, the first part) and returns the number of the next
+// part.
+func (w *writer) startPart(body io.ReadSeeker) (int, error) {
+	if w.uploadID == "" {
+		if err := w.setContentType(body); err != nil {
+			return 0, err
+		}
+		if err := w.initiate(); err != nil {
+			return 0, err
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.parts = append(w.parts, completedPart{})
+	return len(w.parts), nil
+}
+
+// sendPart uploads the size bytes read from body as part num of the
+// multipart upload.
+func (w *writer) sendPart(num int, body io.ReadSeeker, size int64) error {
+	u := fmt.Sprintf("%s?partNumber=%d&uploadId=%s", w.fs.url(w.path), num, url.QueryEscape(w.uploadID))
+	req, err := http.NewRequestWithContext(w.context(), "PUT", u, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	w.fs.setCustomerKeyHeader(req.Header)
+	if err := setContentMD5(req, body, size); err != nil {
+		return err
+	}
+	sum, err := w.setChecksum(req, body, size)
+	if err != nil {
+		return err
+	}
+	resp, err := w.fs.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return w.fs.newS3Error(resp)
+	}
+	resp.Body.Close()
+	part := completedPart{PartNumber: num, ETag: resp.Header.Get("ETag")}
+	if sum != nil {
+		switch w.checksumAlgorithm {
+		case "CRC32C":
+			part.ChecksumCRC32C = sum()
+		case "SHA256":
+			part.ChecksumSHA256 = sum()
+		}
+	}
+	w.mu.Lock()
+	w.parts[num-1] = part
+	w.mu.Unlock()
+	w.reportProgress(size)
+	return nil
+}
+
+func (w *writer) initiate() error {
+	req, err := http.NewRequestWithContext(w.context(), "POST", w.fs.url(w.path)+"?uploads", nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+	if w.checksumAlgorithm != "" {
+		req.Header.Set("X-Amz-Checksum-Algorithm", w.checksumAlgorithm)
+	}
+	resp, err := w.fs.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return w.fs.newS3Error(resp)
+	}
+	defer resp.Body.Close()
+
+	var result struct{ UploadId string }
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	w.uploadID = result.UploadId
+	return nil
+}
+
+// complete completes the multipart upload. Completing an upload is
+// idempotent, so unlike other POST requests it is retried (with the same
+// upload ID) after a transient failure, including one that S3 reports in the
+// body of a 200 response, as described by Options.MaxRetries.
+//
+// If a retry fails with NoSuchUpload, an earlier attempt may have completed
+// the upload even though its response was lost, so the upload is considered
+// complete if the object exists.
+func (w *writer) complete() error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	backoff := w.fs.opt.RetryBaseDelay
+	if backoff <= 0 {
+		backoff = defaultRetryBaseDelay
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := w.completeOnce(body)
+		if attempt > 0 && isNoSuchUpload(err) {
+			if resp, herr := w.fs.headContext(w.context(), w.path); herr == nil {
+				resp.Body.Close()
+				return nil
+			}
+		}
+		if err == nil || !isTransient(err) || attempt >= w.fs.maxRetries() {
+			return err
+		}
+		delay := jitter(backoff)
+		w.fs.onRetry(req, nil, err, attempt+1, delay)
+		select {
+		case <-time.After(delay):
+		case <-w.context().Done():
+			return w.context().Err()
+		case <-w.fs.closed:
+			return ErrClosed
+		}
+		backoff *= 2
+	}
+}
+
+// completeOnce sends a CompleteMultipartUpload request with the given body,
+// and returns the request and its error.
+func (w *writer) completeOnce(body []byte) (*http.Request, error) {
+	u := w.fs.url(w.path) + "?uploadId=" + url.QueryEscape(w.uploadID)
+	req, err := http.NewRequestWithContext(w.context(), "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if w.exclusive {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := w.fs.do(req)
+	if err != nil {
+		return req, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return req, w.fs.checkOKResponse(resp)
+	}
+	return req, w.checkResponse(resp)
+}
+
+// isTransient reports whether err, the error of a request, is a failure that
+// may not recur if the request is retried: a network error, or an S3 error
+// that means the service is overloaded or failed internally.
+func isTransient(err error) bool {
+	var e *S3Error
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusTooManyRequests:
+			return true
+		}
+		return e.Code == "InternalError" || e.Code == "SlowDown" || e.Code == "ServiceUnavailable"
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// isNoSuchUpload reports whether err is S3's error for a request for a
+// multipart upload that does not exist (e.g., because it was completed).
+func isNoSuchUpload(err error) bool {
+	var e *S3Error
+	return errors.As(err, &e) && e.Code == "NoSuchUpload"
+}
+
+// abort aborts the multipart upload (if any) so that S3 discards the parts
+// that were already uploaded.
+func (w *writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	u := w.fs.url(w.path) + "?uploadId=" + url.QueryEscape(w.uploadID)
+	req, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := w.fs.do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// put uploads the buffered data as the whole object with a single PUT.
+func (w *writer) put() error {
+	return w.putBody(w.part(), w.partLen())
+}
+
+// putBody uploads the size bytes read from body as the whole object with a
+// single PUT.
+func (w *writer) putBody(body io.ReadSeeker, size int64) error {
+	if err := w.setContentType(body); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(w.context(), "PUT", w.fs.url(w.path), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+	if err := setContentMD5(req, body, size); err != nil {
+		return err
+	}
+	if _, err := w.setChecksum(req, body, size); err != nil {
+		return err
+	}
+	if w.exclusive {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := w.fs.do(req)
+	if err != nil {
+		return err
+	}
+	if err := w.checkResponse(resp); err != nil {
+		return err
+	}
+	w.reportProgress(size)
+	return nil
+}
+
+// checkResponse returns the error (if any) of the response to the request
+// that creates the object, which is os.ErrExist if an exclusive creation's
+// precondition failed.
+func (w *writer) checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body.Close()
+	case resp.StatusCode == http.StatusPreconditionFailed && w.exclusive:
+		resp.Body.Close()
+		return os.ErrExist
+	default:
+		return w.fs.newS3Error(resp)
+	}
+}
+
+// setContentType sets the Content-Type header of the object, if the writer
+// should detect it, from the extension of its path or else by sniffing the
+// data read from body, which is then rewound.
+func (w *writer) setContentType(body io.ReadSeeker) error {
+	if !w.detectContentType {
+		return nil
+	}
+	w.detectContentType = false
+	if ct := mime.TypeByExtension(pathpkg.Ext(w.path)); ct != "" {
+		w.header.Set("Content-Type", ct)
+		return nil
+	}
+
+	pos, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	sample, err := ioutil.ReadAll(io.LimitReader(body, 512))
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	if len(sample) > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(sample))
+	}
+	return nil
+}
+
+// fail aborts the upload, releases the write buffer (and removes the
+// temporary file, if any), and records err as the writer's sticky error.
+func (w *writer) fail(err error) error {
+	w.wait()
+	w.abort()
+	if w.buf != nil {
+		w.buf = nil
+		w.fs.releaseWriteBuffer()
+	}
+	w.removeSpill()
+	w.err = &os.PathError{Op: "create", Path: w.fs.url(w.path), Err: err}
+	return w.err
+}
+
+// Abort discards the data written so far without creating or modifying
+// the object, and aborts the multipart upload (if any). Write and Close
+// fail after Abort. It returns an error if the writer was already closed.
+func (w *writer) Abort() error {
+	if w.closed && w.err == nil {
+		return &os.PathError{Op: "abort", Path: w.fs.url(w.path), Err: os.ErrClosed}
+	}
+	w.closed = true
+	if w.err == nil {
+		w.fail(errAborted)
+	}
+	return nil
+}
+
+// errAborted is the error of a writer's Write and Close after Abort.
+var errAborted = errors.New("s3vfs: upload aborted")
+
+func (w *writer) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.closed = true
+
+	multipart := w.uploadID != ""
+	if !multipart {
+		if err := w.put(); err != nil {
+			return w.fail(err)
+		}
+	} else {
+		if w.partLen() > 0 {
+			if err := w.flushPart(); err != nil {
+				return w.fail(err)
+			}
+		}
+		if err := w.wait(); err != nil {
+			return w.fail(err)
+		}
+		if err := w.complete(); err != nil {
+			return w.fail(err)
+		}
+	}
+	if w.buf != nil {
+		w.buf = nil
+		w.fs.releaseWriteBuffer()
+	}
+	w.removeSpill()
+
+	if multipart && w.fs.opt.PostUploadVerify > 0 {
+		return w.waitVisible(w.fs.opt.PostUploadVerify)
+	}
+	return nil
+}
+
+// waitVisible polls the object with HEAD requests (with exponential backoff)
+// until it exists, the timeout elapses, or the writer's context is done.
+func (w *writer) waitVisible(timeout time.Duration) error {
+	fs, path := w.fs, w.path
+	deadline := time.Now().Add(timeout)
+	backoff := 25 * time.Millisecond
+	for {
+		req, err := http.NewRequestWithContext(w.context(), "HEAD", fs.url(path), nil)
+		if err != nil {
+			return err
+		}
+		fs.setCustomerKeyHeader(req.Header)
+		resp, err := fs.do(req)
+		if err != nil {
+			return &os.PathError{Op: "create", Path: fs.url(path), Err: err}
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return &os.PathError{Op: "create", Path: fs.url(path), Err: fs.newS3Error(resp)}
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return &os.PathError{Op: "create", Path: fs.url(path), Err: fmt.Errorf("object not visible %s after upload", timeout)}
+		}
+		select {
+		case <-time.After(backoff):
+		case <-w.context().Done():
+			return &os.PathError{Op: "create", Path: fs.url(path), Err: w.context().Err()}
+		}
+		if backoff *= 2; backoff > time.Second {
+			backoff = time.Second
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package s3vfs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		data      []byte
+		wantPuts  int // PUT requests (single or part uploads)
+		multipart bool
+	}{
+		"empty":     {nil, 1, false},
+		"small":     {[]byte("abc"), 1, false},
+		"one part":  {bytes.Repeat([]byte("a"), 10), 1, false},
+		"multipart": {bytes.Repeat([]byte("abcdefg"), 4), 3, true},
+	}
+	for name, test := range tests {
+		f.reset()
+		createFile(t, fs, name, test.data)
+
+		if o, ok := f.get(name); !ok || !bytes.Equal(o.data, test.data) {
+			t.Errorf("%s: object not written correctly", name)
+		}
+		var puts int
+		var multipart bool
+		for _, req := range f.received() {
+			if req.Method == "PUT" {
+				puts++
+			}
+			if req.URL.Query().Get("uploadId") != "" {
+				multipart = true
+			}
+		}
+		if puts != test.wantPuts {
+			t.Errorf("%s: got %d PUTs, want %d", name, puts, test.wantPuts)
+		}
+		if multipart != test.multipart {
+			t.Errorf("%s: got multipart %v, want %v", name, multipart, test.multipart)
+		}
+	}
+}
+
+func TestWriter_abort(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := fs.Create("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("a"), 15)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fail the upload of the last part.
+	f.mu.Lock()
+	f.slowDowns = 1
+	f.mu.Unlock()
+	if err := w.Close(); err == nil {
+		t.Fatal("Close: got nil error")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.uploads) != 0 {
+		t.Errorf("got %d multipart uploads left behind, want none", len(f.uploads))
+	}
+	if _, ok := f.objects["f"]; ok {
+		t.Error("object created despite failed upload")
+	}
+}
+
+func TestWriter_MaxWriteBufferBytes(t *testing.T) {
+	f := newFakeS3(t)
+	if _, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10, MaxWriteBufferBytes: 5}); err == nil {
+		t.Error("New with MaxWriteBufferBytes < PartSize: got nil error")
+	}
+
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10, MaxWriteBufferBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// w1 holds the only write buffer until it is closed.
+	w1, err := fs.Create("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w1, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	w2, err := fs.Create("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := io.WriteString(w2, "def")
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("second writer's Write did not block while the buffer budget was exhausted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := w1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second writer's Write still blocked after first writer closed")
+	}
+	if err := w2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10, DetectContentType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 20)...)
+	tests := map[string]struct {
+		data []byte
+		opt  *WriteOptions
+		want string
+	}{
+		"index.html":    {[]byte("<p>hi</p>"), nil, "text/html; charset=utf-8"},
+		"image":         {png[:9], nil, "image/png"},
+		"image-big":     {png, nil, "image/png"}, // multipart
+		"empty":         {nil, nil, ""},
+		"overridden.js": {[]byte("x"), &WriteOptions{ContentType: "text/x-custom"}, "text/x-custom"},
+	}
+	for name, test := range tests {
+		w, _ := fs.CreateWithOptions(name, test.opt)
+		w.Write(test.data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if o, _ := f.get(name); o.header.Get("Content-Type") != test.want {
+			t.Errorf("%s: got Content-Type %q, want %q", name, o.header.Get("Content-Type"), test.want)
+		}
+	}
+
+	// Without the option, no Content-Type is set.
+	createFile(t, f.fs(), "plain.html", []byte("<p>hi</p>"))
+	if o, _ := f.get("plain.html"); o.header.Get("Content-Type") != "" {
+		t.Errorf("got Content-Type %q without DetectContentType", o.header.Get("Content-Type"))
+	}
+}
+
+func TestStorageClass(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10, StorageClass: "STANDARD_IA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		data []byte
+		opt  *WriteOptions
+		want string
+	}{
+		"small":      {[]byte("x"), nil, "STANDARD_IA"},
+		"big":        {bytes.Repeat([]byte("x"), 25), nil, "STANDARD_IA"}, // multipart
+		"overridden": {[]byte("x"), &WriteOptions{StorageClass: "GLACIER"}, "GLACIER"},
+	}
+	for name, test := range tests {
+		w, err := fs.CreateWithOptions(name, test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(test.data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if o, _ := f.get(name); o.header.Get("X-Amz-Storage-Class") != test.want {
+			t.Errorf("%s: got storage class %q, want %q", name, o.header.Get("X-Amz-Storage-Class"), test.want)
+		}
+	}
+
+	if _, err := fs.CreateWithOptions("bad", &WriteOptions{StorageClass: "COLD"}); err == nil {
+		t.Error("got no error for unknown storage class")
+	}
+	if _, err := New(f.bucketURL(), f.config(), &Options{StorageClass: "COLD"}); err == nil {
+		t.Error("New: got no error for unknown storage class")
+	}
+}
+
+func TestACL(t *testing.T) {
+	f := newFakeS3(t)
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 10, ACL: "bucket-owner-full-control"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]struct {
+		data []byte
+		opt  *WriteOptions
+		want string
+	}{
+		"small":      {[]byte("x"), nil, "bucket-owner-full-control"},
+		"big":        {bytes.Repeat([]byte("x"), 25), nil, "bucket-owner-full-control"}, // multipart
+		"overridden": {[]byte("x"), &WriteOptions{ACL: "public-read"}, "public-read"},
+	}
+	for name, test := range tests {
+		f.reset()
+		w, err := fs.CreateWithOptions(name, test.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(test.data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// The ACL is sent with the PUT or with the request that initiates
+		// the multipart upload.
+		if r := f.received()[0]; r.Header.Get("X-Amz-Acl") != test.want {
+			t.Errorf("%s: %s request got ACL %q, want %q", name, r.Method, r.Header.Get("X-Amz-Acl"), test.want)
+		}
+	}
+
+	f.reset()
+	if _, err := fs.CreateWithOptions("bad", &WriteOptions{ACL: "everyone"}); err == nil {
+		t.Error("got no error for unknown ACL")
+	}
+	if err := fs.PutFile("bad", "/dev/null", &WriteOptions{ACL: "everyone"}); err == nil {
+		t.Error("PutFile: got no error for unknown ACL")
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("got %d requests for unknown ACL, want none", n)
+	}
+	if _, err := New(f.bucketURL(), f.config(), &Options{ACL: "everyone"}); err == nil {
+		t.Error("New: got no error for unknown ACL")
+	}
+}
+
+func TestWriter_MaxObjectSize(t *testing.T) {
+	defer func(n int64) { maxUploadParts = n }(maxUploadParts)
+	maxUploadParts = 3
+
+	f := newFakeS3(t)
+	for _, opt := range []*Options{
+		{PartSize: 5, MaxObjectSize: 12},
+		{PartSize: 2, MaxObjectSize: 20}, // limited to 3 parts
+	} {
+		fs, err := New(f.bucketURL(), f.config(), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		max := fs.maxObjectSize()
+		w, err := fs.Create("big")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(make([]byte, max)); err != nil {
+			t.Fatalf("writing %d bytes: %s", max, err)
+		}
+		if _, err := w.Write([]byte("x")); !errors.Is(err, ErrObjectTooLarge) {
+			t.Errorf("writing past %d bytes: got error %v, want ErrObjectTooLarge", max, err)
+		}
+		if err := w.Close(); !errors.Is(err, ErrObjectTooLarge) {
+			t.Errorf("Close: got error %v, want ErrObjectTooLarge", err)
+		}
+		if _, ok := f.get("big"); ok {
+			t.Error("object was created")
+		}
+	}
+}
+
+func TestWriter_spill(t *testing.T) {
+	f := newFakeS3(t)
+	dir := t.TempDir()
+	fs, err := New(f.bucketURL(), f.config(), &Options{PartSize: 20, MaxObjectSize: 50, SpillThreshold: 4, TempDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFiles := func() int {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(fis)
+	}
+
+	for name, data := range map[string][]byte{
+		"small": []byte("abc"), // fits in memory
+		"one":   []byte("0123456789abcdef"),
+		"multi": bytes.Repeat([]byte("0123456789"), 5), // 3 parts
+	} {
+		f.reset()
+		w, err := fs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range data {
+			if _, err := w.Write([]byte{b}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := 1
+		if len(data) <= 4 {
+			want = 0
+		}
+		if n := tempFiles(); n != want {
+			t.Errorf("%s: got %d temp files before Close, want %d", name, n, want)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := tempFiles(); n != 0 {
+			t.Errorf("%s: got %d temp files after Close, want none", name, n)
+		}
+		if o, ok := f.get(name); !ok || !bytes.Equal(o.data, data) {
+			t.Errorf("%s: got object %v, want data %q", name, o, data)
+		}
+	}
+
+	// The temp file is removed when the upload fails.
+	w, err := fs.Create("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 10))
+	if _, err := w.Write(make([]byte, 41)); !errors.Is(err, ErrObjectTooLarge) {
+		t.Errorf("got error %v, want ErrObjectTooLarge", err)
+	}
+	if n := tempFiles(); n != 0 {
+		t.Errorf("got %d temp files after failure, want none", n)
+	}
+}
+
+func TestWriter_completeErrorBody(t *testing.T) {
+	f := newFakeS3(t)
+	var completes int
+	code := "InternalError"
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == "POST" && r.URL.Query().Get("uploadId") != "" {
+			if completes++; completes == 1 {
+				// S3 sends the 200 status before it knows the outcome.
+				rec := httptest.NewRecorder()
+				rec.WriteHeader(http.StatusOK)
+				fmt.Fprintf(rec, "%s<Error><Code>%s</Code><Message>failed</Message></Error>", xml.Header, code)
+				return rec.Result(), nil
+			}
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	fs, err := New(f.bucketURL(), config, &Options{PartSize: 5, RetryBaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A transient error is retried with the same upload.
+	createFile(t, fs, "a", []byte("0123456789ab"))
+	if completes != 2 {
+		t.Errorf("got %d complete requests, want 2", completes)
+	}
+	if o, ok := f.get("a"); !ok || string(o.data) != "0123456789ab" {
+		t.Errorf("got object %v", o)
+	}
+
+	// Other errors abort the upload.
+	completes, code = 0, "InvalidPart"
+	f.reset()
+	w, _ := fs.Create("b")
+	w.Write([]byte("0123456789ab"))
+	var e *S3Error
+	if err := w.Close(); !errors.As(err, &e) || e.Code != "InvalidPart" {
+		t.Errorf("got error %v, want InvalidPart", err)
+	}
+	if completes != 1 {
+		t.Errorf("got %d complete requests, want 1", completes)
+	}
+	if _, ok := f.get("b"); ok {
+		t.Error("object created")
+	}
+	var aborted bool
+	for _, r := range f.received() {
+		aborted = aborted || r.Method == "DELETE"
+	}
+	if !aborted {
+		t.Error("upload not aborted")
+	}
+}
+
+func TestWriter_completeResponseLost(t *testing.T) {
+	f := newFakeS3(t)
+	var completes int
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if r.Method == "POST" && r.URL.Query().Get("uploadId") != "" {
+			if completes++; completes == 1 && err == nil {
+				// S3 completes the upload, but the connection drops before
+				// the response arrives.
+				resp.Body.Close()
+				return nil, errors.New("connection reset by peer")
+			}
+		}
+		return resp, err
+	})}
+	fs, err := New(f.bucketURL(), config, &Options{PartSize: 5, RetryBaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createFile(t, fs, "a", []byte("0123456789ab"))
+	if completes != 2 {
+		t.Errorf("got %d complete requests, want 2", completes)
+	}
+	if o, ok := f.get("a"); !ok || string(o.data) != "0123456789ab" {
+		t.Errorf("got object %v", o)
+	}
+}
+
+func TestWriter_UploadConcurrency(t *testing.T) {
+	f := newFakeS3(t)
+	var (
+		mu                 sync.Mutex
+		inFlight, maxParts int
+		failPart           string
+	)
+	config := f.config()
+	config.Client = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		num := r.URL.Query().Get("partNumber")
+		if num == "" {
+			return http.DefaultTransport.RoundTrip(r)
+		}
+		mu.Lock()
+		if inFlight++; inFlight > maxParts {
+			maxParts = inFlight
+		}
+		fail := num == failPart
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if fail {
+			rec := httptest.NewRecorder()
+			fakeError(rec, http.StatusForbidden, "AccessDenied")
+			return rec.Result(), nil
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	fs, err := New(f.bucketURL(), config, &Options{PartSize: 4, UploadConcurrency: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("0000111122223333444455556666777788889")
+	createFile(t, fs, "f", data)
+	if o, ok := f.get("f"); !ok || !bytes.Equal(o.data, data) {
+		t.Errorf("object not written correctly")
+	}
+	if maxParts != 3 {
+		t.Errorf("got at most %d concurrent part uploads, want 3", maxParts)
+	}
+
+	// A failed background upload fails the writer and aborts the upload.
+	mu.Lock()
+	failPart = "2"
+	mu.Unlock()
+	w, err := fs.Create("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err == nil {
+		t.Fatal("Close: got nil error")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.uploads) != 0 {
+		t.Errorf("got %d multipart uploads left behind, want none", len(f.uploads))
+	}
+	if _, ok := f.objects["g"]; ok {
+		t.Error("object created despite failed upload")
+	}
+}